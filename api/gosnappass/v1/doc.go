@@ -0,0 +1,5 @@
+// Package gosnappassv1 contains the gRPC service for sharing secrets, generated from
+// secrets.proto.
+package gosnappassv1
+
+//go:generate protoc -I ../.. --go_out=../.. --go_opt=paths=source_relative --go-grpc_out=../.. --go-grpc_opt=paths=source_relative gosnappass/v1/secrets.proto
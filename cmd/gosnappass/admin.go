@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/concerthall/gosnappass/internal/config"
+	"github.com/concerthall/gosnappass/internal/server"
+	"github.com/concerthall/gosnappass/pkg/client"
+)
+
+const adminUsage = `usage: gosnappass admin <command> [flags]
+
+Operate the deployment configured by the environment, directly against its
+database. Secrets are never printed.
+
+commands:
+  stats          count the active secrets and their remaining TTLs
+  purge -yes     delete every secret
+  revoke <id>    delete a secret, given its ID or link
+  check          check the configuration and the database
+  bans           list the clients banned for probing for secrets
+  unban <client> lift the ban of a client
+`
+
+// adminCommand runs the admin subcommand with args, returning the exit code.
+func adminCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, adminUsage)
+		return 2
+	}
+
+	fs := flag.NewFlagSet("admin "+args[0], flag.ContinueOnError)
+	ctx := context.Background()
+	keyPrefix := server.DefaultRedisKeyPrefix
+	if val, isSet := os.LookupEnv(config.EnvRedisPrefix); isSet {
+		keyPrefix = val
+	}
+
+	switch args[0] {
+	case "stats":
+		if err := fs.Parse(args[1:]); err != nil {
+			return 2
+		}
+
+		stats, err := server.AdminStats(ctx, keyPrefix)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(tw, "active\t%d\n", stats.Active)
+		fmt.Fprintf(tw, "  pending\t%d\n", stats.Pending)
+		fmt.Fprintf(tw, "  restricted\t%d\n", stats.Restricted)
+		fmt.Fprintf(tw, "  structured\t%d\n", stats.Structured)
+		fmt.Fprintln(tw, "remaining ttl\t")
+		for _, b := range stats.TTLs {
+			if b.Under == 0 {
+				fmt.Fprintf(tw, "  longer\t%d\n", b.Count)
+			} else {
+				fmt.Fprintf(tw, "  under %s\t%d\n", formatTTL(b.Under), b.Count)
+			}
+		}
+		fmt.Fprintln(tw, "gone in the last day\t")
+		for _, reason := range []string{"expired", "revealed", "deleted"} {
+			fmt.Fprintf(tw, "  %s\t%d\n", reason, stats.Gone[reason])
+		}
+		tw.Flush()
+	case "purge":
+		yes := fs.Bool("yes", false, "confirm deleting every secret")
+		if err := fs.Parse(args[1:]); err != nil {
+			return 2
+		}
+
+		if !*yes {
+			fmt.Fprintf(os.Stderr, "purge deletes every secret under the prefix %q, confirm with -yes\n", keyPrefix)
+			return 2
+		}
+
+		n, err := server.AdminPurge(ctx, keyPrefix)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+
+		fmt.Printf("purged %d secrets\n", n)
+	case "revoke":
+		if err := fs.Parse(args[1:]); err != nil {
+			return 2
+		}
+
+		if fs.NArg() != 1 {
+			fmt.Fprint(os.Stderr, adminUsage)
+			return 2
+		}
+
+		// Links and tokens are accepted too, but only their ID is used.
+		id := fs.Arg(0)
+		if linkID, _, err := client.ParseLink(id); err == nil {
+			id = linkID
+		}
+
+		if err := server.AdminRevoke(ctx, keyPrefix, id); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+
+		fmt.Println("revoked", id)
+	case "check":
+		if err := fs.Parse(args[1:]); err != nil {
+			return 2
+		}
+
+		if _, err := serverOptions(); err != nil {
+			fmt.Fprintln(os.Stderr, "configuration:", err)
+			return 1
+		}
+		fmt.Println("configuration: ok")
+
+		if err := server.AdminCheckStorage(ctx, keyPrefix); err != nil {
+			fmt.Fprintln(os.Stderr, "database:", err)
+			return 1
+		}
+		fmt.Println("database: ok")
+	case "bans":
+		if err := fs.Parse(args[1:]); err != nil {
+			return 2
+		}
+
+		bans, err := server.AdminBans(ctx, keyPrefix)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "client\tfailures\tsince\texpires\t")
+		for _, b := range bans {
+			fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t\n", b.Client, b.Failures, b.Since.Format(time.RFC3339), b.Expires.Format(time.RFC3339))
+		}
+		tw.Flush()
+	case "unban":
+		if err := fs.Parse(args[1:]); err != nil {
+			return 2
+		}
+
+		if fs.NArg() != 1 {
+			fmt.Fprint(os.Stderr, adminUsage)
+			return 2
+		}
+
+		if err := server.AdminLiftBan(ctx, keyPrefix, fs.Arg(0)); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+
+		fmt.Println("unbanned", fs.Arg(0))
+	default:
+		fmt.Fprint(os.Stderr, adminUsage)
+		return 2
+	}
+
+	return 0
+}
+
+// formatTTL formats the bound of a TTL bucket in the largest whole unit of weeks,
+// days or hours.
+func formatTTL(d time.Duration) string {
+	const day = 24 * time.Hour
+	switch {
+	case d%(7*day) == 0:
+		return fmt.Sprintf("%dw", d/(7*day))
+	case d%day == 0:
+		return fmt.Sprintf("%dd", d/day)
+	}
+	return fmt.Sprintf("%dh", d/time.Hour)
+}
@@ -1,17 +1,76 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
+	"github.com/concerthall/gosnappass/internal/apitoken"
 	"github.com/concerthall/gosnappass/internal/config"
 	"github.com/concerthall/gosnappass/internal/server"
 )
 
 func main() {
+	// Subcommands manage the deployment, or share and reveal secrets with a server.
+	// Without one, the server runs.
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "token":
+			os.Exit(tokenCommand(os.Args[2:]))
+		case "share":
+			os.Exit(shareCommand(os.Args[2:]))
+		case "get":
+			os.Exit(getCommand(os.Args[2:]))
+		case "admin":
+			os.Exit(adminCommand(os.Args[2:]))
+		}
+	}
+
+	opts, err := serverOptions()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	srv := server.New(opts...)
+
+	// handle OS signals
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	// SIGHUP reloads the TLS certificate, e.g. after it was renewed.
+	reloads := make(chan os.Signal, 1)
+	signal.Notify(reloads, syscall.SIGHUP)
+	go func() {
+		for range reloads {
+			// Failures are logged, and the previous certificate is still served.
+			_ = srv.ReloadCertificates()
+		}
+	}()
+
+	// run the application
+	go func() {
+		if err := srv.Run(); err != nil {
+			fmt.Fprintf(os.Stderr, "The server quit with error: "+err.Error())
+			os.Exit(1)
+		}
+	}()
+
+	s := <-signals
+	fmt.Println("received signal:", s, "-- shutting down")
+	if err := srv.Shutdown(); err != nil {
+		fmt.Println(err)
+		os.Exit(9)
+	}
+}
+
+// serverOptions returns the options of the server configured by the environment.
+func serverOptions() ([]server.ServerOption, error) {
 	serverOptions := []server.ServerOption{}
 
 	if val, isSet := os.LookupEnv(config.EnvHostOverride); isSet {
@@ -26,32 +85,268 @@ func main() {
 		serverOptions = append(serverOptions, server.WithProto("https"))
 	}
 
+	if val := os.Getenv(config.EnvPublicURL); val != "" {
+		for _, env := range []string{config.EnvHostOverride, config.EnvURLPrefix, config.EnvNoSSL} {
+			if _, isSet := os.LookupEnv(env); isSet {
+				return nil, fmt.Errorf("%s replaces %s, set only one of them", config.EnvPublicURL, env)
+			}
+		}
+
+		u, err := server.ParsePublicURL(val)
+		if err != nil {
+			return nil, err
+		}
+		serverOptions = append(serverOptions, server.WithPublicURL(u))
+	}
+
+	if val := os.Getenv(config.EnvAllowedHosts); val != "" {
+		serverOptions = append(serverOptions, server.WithAllowedHosts(splitList(val)))
+	}
+
 	if val, isSet := os.LookupEnv(config.EnvListenString); isSet {
 		serverOptions = append(serverOptions, server.SetListenAddress(val))
 	}
 
+	if certFile, keyFile := os.Getenv(config.EnvTLSCert), os.Getenv(config.EnvTLSKey); certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return nil, fmt.Errorf("serving TLS requires both %s and %s", config.EnvTLSCert, config.EnvTLSKey)
+		}
+
+		certs, err := server.NewCertReloader(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		serverOptions = append(serverOptions, server.WithTLS(certs))
+
+		if val := os.Getenv(config.EnvTLSMinVersion); val != "" {
+			version, err := server.ParseTLSVersion(val)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", config.EnvTLSMinVersion, err)
+			}
+			serverOptions = append(serverOptions, server.WithTLSMinVersion(version))
+		}
+
+		if val := os.Getenv(config.EnvTLSCiphers); val != "" {
+			suites, err := server.ParseCipherSuites(val)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", config.EnvTLSCiphers, err)
+			}
+			serverOptions = append(serverOptions, server.WithTLSCipherSuites(suites))
+		}
+
+		if val := os.Getenv(config.EnvHTTPRedirectAddress); val != "" {
+			serverOptions = append(serverOptions, server.WithHTTPRedirect(val))
+		}
+	} else {
+		for _, env := range []string{config.EnvTLSMinVersion, config.EnvTLSCiphers, config.EnvHTTPRedirectAddress} {
+			if os.Getenv(env) != "" {
+				return nil, fmt.Errorf("%s requires serving TLS with %s and %s", env, config.EnvTLSCert, config.EnvTLSKey)
+			}
+		}
+	}
+
 	if val, isSet := os.LookupEnv(config.EnvRedisPrefix); isSet {
 		serverOptions = append(serverOptions, server.WithRedisKeyPrefix(val))
 	}
 
-	srv := server.New(serverOptions...)
+	if val, isSet := os.LookupEnv(config.EnvLabelMaxLength); isSet {
+		length, err := strconv.Atoi(val)
+		if err != nil || length < 0 {
+			return nil, fmt.Errorf("%s must be a non-negative integer, got %q", config.EnvLabelMaxLength, val)
+		}
+		serverOptions = append(serverOptions, server.WithLabelMaxLength(length))
+	}
 
-	// handle OS signals
-	signals := make(chan os.Signal, 1)
-	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	if val, isSet := os.LookupEnv(config.EnvDisableQRCode); isSet && strings.ToLower(val) == "true" {
+		serverOptions = append(serverOptions, server.WithoutQRCode())
+	}
 
-	// run the application
-	go func() {
-		if err := srv.Run(); err != nil {
-			fmt.Fprintf(os.Stderr, "The server quit with error: "+err.Error())
-			os.Exit(1)
+	if val, isSet := os.LookupEnv(config.EnvLinkStyle); isSet {
+		style := strings.ToLower(val)
+		switch style {
+		case server.LinkStyleLong, server.LinkStyleShort, server.LinkStyleWords:
+			serverOptions = append(serverOptions, server.WithLinkStyle(style))
+		default:
+			return nil, fmt.Errorf("%s must be one of long, short or words, got %q", config.EnvLinkStyle, val)
 		}
-	}()
+	}
 
-	s := <-signals
-	fmt.Println("received signal:", s, "-- shutting down")
-	if err := srv.Shutdown(); err != nil {
-		fmt.Println(err)
-		os.Exit(9)
+	if val, isSet := os.LookupEnv(config.EnvSessionSecret); isSet {
+		serverOptions = append(serverOptions, server.WithSessionSecret(val))
+	}
+
+	headers := server.DefaultSecurityHeaders()
+	for env, header := range map[string]*string{
+		config.EnvContentSecurityPolicy:   &headers.ContentSecurityPolicy,
+		config.EnvReferrerPolicy:          &headers.ReferrerPolicy,
+		config.EnvFrameOptions:            &headers.FrameOptions,
+		config.EnvStrictTransportSecurity: &headers.StrictTransportSecurity,
+		config.EnvRobotsTag:               &headers.RobotsTag,
+		config.EnvCacheControl:            &headers.CacheControl,
+	} {
+		if val, isSet := os.LookupEnv(env); isSet {
+			*header = val
+		}
+	}
+	serverOptions = append(serverOptions, server.WithSecurityHeaders(headers))
+
+	limits := server.DefaultRateLimits()
+	for env, limit := range map[string]*server.RateLimit{
+		config.EnvRateLimitCreate:  &limits.Create,
+		config.EnvRateLimitPreview: &limits.Preview,
+		config.EnvRateLimitReveal:  &limits.Reveal,
+	} {
+		if val, isSet := os.LookupEnv(env); isSet {
+			l, err := server.ParseRateLimit(val)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", env, err)
+			}
+			*limit = l
+		}
+	}
+	serverOptions = append(serverOptions, server.WithRateLimits(limits))
+
+	bans := server.DefaultProbeBans()
+	if val, isSet := os.LookupEnv(config.EnvBanThreshold); isSet {
+		threshold, err := strconv.Atoi(val)
+		if err != nil || threshold < 0 {
+			return nil, fmt.Errorf("%s must be a non-negative integer, got %q", config.EnvBanThreshold, val)
+		}
+		bans.Threshold = threshold
+	}
+	for env, d := range map[string]*time.Duration{
+		config.EnvBanWindow:   &bans.Window,
+		config.EnvBanDuration: &bans.Duration,
+	} {
+		if val, isSet := os.LookupEnv(env); isSet {
+			parsed, err := time.ParseDuration(val)
+			if err != nil || parsed < time.Second {
+				return nil, fmt.Errorf("%s must be a duration of at least 1s, got %q", env, val)
+			}
+			*d = parsed
+		}
+	}
+	serverOptions = append(serverOptions, server.WithProbeBans(bans))
+
+	if val := os.Getenv(config.EnvMetricsListenAddress); val != "" {
+		serverOptions = append(serverOptions, server.WithMetrics(val))
+	}
+
+	if issuer := os.Getenv(config.EnvOIDCIssuer); issuer != "" {
+		provider, err := server.NewOIDCProvider(context.Background(), server.OIDCConfig{
+			IssuerURL:    issuer,
+			ClientID:     os.Getenv(config.EnvOIDCClientID),
+			ClientSecret: os.Getenv(config.EnvOIDCClientSecret),
+			RedirectURL:  os.Getenv(config.EnvOIDCRedirectURL),
+			GroupsClaim:  os.Getenv(config.EnvOIDCGroupsClaim),
+		})
+		if err != nil {
+			return nil, err
+		}
+		serverOptions = append(serverOptions, server.WithOIDCProvider(provider))
+	}
+
+	proxyAuth := false
+	if val := os.Getenv(config.EnvTrustedProxies); val != "" {
+		networks, err := server.ParseNetworks(val)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", config.EnvTrustedProxies, err)
+		}
+		serverOptions = append(serverOptions, server.WithTrustedProxies(networks))
+
+		if val, isSet := os.LookupEnv(config.EnvProxyAuth); isSet && strings.ToLower(val) == "true" {
+			proxyAuth = true
+			serverOptions = append(serverOptions, server.WithProxyAuth())
+		}
+	} else if val, isSet := os.LookupEnv(config.EnvProxyAuth); isSet && strings.ToLower(val) == "true" {
+		return nil, fmt.Errorf("%s requires %s", config.EnvProxyAuth, config.EnvTrustedProxies)
+	}
+
+	if val, isSet := os.LookupEnv(config.EnvCreatorLogin); isSet && strings.ToLower(val) == "true" {
+		if os.Getenv(config.EnvOIDCIssuer) == "" && !proxyAuth {
+			return nil, fmt.Errorf("%s requires %s or %s", config.EnvCreatorLogin, config.EnvOIDCIssuer, config.EnvProxyAuth)
+		}
+
+		serverOptions = append(serverOptions, server.WithCreatorLogin(server.CreatorPolicy{
+			Groups:  splitList(os.Getenv(config.EnvCreatorGroups)),
+			Domains: splitList(os.Getenv(config.EnvCreatorDomains)),
+		}))
+	}
+
+	if path := os.Getenv(config.EnvAPITokensFile); path != "" {
+		store, err := apitoken.NewStore(path)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load API tokens: %w", err)
+		}
+		serverOptions = append(serverOptions, server.WithAPITokens(store))
+	}
+
+	if path := os.Getenv(config.EnvJWTIssuersFile); path != "" {
+		issuers, err := server.LoadJWTIssuers(path)
+		if err != nil {
+			return nil, err
+		}
+
+		authenticator, err := server.NewJWTAuthenticator(context.Background(), issuers)
+		if err != nil {
+			return nil, err
+		}
+		serverOptions = append(serverOptions, server.WithJWTAuthenticator(authenticator))
+	}
+
+	if caFile := os.Getenv(config.EnvAPIClientCA); caFile != "" {
+		if os.Getenv(config.EnvTLSCert) == "" {
+			return nil, fmt.Errorf("%s requires serving TLS with %s and %s", config.EnvAPIClientCA, config.EnvTLSCert, config.EnvTLSKey)
+		}
+
+		rules := []server.ClientCertRule{}
+		if path := os.Getenv(config.EnvAPIClientCertRulesFile); path != "" {
+			loaded, err := server.LoadClientCertRules(path)
+			if err != nil {
+				return nil, err
+			}
+			rules = loaded
+		}
+
+		authenticator, err := server.NewClientCertAuthenticator(caFile, rules)
+		if err != nil {
+			return nil, err
+		}
+		serverOptions = append(serverOptions, server.WithClientCertAuthenticator(authenticator))
+
+		if val, isSet := os.LookupEnv(config.EnvAPIClientCertRequired); isSet && strings.ToLower(val) == "true" {
+			serverOptions = append(serverOptions, server.WithClientCertRequired())
+		}
+	} else {
+		for _, env := range []string{config.EnvAPIClientCertRulesFile, config.EnvAPIClientCertRequired} {
+			if os.Getenv(env) != "" {
+				return nil, fmt.Errorf("%s requires %s", env, config.EnvAPIClientCA)
+			}
+		}
+	}
+
+	if address := os.Getenv(config.EnvGRPCListenAddress); address != "" {
+		tlsConfig, err := server.MutualTLSConfig(
+			os.Getenv(config.EnvGRPCTLSCert),
+			os.Getenv(config.EnvGRPCTLSKey),
+			os.Getenv(config.EnvGRPCClientCA),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("the gRPC service requires mutual TLS: %w", err)
+		}
+		serverOptions = append(serverOptions, server.WithGRPC(address, tlsConfig))
+	}
+
+	return serverOptions, nil
+}
+
+// splitList returns the non-empty entries of the comma separated list s.
+func splitList(s string) []string {
+	list := []string{}
+	for _, entry := range strings.Split(s, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			list = append(list, entry)
+		}
 	}
+	return list
 }
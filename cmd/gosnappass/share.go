@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/concerthall/gosnappass/internal/config"
+	"github.com/concerthall/gosnappass/pkg/client"
+	"golang.org/x/term"
+)
+
+const shareUsage = `usage: gosnappass share [flags] [file]
+
+Share a secret and print its link. The secret is read from file, from standard
+input when it isn't a terminal, or else from a hidden prompt.
+
+flags:
+`
+
+const getUsage = `usage: gosnappass get [flags] <link>
+
+Reveal the secret of link and print it. Revealing deletes the secret.
+
+flags:
+`
+
+// maxSecretSize bounds the secrets read by the share command. The server enforces
+// its own, smaller limit.
+const maxSecretSize = 1 << 20
+
+// shareCommand runs the share subcommand with args, returning the exit code.
+func shareCommand(args []string) int {
+	fs := flag.NewFlagSet("share", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, shareUsage)
+		fs.PrintDefaults()
+	}
+	serverURL := fs.String("server", os.Getenv(config.EnvClientServerURL), "URL of the server, defaults to $"+config.EnvClientServerURL)
+	ttl := fs.Duration("ttl", 0, "how long the secret can be revealed for, e.g. 1h, defaults to the server's default")
+	label := fs.String("label", "", "public description shown before the secret is revealed")
+	if err := fs.Parse(args); err != nil || fs.NArg() > 1 {
+		fs.Usage()
+		return 2
+	}
+
+	if *serverURL == "" {
+		fmt.Fprintf(os.Stderr, "a server URL is required, set -server or $%s\n", config.EnvClientServerURL)
+		return 2
+	}
+
+	c, err := newClient(*serverURL, true)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	secret, err := readSecret(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	link, err := c.Share(context.Background(), secret, client.ShareOptions{TTL: *ttl, Label: *label})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	fmt.Println(link)
+	return 0
+}
+
+// getCommand runs the get subcommand with args, returning the exit code.
+func getCommand(args []string) int {
+	fs := flag.NewFlagSet("get", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, getUsage)
+		fs.PrintDefaults()
+	}
+	serverURL := fs.String("server", os.Getenv(config.EnvClientServerURL), "URL of the server, defaults to $"+config.EnvClientServerURL+", or else to the server of the link")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	// The token is only sent to the configured server, never to the server of a link,
+	// which anyone could have sent.
+	link := fs.Arg(0)
+	configured := *serverURL != ""
+	if !configured {
+		*serverURL = linkServerURL(link)
+	}
+
+	if *serverURL == "" {
+		fmt.Fprintf(os.Stderr, "a server URL is required for tokens, set -server or $%s\n", config.EnvClientServerURL)
+		return 2
+	}
+
+	c, err := newClient(*serverURL, configured)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	secret, err := c.Reveal(context.Background(), link)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if secret.Fields == nil {
+		fmt.Println(secret.Text)
+		return 0
+	}
+
+	for _, f := range secret.Fields {
+		fmt.Printf("%s: %s\n", f.Name, f.Value)
+	}
+	return 0
+}
+
+// newClient returns a client of the server at serverURL. If withToken is set, it is
+// authenticated with the API token in the environment, if any.
+func newClient(serverURL string, withToken bool) (*client.Client, error) {
+	opts := []client.Option{}
+	if token := os.Getenv(config.EnvClientToken); withToken && token != "" {
+		opts = append(opts, client.WithBearerToken(token))
+	}
+
+	return client.New(serverURL, opts...)
+}
+
+// linkServerURL returns the URL of the server that produced link: the link without
+// its last path segment. It returns an empty string if link is only a token.
+func linkServerURL(link string) string {
+	u, err := url.Parse(link)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return ""
+	}
+
+	u.Path = u.Path[:strings.LastIndex(u.Path, "/")+1]
+	u.RawPath, u.RawQuery, u.Fragment = "", "", ""
+	return u.String()
+}
+
+// readSecret reads the secret from the file at path if it is set, from standard input
+// when it isn't a terminal, or else from a hidden prompt. A trailing newline is
+// removed.
+func readSecret(path string) (string, error) {
+	var r io.Reader = os.Stdin
+	switch {
+	case path != "":
+		f, err := os.Open(path)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		r = f
+	case term.IsTerminal(int(os.Stdin.Fd())):
+		fmt.Fprint(os.Stderr, "Secret: ")
+		b, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", err
+		}
+		r = strings.NewReader(string(b))
+	}
+
+	b, err := io.ReadAll(io.LimitReader(r, maxSecretSize+1))
+	if err != nil {
+		return "", err
+	}
+
+	if len(b) > maxSecretSize {
+		return "", errors.New("the secret is too large")
+	}
+
+	secret := strings.TrimSuffix(strings.TrimSuffix(string(b), "\n"), "\r")
+	if secret == "" {
+		return "", errors.New("the secret is empty")
+	}
+
+	return secret, nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/concerthall/gosnappass/internal/config"
+)
+
+func TestGetSendsTokenOnlyToConfiguredServer(t *testing.T) {
+	var authorization string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"id": "abc", "secret": "hunter2"})
+	}))
+	defer ts.Close()
+
+	link := ts.URL + "/snappassabc~a2V5"
+	tests := []struct {
+		name      string
+		serverURL string
+		args      []string
+		want      string
+	}{
+		{"server of the link", "", []string{link}, ""},
+		{"server flag", "", []string{"-server", ts.URL, link}, "Bearer s3cr3t"},
+		{"server in the environment", ts.URL, []string{link}, "Bearer s3cr3t"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(config.EnvClientToken, "s3cr3t")
+			t.Setenv(config.EnvClientServerURL, tt.serverURL)
+			authorization = ""
+
+			if code := getCommand(tt.args); code != 0 {
+				t.Fatalf("got exit code %d", code)
+			}
+			if authorization != tt.want {
+				t.Errorf("got Authorization %q, want %q", authorization, tt.want)
+			}
+		})
+	}
+}
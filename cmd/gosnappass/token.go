@@ -0,0 +1,99 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/concerthall/gosnappass/internal/apitoken"
+	"github.com/concerthall/gosnappass/internal/config"
+)
+
+const tokenUsage = `usage: gosnappass token <command> [flags]
+
+Manage the API tokens in the tokens file. The server picks up changes without a
+restart.
+
+commands:
+  mint    create a token and print it
+  revoke  delete a token
+  list    list tokens
+`
+
+// tokenCommand runs the token subcommand with args, returning the exit code.
+func tokenCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, tokenUsage)
+		return 2
+	}
+
+	fs := flag.NewFlagSet("token "+args[0], flag.ContinueOnError)
+	file := fs.String("file", os.Getenv(config.EnvAPITokensFile), "path of the tokens file, defaults to $"+config.EnvAPITokensFile)
+
+	switch args[0] {
+	case "mint":
+		name := fs.String("name", "", "name of the token, recorded in request logs")
+		scopes := fs.String("scopes", "", "comma separated scopes to grant: "+strings.Join(apitoken.Scopes, ", "))
+		if !parseTokenFlags(fs, args[1:], file) {
+			return 2
+		}
+
+		token, err := apitoken.Mint(*file, *name, splitList(*scopes))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+
+		fmt.Fprintln(os.Stderr, "Store this token now, it can't be shown again.")
+		fmt.Println(token)
+	case "revoke":
+		name := fs.String("name", "", "name of the token to revoke")
+		if !parseTokenFlags(fs, args[1:], file) {
+			return 2
+		}
+
+		if err := apitoken.Revoke(*file, *name); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	case "list":
+		if !parseTokenFlags(fs, args[1:], file) {
+			return 2
+		}
+
+		tokens, err := apitoken.List(*file)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "NAME\tSCOPES\tCREATED")
+		for _, t := range tokens {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", t.Name, strings.Join(t.Scopes, ","), t.CreatedAt.Format("2006-01-02 15:04:05"))
+		}
+		tw.Flush()
+	default:
+		fmt.Fprint(os.Stderr, tokenUsage)
+		return 2
+	}
+
+	return 0
+}
+
+// parseTokenFlags parses the flags of a token command, reporting problems to the
+// user. A tokens file is required by every command.
+func parseTokenFlags(fs *flag.FlagSet, args []string, file *string) bool {
+	if err := fs.Parse(args); err != nil {
+		return false
+	}
+
+	if *file == "" {
+		fmt.Fprintf(os.Stderr, "a tokens file is required, set -file or $%s\n", config.EnvAPITokensFile)
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/concerthall/gosnappass/internal/apitoken"
+	"github.com/concerthall/gosnappass/internal/config"
+)
+
+// captureStdout returns what f prints to stdout, and its exit code.
+func captureStdout(t *testing.T, f func() int) (string, int) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdout := os.Stdout
+	os.Stdout = w
+	code := f()
+	os.Stdout = stdout
+	w.Close()
+
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b), code
+}
+
+func TestTokenCommand(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "tokens.json")
+	t.Setenv(config.EnvAPITokensFile, file)
+
+	out, code := captureStdout(t, func() int {
+		return tokenCommand([]string{"mint", "-name", "ci", "-scopes", "create, reveal"})
+	})
+	if code != 0 {
+		t.Fatalf("minting: got exit code %d", code)
+	}
+
+	store, err := apitoken.NewStore(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token, ok := store.Verify(strings.TrimSpace(out)); !ok || token.Name != "ci" || strings.Join(token.Scopes, ",") != "create,reveal" {
+		t.Errorf("the printed token %q doesn't verify: got %+v, %t", out, token, ok)
+	}
+
+	out, code = captureStdout(t, func() int { return tokenCommand([]string{"list"}) })
+	if code != 0 || !strings.Contains(out, "ci") || !strings.Contains(out, "create,reveal") {
+		t.Errorf("listing: got exit code %d, output %q", code, out)
+	}
+
+	other := filepath.Join(t.TempDir(), "tokens.json")
+	if _, code := captureStdout(t, func() int { return tokenCommand([]string{"mint", "-file", other, "-name", "ops", "-scopes", "admin"}) }); code != 0 {
+		t.Errorf("minting into -file: got exit code %d", code)
+	}
+	if tokens, err := apitoken.List(other); err != nil || len(tokens) != 1 || tokens[0].Name != "ops" {
+		t.Errorf("minting into -file: got tokens %+v, %v", tokens, err)
+	}
+
+	if _, code := captureStdout(t, func() int { return tokenCommand([]string{"revoke", "-name", "ci"}) }); code != 0 {
+		t.Errorf("revoking: got exit code %d", code)
+	}
+	if tokens, err := apitoken.List(file); err != nil || len(tokens) != 0 {
+		t.Errorf("after revoking: got tokens %+v, %v", tokens, err)
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{"no command", nil, 2},
+		{"unknown command", []string{"rotate"}, 2},
+		{"unknown flag", []string{"list", "-verbose"}, 2},
+		{"unknown scope", []string{"mint", "-name", "ci", "-scopes", "delete"}, 1},
+		{"unknown token", []string{"revoke", "-name", "ci"}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, code := captureStdout(t, func() int { return tokenCommand(tt.args) }); code != tt.want {
+				t.Errorf("got exit code %d, want %d", code, tt.want)
+			}
+		})
+	}
+
+	t.Setenv(config.EnvAPITokensFile, "")
+	if _, code := captureStdout(t, func() int { return tokenCommand([]string{"list"}) }); code != 2 {
+		t.Errorf("without a tokens file: got exit code %d", code)
+	}
+}
@@ -0,0 +1,262 @@
+// Package apitoken manages the bearer tokens that authenticate API clients. Tokens
+// are stored in a JSON file as SHA-256 hashes, so the file never contains a usable
+// token.
+package apitoken
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scopes grant access to groups of API endpoints.
+const (
+	// ScopeCreate allows creating secrets.
+	ScopeCreate = "create"
+	// ScopeReveal allows describing, revealing and deleting secrets.
+	ScopeReveal = "reveal"
+	// ScopeAdmin allows everything.
+	ScopeAdmin = "admin"
+)
+
+// Scopes lists every valid scope.
+var Scopes = []string{ScopeCreate, ScopeReveal, ScopeAdmin}
+
+// Prefix begins every token, which tells them apart from other bearer credentials.
+const Prefix = "gsp_"
+
+// tokenBytes is the number of random bytes in a token.
+const tokenBytes = 32
+
+var (
+	// ErrNotFound is returned when revoking a token that doesn't exist.
+	ErrNotFound = errors.New("token not found")
+	// ErrExists is returned when minting a token with a name already in use.
+	ErrExists = errors.New("a token with this name already exists")
+)
+
+// Token is a minted token as recorded in the tokens file.
+type Token struct {
+	// Name identifies the token in logs and when revoking it.
+	Name string `json:"name"`
+	// Hash is the hex-encoded SHA-256 hash of the token.
+	Hash      string    `json:"hash"`
+	Scopes    []string  `json:"scopes"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// file is the content of the tokens file.
+type file struct {
+	Tokens []Token `json:"tokens"`
+}
+
+// Store verifies tokens against a tokens file. Reload reads the file again when it
+// changes, so tokens minted or revoked while the server runs take effect without a
+// restart.
+type Store struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	tokens  []Token
+}
+
+// NewStore returns a Store for the tokens file at path, which must exist.
+func NewStore(path string) (*Store, error) {
+	s := &Store{path: path}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Verify returns the token matching the presented token among those last loaded, or
+// false if there isn't one.
+func (s *Store) Verify(presented string) (Token, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hash := hashToken(presented)
+	for _, t := range s.tokens {
+		if subtle.ConstantTimeCompare([]byte(t.Hash), []byte(hash)) == 1 {
+			return t, true
+		}
+	}
+
+	return Token{}, false
+}
+
+// Reload reads the tokens file if it changed since it was last read. If the file
+// can't be read, the tokens loaded before are kept, and the file is read again on
+// the next call.
+func (s *Store) Reload() error {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if info.ModTime().Equal(s.modTime) && info.Size() == s.size && s.tokens != nil {
+		return nil
+	}
+
+	f, err := readFile(s.path)
+	if err != nil {
+		return err
+	}
+
+	s.tokens = f.Tokens
+	if s.tokens == nil {
+		s.tokens = []Token{}
+	}
+	s.modTime, s.size = info.ModTime(), info.Size()
+	return nil
+}
+
+// Mint creates a token named name with scopes, records its hash in the tokens file
+// at path, and returns the token. The file is created if it doesn't exist. The token
+// can't be recovered later.
+func Mint(path, name string, scopes []string) (string, error) {
+	if name == "" {
+		return "", errors.New("a token name is required")
+	}
+
+	if len(scopes) == 0 {
+		return "", errors.New("at least one scope is required")
+	}
+
+	for _, scope := range scopes {
+		if !ValidScope(scope) {
+			return "", fmt.Errorf("unknown scope %q, valid scopes are %s", scope, strings.Join(Scopes, ", "))
+		}
+	}
+
+	f, err := readFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+
+	for _, t := range f.Tokens {
+		if t.Name == name {
+			return "", ErrExists
+		}
+	}
+
+	b := make([]byte, tokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := Prefix + base64.RawURLEncoding.EncodeToString(b)
+
+	f.Tokens = append(f.Tokens, Token{
+		Name:      name,
+		Hash:      hashToken(token),
+		Scopes:    scopes,
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+	})
+
+	if err := writeFile(path, f); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// Revoke removes the token named name from the tokens file at path.
+func Revoke(path, name string) error {
+	f, err := readFile(path)
+	if err != nil {
+		return err
+	}
+
+	tokens := make([]Token, 0, len(f.Tokens))
+	for _, t := range f.Tokens {
+		if t.Name != name {
+			tokens = append(tokens, t)
+		}
+	}
+
+	if len(tokens) == len(f.Tokens) {
+		return ErrNotFound
+	}
+
+	f.Tokens = tokens
+	return writeFile(path, f)
+}
+
+// List returns the tokens recorded in the tokens file at path.
+func List(path string) ([]Token, error) {
+	f, err := readFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return f.Tokens, nil
+}
+
+// ValidScope returns true if scope is one of Scopes.
+func ValidScope(scope string) bool {
+	for _, s := range Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func readFile(path string) (file, error) {
+	f := file{}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return f, err
+	}
+
+	if err := json.Unmarshal(b, &f); err != nil {
+		return f, fmt.Errorf("unable to parse tokens file %s: %w", path, err)
+	}
+
+	return f, nil
+}
+
+// writeFile replaces the tokens file at path with f. The file is replaced by a
+// rename, so a running server never reads a partially written file.
+func writeFile(path string, f file) error {
+	b, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(append(b, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,143 @@
+package apitoken
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+
+	token, err := Mint(path, "ci", []string{ScopeCreate, ScopeReveal})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(token, Prefix) {
+		t.Errorf("got token %q without prefix %q", token, Prefix)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), strings.TrimPrefix(token, Prefix)) {
+		t.Errorf("the tokens file contains the token: %s", b)
+	}
+
+	tokens, err := List(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 1 || tokens[0].Name != "ci" || strings.Join(tokens[0].Scopes, ",") != "create,reveal" || tokens[0].CreatedAt.IsZero() {
+		t.Errorf("got tokens %+v", tokens)
+	}
+
+	if _, err := Mint(path, "ci", []string{ScopeCreate}); !errors.Is(err, ErrExists) {
+		t.Errorf("minting a token with a name in use: got error %v", err)
+	}
+
+	for name, scopes := range map[string][]string{
+		"":       {ScopeCreate},
+		"none":   nil,
+		"delete": {"delete"},
+	} {
+		if _, err := Mint(path, name, scopes); err == nil {
+			t.Errorf("minted token %q with scopes %q", name, scopes)
+		}
+	}
+}
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	ci, err := Mint(path, "ci", []string{ScopeCreate})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, ok := s.Verify(ci); !ok || got.Name != "ci" {
+		t.Errorf("verifying a minted token: got %+v, %t", got, ok)
+	}
+	if _, ok := s.Verify(Prefix + "forged"); ok {
+		t.Error("verified a forged token")
+	}
+
+	// Tokens minted and revoked while the store is in use take effect on reload.
+	deploy, err := Mint(path, "deploy", []string{ScopeAdmin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Revoke(path, "ci"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.Verify(deploy); !ok {
+		t.Error("a minted token wasn't picked up")
+	}
+	if _, ok := s.Verify(ci); ok {
+		t.Error("a revoked token was still verified")
+	}
+
+	if err := Revoke(path, "ci"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("revoking a token twice: got error %v", err)
+	}
+}
+
+func TestStoreKeepsTokensWhenReloadFails(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	token, err := Mint(path, "ci", []string{ScopeCreate})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(path, []byte("{not json"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Reload(); err == nil {
+		t.Error("reloaded a malformed file")
+	}
+	if _, ok := s.Verify(token); !ok {
+		t.Error("a malformed file dropped the tokens loaded before")
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Reload(); err == nil {
+		t.Error("reloaded a missing file")
+	}
+	if _, ok := s.Verify(token); !ok {
+		t.Error("a missing file dropped the tokens loaded before")
+	}
+
+	// The file is read again once it is fixed.
+	if _, err := Mint(path, "deploy", []string{ScopeCreate}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.Verify(token); ok {
+		t.Error("a token missing from the fixed file was still verified")
+	}
+}
+
+func TestNewStoreRequiresFile(t *testing.T) {
+	if _, err := NewStore(filepath.Join(t.TempDir(), "tokens.json")); err == nil {
+		t.Error("created a store without a tokens file")
+	}
+}
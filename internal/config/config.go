@@ -19,11 +19,138 @@ const (
 	EnvHostOverride = "HOST_OVERRIDE"
 	EnvNoSSL        = "NO_SSL"
 
+	// EnvPublicURL is the URL the server is reached at, e.g.
+	// "https://snappass.example.com/sharepassword/". Every link is built from it. It
+	// replaces EnvHostOverride, EnvURLPrefix and EnvNoSSL.
+	EnvPublicURL = "PUBLIC_URL"
+	// EnvAllowedHosts is a comma separated list of the hosts requests may be made
+	// for, with or without a port. Requests for other hosts are rejected.
+	EnvAllowedHosts = "SNAPPASS_ALLOWED_HOSTS"
+
 	// EnvListenString is the server address on which to listen.,
 	// e.g. 192.168.10.10:5000, :1234, etc.
 	// The python implementation uses flask which has other environment variables that we
 	// will not implement.
 	EnvListenString = "SNAPPASS_LISTEN_ADDRESS"
+
+	// EnvTLSCert and EnvTLSKey are the PEM files of the certificate and key to serve
+	// https with, reloaded when they change or on SIGHUP. EnvTLSMinVersion is the
+	// lowest TLS version negotiated, "1.2" unless set, and EnvTLSCiphers a comma
+	// separated list of the cipher suites allowed with TLS 1.2.
+	EnvTLSCert       = "SNAPPASS_TLS_CERT"
+	EnvTLSKey        = "SNAPPASS_TLS_KEY"
+	EnvTLSMinVersion = "SNAPPASS_TLS_MIN_VERSION"
+	EnvTLSCiphers    = "SNAPPASS_TLS_CIPHERS"
+	// EnvHTTPRedirectAddress is an address on which to listen for plain HTTP, e.g.
+	// :80, and redirect requests to https.
+	EnvHTTPRedirectAddress = "SNAPPASS_HTTP_REDIRECT_ADDRESS"
+
+	// EnvLabelMaxLength is the maximum number of characters allowed in the public
+	// label a sender may attach to a secret.
+	EnvLabelMaxLength = "SNAPPASS_LABEL_MAX_LENGTH"
+
+	// EnvDisableQRCode, when "true", removes the QR code of the link from the page
+	// shown after a secret is stored.
+	EnvDisableQRCode = "SNAPPASS_DISABLE_QR_CODE"
+
+	// EnvLinkStyle selects the style of links produced for new secrets: "long"
+	// (default, compatible with snappass), "short", or "words".
+	EnvLinkStyle = "SNAPPASS_LINK_STYLE"
+
+	// EnvOIDCIssuer enables login with the OpenID Connect provider at this issuer
+	// URL. The remaining EnvOIDC variables configure this application as a client of
+	// the provider.
+	EnvOIDCIssuer       = "SNAPPASS_OIDC_ISSUER"
+	EnvOIDCClientID     = "SNAPPASS_OIDC_CLIENT_ID"
+	EnvOIDCClientSecret = "SNAPPASS_OIDC_CLIENT_SECRET"
+	// EnvOIDCRedirectURL is the public URL of the /auth/callback endpoint.
+	EnvOIDCRedirectURL = "SNAPPASS_OIDC_REDIRECT_URL"
+	// EnvOIDCGroupsClaim is the ID token claim listing a user's groups. Defaults
+	// to "groups".
+	EnvOIDCGroupsClaim = "SNAPPASS_OIDC_GROUPS_CLAIM"
+
+	// EnvTrustedProxies is a comma separated list of the CIDRs and IP addresses of
+	// proxies whose Forwarded and X-Forwarded-* headers are read.
+	EnvTrustedProxies = "SNAPPASS_TRUSTED_PROXIES"
+	// EnvProxyAuth set to "true" treats users named by the X-Forwarded-User and
+	// X-Forwarded-Email headers of trusted proxies as logged in.
+	EnvProxyAuth = "SNAPPASS_PROXY_AUTH"
+
+	// EnvCreatorLogin set to "true" requires logging in with the OpenID Connect
+	// provider, or through a trusted proxy, to create secrets. EnvCreatorGroups and
+	// EnvCreatorDomains are comma separated lists of the groups and email domains
+	// allowed to; any user who logs in is allowed when both are empty.
+	EnvCreatorLogin   = "SNAPPASS_CREATOR_LOGIN"
+	EnvCreatorGroups  = "SNAPPASS_CREATOR_GROUPS"
+	EnvCreatorDomains = "SNAPPASS_CREATOR_DOMAINS"
+
+	// EnvSessionSecret is the key used to sign CSRF and session cookies. It must be
+	// shared by all replicas.
+	EnvSessionSecret = "SNAPPASS_SESSION_SECRET"
+
+	// EnvAPITokensFile is the file of hashed API tokens, managed with the token
+	// command. When set, API clients must authenticate with a token.
+	EnvAPITokensFile = "SNAPPASS_API_TOKENS_FILE"
+
+	// EnvJWTIssuersFile is a JSON file of issuers whose JWTs authenticate API
+	// clients, typically CI systems, and the claim rules granting them scopes.
+	EnvJWTIssuersFile = "SNAPPASS_JWT_ISSUERS_FILE"
+
+	// EnvAPIClientCA is a PEM bundle of the CAs that sign the client certificates API
+	// clients may authenticate with, when serving TLS. EnvAPIClientCertRulesFile is a
+	// JSON file of rules granting scopes to certificates by their subject and SANs.
+	// EnvAPIClientCertRequired set to "true" rejects API requests without a client
+	// certificate.
+	EnvAPIClientCA            = "SNAPPASS_API_CLIENT_CA"
+	EnvAPIClientCertRulesFile = "SNAPPASS_API_CLIENT_CERT_RULES_FILE"
+	EnvAPIClientCertRequired  = "SNAPPASS_API_CLIENT_CERT_REQUIRED"
+
+	// EnvGRPCListenAddress enables the gRPC service on this address, e.g. :5001.
+	// The service requires mutual TLS, configured by the remaining EnvGRPC
+	// variables.
+	EnvGRPCListenAddress = "SNAPPASS_GRPC_LISTEN_ADDRESS"
+	EnvGRPCTLSCert       = "SNAPPASS_GRPC_TLS_CERT"
+	EnvGRPCTLSKey        = "SNAPPASS_GRPC_TLS_KEY"
+	// EnvGRPCClientCA is a PEM bundle of the CAs that sign client certificates.
+	EnvGRPCClientCA = "SNAPPASS_GRPC_CLIENT_CA"
+
+	// These variables replace the default security headers set on every response.
+	// Setting one to an empty value removes its header. "{nonce}" in the
+	// Content-Security-Policy is replaced by the nonce of each response, which the
+	// scripts of its page carry.
+	EnvContentSecurityPolicy   = "SNAPPASS_CONTENT_SECURITY_POLICY"
+	EnvReferrerPolicy          = "SNAPPASS_REFERRER_POLICY"
+	EnvFrameOptions            = "SNAPPASS_FRAME_OPTIONS"
+	EnvStrictTransportSecurity = "SNAPPASS_STRICT_TRANSPORT_SECURITY"
+	EnvRobotsTag               = "SNAPPASS_ROBOTS_TAG"
+	EnvCacheControl            = "SNAPPASS_CACHE_CONTROL"
+
+	// These variables replace the default rate limits of each client, as
+	// "<requests>/<window>", e.g. "30/1m", or "off". API clients are limited by their
+	// credential, and everyone else by their IP address.
+	EnvRateLimitCreate  = "SNAPPASS_RATE_LIMIT_CREATE"
+	EnvRateLimitPreview = "SNAPPASS_RATE_LIMIT_PREVIEW"
+	EnvRateLimitReveal  = "SNAPPASS_RATE_LIMIT_REVEAL"
+
+	// These variables replace the default bans of clients probing for secrets.
+	// EnvBanThreshold is the number of malformed tokens, unknown secrets and wrong
+	// keys a client may request per EnvBanWindow, or 0 to ban nobody. EnvBanDuration
+	// is how long the next failure bans the client for.
+	EnvBanThreshold = "SNAPPASS_BAN_THRESHOLD"
+	EnvBanWindow    = "SNAPPASS_BAN_WINDOW"
+	EnvBanDuration  = "SNAPPASS_BAN_DURATION"
+
+	// EnvMetricsListenAddress serves Prometheus metrics at /metrics on this address,
+	// e.g. 127.0.0.1:9090, apart from the pages and APIs. Metrics aren't served unless
+	// it is set.
+	EnvMetricsListenAddress = "SNAPPASS_METRICS_LISTEN_ADDRESS"
+
+	// EnvClientServerURL is the URL of the server that the share and get commands
+	// talk to.
+	EnvClientServerURL = "SNAPPASS_URL"
+	// EnvClientToken is the API token, or JWT, that the share and get commands
+	// authenticate with.
+	EnvClientToken = "SNAPPASS_TOKEN"
 )
 
 func RedisURL() string {
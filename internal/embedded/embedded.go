@@ -12,3 +12,6 @@ var StaticAssets embed.FS
 
 //go:embed static/favicon.ico
 var Favicon []byte
+
+//go:embed openapi/openapi.json
+var OpenAPISpec []byte
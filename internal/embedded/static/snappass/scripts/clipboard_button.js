@@ -1,6 +1,7 @@
 (function(){
 
-    var targetButtonSelector = '#copy-clipboard-btn'
+    // Every copy button on the page, e.g. one per field of a structured secret.
+    var targetButtonSelector = '.copy-clipboard-btn'
     var clipboard = new Clipboard(targetButtonSelector);
 
     var copyError = function(e) {
@@ -0,0 +1,37 @@
+(function () {
+
+  var countdown = $('#countdown');
+  if (countdown.length === 0) {
+    return;
+  }
+
+  var notBefore = parseInt(countdown.attr('data-not-before'), 10) * 1000;
+
+  var pad = function (n) {
+    return n < 10 ? '0' + n : '' + n;
+  };
+
+  var tick = function () {
+    var remaining = Math.floor((notBefore - Date.now()) / 1000);
+    if (remaining <= 0) {
+      // The secret is available now. Reloading shows the reveal button.
+      window.location.reload();
+      return;
+    }
+
+    var days = Math.floor(remaining / 86400);
+    var hours = Math.floor((remaining % 86400) / 3600);
+    var minutes = Math.floor((remaining % 3600) / 60);
+    var seconds = remaining % 60;
+
+    var text = pad(hours) + ':' + pad(minutes) + ':' + pad(seconds);
+    if (days > 0) {
+      text = days + 'd ' + text;
+    }
+
+    countdown.text('Available in ' + text);
+    setTimeout(tick, 1000);
+  };
+
+  tick();
+})();
@@ -4,6 +4,11 @@
     var form = $('<form/>')
       .attr('id', 'revealSecretForm')
       .attr('method', 'post');
+    $('<input/>')
+      .attr('type', 'hidden')
+      .attr('name', 'csrf_token')
+      .val($(this).data('csrf-token'))
+      .appendTo(form);
     form.appendTo($('body'));
     form.submit();
   });
@@ -0,0 +1,19 @@
+(function () {
+
+  // Switch between a free text secret and a structured secret of named fields.
+  $('input[name="mode"]').change(function () {
+    var structured = $('input[name="mode"]:checked').val() === 'fields';
+    $('#structured-fields').prop('hidden', !structured);
+    $('#text-secret').prop('hidden', structured);
+    $('#password').prop('required', !structured);
+  });
+
+  // datetime-local values carry no timezone. Convert the value to an RFC 3339
+  // timestamp in the browser's timezone before submitting.
+  $('#password_create').submit(function () {
+    var local = $('#not_before_local').val();
+    if (local) {
+      $('#not_before').val(new Date(local).toISOString());
+    }
+  });
+})();
@@ -0,0 +1,59 @@
+// Package linktoken splits the tokens of secret links, their last path segment, into
+// the ID and key of the secret. The server and its clients share it, so that they
+// always agree on how tokens are separated.
+package linktoken
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// Separator separates the ID and key of long tokens.
+	Separator = "~"
+	// CompactSeparator separates the parts of short and word tokens.
+	CompactSeparator = "-"
+
+	// WordIDCount and WordKeyCount are the number of words encoding the ID and key of
+	// word tokens.
+	WordIDCount  = 8
+	WordKeyCount = 12
+)
+
+// Style is the link style of a token.
+type Style int
+
+const (
+	// Long tokens are <id>~<escaped fernet key>.
+	Long Style = iota
+	// Short tokens are <base58 id>-<base58 key>.
+	Short
+	// Words tokens are WordIDCount words of ID followed by WordKeyCount words of key,
+	// separated by dashes.
+	Words
+)
+
+// Split returns the ID and key parts of the token t, and its style. The parts are
+// returned as they appear in t: the parts of long tokens are still escaped, and
+// those of word tokens are joined with CompactSeparator. Split doesn't check that
+// the parts are valid IDs and keys.
+func Split(t string) (id, key string, style Style, err error) {
+	if strings.Contains(t, Separator) {
+		parts := strings.Split(t, Separator)
+		if len(parts) != 2 {
+			return "", "", 0, fmt.Errorf("unable to split token: '%s' using separator '%s'", t, Separator)
+		}
+
+		return parts[0], parts[1], Long, nil
+	}
+
+	parts := strings.Split(t, CompactSeparator)
+	switch len(parts) {
+	case 2:
+		return parts[0], parts[1], Short, nil
+	case WordIDCount + WordKeyCount:
+		return strings.Join(parts[:WordIDCount], CompactSeparator), strings.Join(parts[WordIDCount:], CompactSeparator), Words, nil
+	}
+
+	return "", "", 0, fmt.Errorf("unable to parse token with %d parts", len(parts))
+}
@@ -0,0 +1,34 @@
+package linktoken
+
+import "testing"
+
+func TestSplit(t *testing.T) {
+	words := "able-acid-aged-also-area-army-away-baby-back-ball-band-bank-base-bath-bear-beat-been-beer-bell-belt"
+	tests := []struct {
+		token   string
+		id, key string
+		style   Style
+	}{
+		{"snappassabc~a2V5%3D", "snappassabc", "a2V5%3D", Long},
+		{"snappass-abc~a2V5", "snappass-abc", "a2V5", Long},
+		{"3mJr7AoUXx2Wqd-5zN8sxn1UFpn", "3mJr7AoUXx2Wqd", "5zN8sxn1UFpn", Short},
+		{words, "able-acid-aged-also-area-army-away-baby", "back-ball-band-bank-base-bath-bear-beat-been-beer-bell-belt", Words},
+	}
+
+	for _, tt := range tests {
+		id, key, style, err := Split(tt.token)
+		if err != nil {
+			t.Errorf("Split(%q): %s", tt.token, err)
+			continue
+		}
+		if id != tt.id || key != tt.key || style != tt.style {
+			t.Errorf("Split(%q) = %q, %q, %d, want %q, %q, %d", tt.token, id, key, style, tt.id, tt.key, tt.style)
+		}
+	}
+
+	for _, token := range []string{"", "a~b~c", "abc", "a-b-c", "able-acid-aged-also-back-ball-band-bank-base-bath-bear-beat-been-beer-bell-belt"} {
+		if _, _, _, err := Split(token); err == nil {
+			t.Errorf("Split(%q) succeeded", token)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// adminScanCount is the number of keys requested from each SCAN of the database.
+const adminScanCount = 500
+
+// TTLBucket counts the secrets whose remaining TTL is under a bound.
+type TTLBucket struct {
+	// Under is the bound of the bucket. Zero means secrets longer than every other
+	// bucket.
+	Under time.Duration
+	Count int
+}
+
+// SecretStats summarizes the secrets in the database. It never includes their
+// content. The counts are taken in a single scan of the database, with each batch of
+// keys read atomically, so a secret is never counted both as active and as gone.
+// Secrets written during the scan may be missed or counted twice.
+type SecretStats struct {
+	Active int
+	// Pending counts the active secrets that can't be revealed yet.
+	Pending    int
+	Restricted int
+	Structured int
+	TTLs       []TTLBucket
+	// Gone counts the secrets that no longer exist by reason, for as long as their
+	// tombstones are kept.
+	Gone map[string]int
+}
+
+// AdminStats summarizes the secrets stored under keyPrefix.
+func AdminStats(ctx context.Context, keyPrefix string) (SecretStats, error) {
+	stats := SecretStats{
+		TTLs: []TTLBucket{{Under: time.Hour}, {Under: 24 * time.Hour}, {Under: 7 * 24 * time.Hour}, {}},
+		Gone: map[string]int{goneExpired: 0, goneRevealed: 0, goneDeleted: 0},
+	}
+	now := time.Now()
+	tombstonePrefix := tombstoneKey(keyPrefix, "")
+
+	err := scanKeys(ctx, escapeGlob(keyPrefix)+"*", func(keys []string) error {
+		ids := []string{}
+		var ttls []*redis.DurationCmd
+		var values []*redis.StringCmd
+		var reasons []*redis.StringCmd
+		var live []*redis.IntCmd
+
+		_, err := RedisClient().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			for _, k := range keys {
+				switch {
+				case isSecretID(k, keyPrefix):
+					ids = append(ids, k)
+					ttls = append(ttls, pipe.PTTL(ctx, k))
+					values = append(values, pipe.Get(ctx, k))
+				case strings.HasPrefix(k, tombstonePrefix):
+					reasons = append(reasons, pipe.Get(ctx, k))
+					live = append(live, pipe.Exists(ctx, strings.TrimPrefix(k, tombstonePrefix)))
+				}
+			}
+			return nil
+		})
+		if err != nil && err != redis.Nil {
+			return err
+		}
+
+		for i := range ids {
+			// The secret expired or was revealed since it was scanned.
+			v, err := values[i].Result()
+			if err == redis.Nil {
+				continue
+			}
+
+			s, err := decodeStoredSecret(v)
+			if err != nil {
+				return err
+			}
+
+			stats.Active++
+			if !s.activeAt(now) {
+				stats.Pending++
+			}
+			if s.Restriction != nil {
+				stats.Restricted++
+			}
+			if s.Kind == secretKindFields {
+				stats.Structured++
+			}
+
+			ttl := ttls[i].Val()
+			for j := range stats.TTLs {
+				if stats.TTLs[j].Under == 0 || ttl < stats.TTLs[j].Under {
+					stats.TTLs[j].Count++
+					break
+				}
+			}
+		}
+
+		// Live secrets have a tombstone that will read expired once they do.
+		for i := range reasons {
+			if reason, err := reasons[i].Result(); err == nil && live[i].Val() == 0 {
+				stats.Gone[reason]++
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return SecretStats{}, err
+	}
+
+	return stats, nil
+}
+
+// AdminPurge deletes every secret stored under keyPrefix, returning how many were
+// deleted. Their links report them as deleted.
+func AdminPurge(ctx context.Context, keyPrefix string) (int, error) {
+	purged := 0
+	err := scanSecretIDs(ctx, keyPrefix, func(ids []string) error {
+		n, err := RedisClient().Del(ctx, ids...).Result()
+		if err != nil {
+			return err
+		}
+		purged += int(n)
+
+		for _, id := range ids {
+			buryTombstone(ctx, keyPrefix, id, goneDeleted)
+		}
+		return nil
+	})
+
+	return purged, err
+}
+
+// AdminRevoke deletes the secret identified by publicID, the ID part of a token of
+// any link style, without revealing it.
+func AdminRevoke(ctx context.Context, keyPrefix, publicID string) error {
+	id, err := parseSecretID(publicID, keyPrefix)
+	if err != nil {
+		return err
+	}
+
+	return deleteSecret(ctx, keyPrefix, id)
+}
+
+// AdminCheckStorage returns an error if the database can't be written to, read from
+// and deleted from under keyPrefix.
+func AdminCheckStorage(ctx context.Context, keyPrefix string) error {
+	if err := RedisIsAlive(); err != nil {
+		return err
+	}
+
+	nonce, err := randomBytes(8)
+	if err != nil {
+		return err
+	}
+
+	key := auxKey(keyPrefix, "check", base58Encode(nonce))
+	if err := RedisClient().Set(ctx, key, "ok", time.Minute).Err(); err != nil {
+		return err
+	}
+
+	v, err := RedisClient().GetDel(ctx, key).Result()
+	if err != nil {
+		return err
+	}
+
+	if v != "ok" {
+		return errors.New("the database returned a different value than was written")
+	}
+
+	return nil
+}
+
+// scanSecretIDs calls fn with batches of the IDs of the secrets stored under
+// keyPrefix. Auxiliary keys and other keys sharing the prefix are skipped.
+func scanSecretIDs(ctx context.Context, keyPrefix string, fn func(ids []string) error) error {
+	return scanKeys(ctx, escapeGlob(keyPrefix)+"*", func(keys []string) error {
+		ids := make([]string, 0, len(keys))
+		for _, k := range keys {
+			if isSecretID(k, keyPrefix) {
+				ids = append(ids, k)
+			}
+		}
+
+		if len(ids) == 0 {
+			return nil
+		}
+		return fn(ids)
+	})
+}
+
+// scanKeys calls fn with batches of the keys matching pattern. A key may be passed
+// more than once if it is written while the database is scanned.
+func scanKeys(ctx context.Context, pattern string, fn func(keys []string) error) error {
+	var cursor uint64
+	for {
+		keys, next, err := RedisClient().Scan(ctx, cursor, pattern, adminScanCount).Result()
+		if err != nil {
+			return err
+		}
+
+		if len(keys) > 0 {
+			if err := fn(keys); err != nil {
+				return err
+			}
+		}
+
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// isSecretID returns true if key is the database ID of a secret of any link style.
+// Short IDs must be canonically encoded, since decoding them accepts other keys.
+func isSecretID(key, keyPrefix string) bool {
+	if strings.HasPrefix(key, keyPrefix+":") || !strings.HasPrefix(key, keyPrefix) {
+		return false
+	}
+
+	if isLongID(key, keyPrefix) {
+		return true
+	}
+
+	publicID := strings.TrimPrefix(key, keyPrefix)
+	if b, err := base58Decode(publicID, shortIDBytes); err == nil {
+		return base58Encode(b) == publicID
+	}
+
+	id, err := parseSecretID(publicID, keyPrefix)
+	return err == nil && id == key
+}
+
+// escapeGlob escapes the characters of s that are special in Redis patterns.
+func escapeGlob(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`).Replace(s)
+}
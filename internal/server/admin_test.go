@@ -0,0 +1,51 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAdminStats(t *testing.T) {
+	ts := newTestServer(t)
+
+	ts.postJSON(t, "/api/v2/secrets", map[string]any{"secret": "s3cr3t", "ttl": 60}, nil, nil)
+	testRedis.FastForward(2 * time.Minute)
+
+	revealed := ts.createSecret(t, "s3cr3t")
+	if resp := ts.postJSON(t, "/api/v2/secrets/"+revealed.ID+"/reveal", apiV2KeyRequest{Key: revealed.Key}, nil, nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("revealing: got status %d", resp.StatusCode)
+	}
+
+	deleted := ts.createSecret(t, "s3cr3t")
+	body := strings.NewReader(`{"key": "` + deleted.Key + `"}`)
+	if resp, _ := ts.do(t, http.MethodDelete, "/api/v2/secrets/"+deleted.ID, body, http.Header{"Content-Type": {"application/json"}}); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("deleting: got status %d", resp.StatusCode)
+	}
+
+	ts.postJSON(t, "/api/v2/secrets", map[string]any{"secret": "s3cr3t", "ttl": 1800}, nil, nil)
+	ts.postJSON(t, "/api/v2/secrets", map[string]any{
+		"fields":     []map[string]string{{"name": "password", "value": "s3cr3t"}},
+		"ttl":        7200,
+		"not_before": time.Now().Add(time.Hour).Format(time.RFC3339),
+	}, nil, nil)
+
+	stats, err := AdminStats(context.Background(), DefaultRedisKeyPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := SecretStats{
+		Active:     2,
+		Pending:    1,
+		Structured: 1,
+		TTLs:       []TTLBucket{{Under: time.Hour, Count: 1}, {Under: 24 * time.Hour, Count: 1}, {Under: 7 * 24 * time.Hour}, {}},
+		Gone:       map[string]int{goneExpired: 1, goneRevealed: 1, goneDeleted: 1},
+	}
+	if !reflect.DeepEqual(stats, want) {
+		t.Errorf("got stats %+v, want %+v", stats, want)
+	}
+}
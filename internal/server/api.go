@@ -0,0 +1,114 @@
+package server
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+const (
+	// defaultAPITTL is the TTL, in seconds, of secrets created with the snappass
+	// compatible API when the request doesn't include one. It is also the maximum.
+	defaultAPITTL = 1209600
+	maxAPITTL     = defaultAPITTL
+)
+
+// snappassSetPasswordRequest is the body of a request to the snappass compatible
+// /api/set_password/ endpoint.
+type snappassSetPasswordRequest struct {
+	Password any `json:"password"`
+	// TTL is in seconds. snappass accepts both numbers and numeric strings.
+	TTL json.RawMessage `json:"ttl"`
+}
+
+// snappassSetPasswordResponse is the body returned by /api/set_password/.
+type snappassSetPasswordResponse struct {
+	Link string `json:"link"`
+	TTL  int    `json:"ttl"`
+}
+
+// newSnappassSetPasswordHandler produces a handler for /api/set_password/ that behaves
+// like the original snappass API. Requests that aren't JSON are rejected with 415,
+// malformed JSON with 400, and a missing password or invalid TTL with 500, as
+// snappass does.
+func newSnappassSetPasswordHandler(cfg routerConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := slog.FromContext(r.Context())
+
+		if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+			http.Error(w, http.StatusText(http.StatusUnsupportedMediaType), http.StatusUnsupportedMediaType)
+			return
+		}
+
+		var body json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+
+		// snappass reads the body as a JSON object. Any other JSON value fails once it
+		// is accessed, which is a 500.
+		req := snappassSetPasswordRequest{}
+		if !strings.HasPrefix(string(body), "{") || json.Unmarshal(body, &req) != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		password, _ := req.Password.(string)
+		ttl, ok := parseSnappassTTL(req.TTL)
+		if password == "" || !ok || ttl <= 0 || ttl > maxAPITTL {
+			logger.Info("rejected invalid set_password API request")
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		secretLink, err := createSecret(r.Context(), cfg, newSecret{
+			plaintext: password,
+			ttl:       time.Duration(ttl) * time.Second,
+			creator:   cfg.actor(r),
+		})
+		if err != nil {
+			logger.Error("unable to store secret", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, snappassSetPasswordResponse{
+			Link: cfg.secretURL(r, secretLink.token),
+			TTL:  ttl,
+		})
+	}
+}
+
+// parseSnappassTTL parses a TTL the way snappass does, accepting numbers, which are
+// truncated, and numeric strings. A missing TTL is defaultAPITTL.
+func parseSnappassTTL(raw json.RawMessage) (int, bool) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return defaultAPITTL, true
+	}
+
+	var n float64
+	if err := json.Unmarshal(raw, &n); err == nil {
+		return int(n), true
+	}
+
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		i, err := strconv.Atoi(strings.TrimSpace(s))
+		return i, err == nil
+	}
+
+	return 0, false
+}
+
+// writeJSON writes v as the JSON body of the response with status.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
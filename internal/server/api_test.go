@@ -0,0 +1,95 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// These cases pin /api/set_password/ to the behaviour of pinterest/snappass.
+func TestSnappassSetPassword(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		wantStatus  int
+		wantTTL     int
+	}{
+		{"password and ttl", "application/json", `{"password": "foobar", "ttl": 3600}`, http.StatusOK, 3600},
+		{"default ttl", "application/json", `{"password": "foobar"}`, http.StatusOK, 1209600},
+		{"null ttl", "application/json", `{"password": "foobar", "ttl": null}`, http.StatusOK, 1209600},
+		{"numeric string ttl", "application/json", `{"password": "foobar", "ttl": "60"}`, http.StatusOK, 60},
+		{"fractional ttl", "application/json", `{"password": "foobar", "ttl": 60.9}`, http.StatusOK, 60},
+		{"maximum ttl", "application/json", `{"password": "foobar", "ttl": 1209600}`, http.StatusOK, 1209600},
+		{"content type with charset", "application/json; charset=utf-8", `{"password": "foobar"}`, http.StatusOK, 1209600},
+		{"missing password", "application/json", `{"ttl": 3600}`, http.StatusInternalServerError, 0},
+		{"empty password", "application/json", `{"password": ""}`, http.StatusInternalServerError, 0},
+		{"numeric password", "application/json", `{"password": 42}`, http.StatusInternalServerError, 0},
+		{"zero ttl", "application/json", `{"password": "foobar", "ttl": 0}`, http.StatusInternalServerError, 0},
+		{"negative ttl", "application/json", `{"password": "foobar", "ttl": -1}`, http.StatusInternalServerError, 0},
+		{"ttl too long", "application/json", `{"password": "foobar", "ttl": 1209601}`, http.StatusInternalServerError, 0},
+		{"non-numeric ttl", "application/json", `{"password": "foobar", "ttl": "soon"}`, http.StatusInternalServerError, 0},
+		{"not an object", "application/json", `["foobar"]`, http.StatusInternalServerError, 0},
+		{"malformed JSON", "application/json", `{"password": `, http.StatusBadRequest, 0},
+		{"form", "application/x-www-form-urlencoded", `password=foobar`, http.StatusUnsupportedMediaType, 0},
+		{"no content type", "", `{"password": "foobar"}`, http.StatusUnsupportedMediaType, 0},
+	}
+
+	link := regexp.MustCompile(`^http://127\.0\.0\.1:\d+/snappass[0-9a-f-]{36}~[A-Za-z0-9_%=-]+$`)
+
+	ts := newTestServer(t)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			header := http.Header{}
+			if test.contentType != "" {
+				header.Set("Content-Type", test.contentType)
+			}
+
+			resp, body := ts.do(t, http.MethodPost, "/api/set_password/", strings.NewReader(test.body), header)
+			if resp.StatusCode != test.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", resp.StatusCode, test.wantStatus, body)
+			}
+
+			if test.wantStatus != http.StatusOK {
+				return
+			}
+
+			got := snappassSetPasswordResponse{}
+			if err := json.Unmarshal([]byte(body), &got); err != nil {
+				t.Fatal(err)
+			}
+
+			if got.TTL != test.wantTTL {
+				t.Errorf("got ttl %d, want %d", got.TTL, test.wantTTL)
+			}
+
+			if !link.MatchString(got.Link) {
+				t.Errorf("got link %q, not a snappass link", got.Link)
+			}
+		})
+	}
+}
+
+func TestSnappassSetPasswordLinkReveals(t *testing.T) {
+	ts := newTestServer(t)
+
+	got := snappassSetPasswordResponse{}
+	ts.postJSON(t, "/api/set_password/", map[string]any{"password": "foobar", "ttl": 60}, nil, &got)
+
+	id, _, err := parseToken(tokenOf(got.Link), DefaultRedisKeyPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ttl := testRedis.TTL(id); ttl != 60*time.Second {
+		t.Errorf("the secret expires in %s, want 1m0s", ttl)
+	}
+
+	resp, body := ts.submitForm(t, "/"+tokenOf(got.Link), nil)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, "foobar") {
+		t.Errorf("revealing the link: got status %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,469 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/exp/slog"
+)
+
+const (
+	// defaultAPIV2TTL is the TTL, in seconds, of secrets created with the v2 API when
+	// the request doesn't include one.
+	defaultAPIV2TTL = 604800
+
+	// maxAPIRequestBytes limits the size of JSON request bodies.
+	maxAPIRequestBytes = 1 << 20
+)
+
+// Error codes returned by the v2 API. These are part of the API and must not change.
+const (
+	apiErrInvalidRequest       = "invalid_request"
+	apiErrInvalidTTL           = "invalid_ttl"
+	apiErrUnsupportedMediaType = "unsupported_media_type"
+	apiErrNotFound             = "not_found"
+	apiErrGone                 = "gone"
+	apiErrInvalidKey           = "invalid_key"
+	apiErrLoginRequired        = "login_required"
+	apiErrForbidden            = "forbidden"
+	apiErrNotYetActive         = "not_yet_active"
+	apiErrInternal             = "internal_error"
+	apiErrUnauthorized         = "unauthorized"
+	apiErrInsufficientScope    = "insufficient_scope"
+	apiErrRateLimited          = "rate_limited"
+	apiErrClientBanned         = "client_banned"
+)
+
+// Secret kinds and statuses reported by the v2 API.
+const (
+	apiKindText   = "text"
+	apiKindFields = "fields"
+
+	apiStatusActive  = "active"
+	apiStatusPending = "pending"
+)
+
+// apiV2Error is the body of every v2 API error response.
+type apiV2Error struct {
+	Error apiV2ErrorDetail `json:"error"`
+}
+
+type apiV2ErrorDetail struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Reason explains why a gone secret no longer exists: expired, revealed or
+	// deleted.
+	Reason string `json:"reason,omitempty"`
+}
+
+// apiV2CreateRequest is the body of POST /api/v2/secrets. Exactly one of Secret and
+// Fields must be set.
+type apiV2CreateRequest struct {
+	Secret *string       `json:"secret"`
+	Fields []SecretField `json:"fields"`
+	// TTL is in seconds, counted from NotBefore if set.
+	TTL        *int       `json:"ttl"`
+	Label      string     `json:"label"`
+	NotBefore  *time.Time `json:"not_before"`
+	RestrictTo []string   `json:"restrict_to"`
+}
+
+// apiV2KeyRequest is the body of requests that must prove knowledge of a secret's
+// key.
+type apiV2KeyRequest struct {
+	Key string `json:"key"`
+}
+
+// apiV2Secret describes a secret without revealing it.
+type apiV2Secret struct {
+	ID string `json:"id"`
+	// Key and Link are only returned when the secret is created.
+	Key        string     `json:"key,omitempty"`
+	Link       string     `json:"link,omitempty"`
+	Kind       string     `json:"kind"`
+	Label      string     `json:"label,omitempty"`
+	Status     string     `json:"status"`
+	TTL        int        `json:"ttl"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	NotBefore  *time.Time `json:"not_before,omitempty"`
+	Restricted bool       `json:"restricted"`
+}
+
+// apiV2RevealedSecret is the body returned when a secret is revealed. Secret is set
+// for text secrets and Fields for structured secrets.
+type apiV2RevealedSecret struct {
+	ID     string        `json:"id"`
+	Kind   string        `json:"kind"`
+	Label  string        `json:"label,omitempty"`
+	Secret *string       `json:"secret,omitempty"`
+	Fields []SecretField `json:"fields,omitempty"`
+}
+
+// newAPIV2CreateHandler produces a handler for POST /api/v2/secrets.
+func newAPIV2CreateHandler(cfg routerConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := slog.FromContext(r.Context())
+
+		req := apiV2CreateRequest{}
+		if !decodeAPIRequest(w, r, &req) {
+			return
+		}
+
+		ttl := defaultAPIV2TTL
+		if req.TTL != nil {
+			ttl = *req.TTL
+		}
+		if ttl <= 0 || ttl > maxAPITTL {
+			writeAPIError(w, http.StatusBadRequest, apiErrInvalidTTL, "ttl must be between 1 and 1209600 seconds")
+			return
+		}
+
+		// The label is shown to anyone holding the link, so it is never logged.
+		label := strings.TrimSpace(req.Label)
+		if utf8.RuneCountInString(label) > cfg.labelMaxLength {
+			writeAPIError(w, http.StatusBadRequest, apiErrInvalidRequest, "label is too long")
+			return
+		}
+
+		restriction := parseRevealRestriction(strings.Join(req.RestrictTo, ","))
+		if restriction != nil && cfg.oidc == nil {
+			writeAPIError(w, http.StatusBadRequest, apiErrInvalidRequest, "restrict_to requires a configured identity provider")
+			return
+		}
+
+		var plaintext, kind string
+		switch {
+		case req.Secret != nil && req.Fields == nil:
+			if *req.Secret == "" {
+				writeAPIError(w, http.StatusBadRequest, apiErrInvalidRequest, "secret must not be empty")
+				return
+			}
+			plaintext = *req.Secret
+		case req.Secret == nil && len(req.Fields) > 0:
+			for _, f := range req.Fields {
+				if f.Name == "" {
+					writeAPIError(w, http.StatusBadRequest, apiErrInvalidRequest, "fields must have a name")
+					return
+				}
+			}
+
+			var err error
+			if plaintext, err = encodeFields(req.Fields); err != nil {
+				logger.Error("error encoding structured secret", err)
+				writeAPIError(w, http.StatusInternalServerError, apiErrInternal, "unable to store secret")
+				return
+			}
+			kind = secretKindFields
+		default:
+			writeAPIError(w, http.StatusBadRequest, apiErrInvalidRequest, "exactly one of secret and fields is required")
+			return
+		}
+
+		var notBefore time.Time
+		if req.NotBefore != nil && req.NotBefore.After(time.Now()) {
+			notBefore = *req.NotBefore
+		}
+
+		secret := newSecret{
+			plaintext:   plaintext,
+			kind:        kind,
+			label:       label,
+			notBefore:   notBefore,
+			restriction: restriction,
+			ttl:         time.Duration(ttl) * time.Second,
+			creator:     cfg.actor(r),
+		}
+
+		secretLink, err := createSecret(r.Context(), cfg, secret)
+		if err != nil {
+			logger.Error("unable to store secret", err)
+			writeAPIError(w, http.StatusInternalServerError, apiErrInternal, "unable to store secret")
+			return
+		}
+
+		resp := describeSecret(secretLink.publicID, secret.metadata(), secret.expiration())
+		resp.Key = secretLink.publicKey
+		resp.Link = cfg.secretURL(r, secretLink.token)
+
+		w.Header().Set("Location", "secrets/"+secretLink.publicID)
+		writeJSON(w, http.StatusCreated, resp)
+	}
+}
+
+// newAPIV2StatusHandler produces a handler for GET /api/v2/secrets/{id}, which
+// describes a secret without revealing it.
+func newAPIV2StatusHandler(cfg routerConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := slog.FromContext(r.Context())
+		publicID := mux.Vars(r)["id"]
+
+		id, stored, ok := cfg.lookupAPISecret(w, r, publicID)
+		if !ok {
+			return
+		}
+
+		ttl, err := secretTTL(r.Context(), id)
+		if err != nil && err != errSecretNotFound {
+			logger.Error("unable to query key TTL from database", err)
+			writeAPIError(w, http.StatusInternalServerError, apiErrInternal, "unable to query secret")
+			return
+		}
+
+		writeJSON(w, http.StatusOK, describeSecret(publicID, stored, ttl))
+	}
+}
+
+// newAPIV2RevealHandler produces a handler for POST /api/v2/secrets/{id}/reveal. The
+// request must carry the secret's key. The secret is only removed once the key is
+// known to decrypt it, and the requester is allowed to reveal it.
+func newAPIV2RevealHandler(cfg routerConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := slog.FromContext(r.Context())
+		publicID := mux.Vars(r)["id"]
+
+		req := apiV2KeyRequest{}
+		if !decodeAPIRequest(w, r, &req) {
+			return
+		}
+
+		id, stored, ok := cfg.lookupAPISecret(w, r, publicID)
+		if !ok {
+			return
+		}
+
+		if stored.Restriction != nil {
+			user := cfg.identity(r)
+			if user == nil {
+				writeAPIError(w, http.StatusUnauthorized, apiErrLoginRequired, "this secret is restricted and requires login")
+				return
+			}
+
+			if !stored.Restriction.allows(user) {
+				logger.Info("denied reveal of a restricted secret", "user", user.name())
+				writeAPIError(w, http.StatusForbidden, apiErrForbidden, "not allowed to reveal this secret")
+				return
+			}
+		}
+
+		if !stored.activeAt(time.Now()) {
+			writeAPIError(w, http.StatusConflict, apiErrNotYetActive, "the secret can't be revealed until "+stored.activatesAt().UTC().Format(time.RFC3339))
+			return
+		}
+
+		plaintext, ok := cfg.decryptAPISecret(w, r, publicID, req.Key, stored)
+		if !ok {
+			return
+		}
+
+		if err := takeSecret(r.Context(), cfg.redisKeyPrefix, id, stored.Token); err != nil {
+			// another request revealed the secret after we looked it up.
+			if err == errSecretNotFound {
+				writeAPIGone(w, goneRevealed)
+				return
+			}
+
+			logger.Error("error deleting from the database: ", err)
+			writeAPIError(w, http.StatusInternalServerError, apiErrInternal, "unable to reveal secret")
+			return
+		}
+		auditEvent(r.Context(), auditSecretRevealed, cfg.actor(r), "secret", id)
+
+		resp := apiV2RevealedSecret{ID: publicID, Kind: apiKindText, Label: stored.Label}
+		if stored.Kind == secretKindFields {
+			s := structuredSecret{}
+			if err := json.Unmarshal([]byte(plaintext), &s); err != nil {
+				logger.Error("error decoding structured secret", err)
+				writeAPIError(w, http.StatusInternalServerError, apiErrInternal, "unable to decode secret")
+				return
+			}
+			resp.Kind, resp.Fields = apiKindFields, s.Fields
+		} else {
+			resp.Secret = &plaintext
+		}
+
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+// newAPIV2DeleteHandler produces a handler for DELETE /api/v2/secrets/{id}. The
+// request must carry the secret's key, so that knowing the ID alone isn't enough to
+// destroy a secret.
+func newAPIV2DeleteHandler(cfg routerConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := slog.FromContext(r.Context())
+		publicID := mux.Vars(r)["id"]
+
+		req := apiV2KeyRequest{}
+		if !decodeAPIRequest(w, r, &req) {
+			return
+		}
+
+		id, stored, ok := cfg.lookupAPISecret(w, r, publicID)
+		if !ok {
+			return
+		}
+
+		if _, ok := cfg.decryptAPISecret(w, r, publicID, req.Key, stored); !ok {
+			return
+		}
+
+		if err := deleteSecret(r.Context(), cfg.redisKeyPrefix, id); err != nil {
+			if err == errSecretNotFound {
+				writeAPIGone(w, goneRevealed)
+				return
+			}
+
+			logger.Error("unable to delete key from database", err)
+			writeAPIError(w, http.StatusInternalServerError, apiErrInternal, "unable to delete secret")
+			return
+		}
+		auditEvent(r.Context(), auditSecretDeleted, cfg.actor(r), "secret", id)
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// lookupAPISecret returns the database ID and the stored secret identified by
+// publicID. If the secret can't be returned, an error response is written and false
+// is returned.
+func (cfg routerConfig) lookupAPISecret(w http.ResponseWriter, r *http.Request, publicID string) (string, storedSecret, bool) {
+	logger := slog.FromContext(r.Context())
+
+	id, err := parseSecretID(publicID, cfg.redisKeyPrefix)
+	if err != nil {
+		cfg.recordProbe(r, probeMalformed)
+		writeAPIError(w, http.StatusNotFound, apiErrNotFound, "secret not found")
+		return "", storedSecret{}, false
+	}
+
+	stored, err := lookupSecret(r.Context(), id)
+	if err == nil {
+		return id, stored, true
+	}
+
+	if err != errSecretNotFound {
+		logger.Error("unable to query key from database", err)
+		writeAPIError(w, http.StatusInternalServerError, apiErrInternal, "unable to query secret")
+		return "", storedSecret{}, false
+	}
+
+	reason, gone, err := secretGone(r.Context(), cfg.redisKeyPrefix, id)
+	if err != nil {
+		logger.Error("unable to query tombstone from database", err)
+		writeAPIError(w, http.StatusInternalServerError, apiErrInternal, "unable to query secret")
+		return "", storedSecret{}, false
+	}
+
+	if gone {
+		writeAPIGone(w, reason)
+	} else {
+		cfg.recordProbe(r, probeNotFound)
+		writeAPIError(w, http.StatusNotFound, apiErrNotFound, "secret not found")
+	}
+	return "", storedSecret{}, false
+}
+
+// decryptAPISecret decrypts stored with the key part of a link. If the key doesn't
+// decrypt the secret, an error response is written and false is returned.
+func (cfg routerConfig) decryptAPISecret(w http.ResponseWriter, r *http.Request, publicID, publicKey string, stored storedSecret) (string, bool) {
+	if publicKey == "" {
+		writeAPIError(w, http.StatusBadRequest, apiErrInvalidRequest, "key is required")
+		return "", false
+	}
+
+	plaintext, err := decryptWithLinkKey(publicID, publicKey, cfg.redisKeyPrefix, stored)
+	if err != nil {
+		cfg.recordProbe(r, probeInvalidKey)
+		writeAPIError(w, http.StatusForbidden, apiErrInvalidKey, "key does not decrypt this secret")
+		return "", false
+	}
+
+	return plaintext, true
+}
+
+// decryptWithLinkKey decrypts stored with the key part of the link whose ID part is
+// publicID.
+func decryptWithLinkKey(publicID, publicKey, keyPrefix string, stored storedSecret) (string, error) {
+	_, key, err := parseToken(joinToken(publicID, publicKey, keyPrefix), keyPrefix)
+	if err != nil {
+		return "", err
+	}
+
+	return Decrypt(stored.Token, key)
+}
+
+// describeSecret returns the API description of stored, which expires in ttl.
+func describeSecret(publicID string, stored storedSecret, ttl time.Duration) apiV2Secret {
+	now := time.Now()
+	desc := apiV2Secret{
+		ID:         publicID,
+		Kind:       apiKindText,
+		Label:      stored.Label,
+		Status:     apiStatusActive,
+		TTL:        int(ttl.Round(time.Second) / time.Second),
+		ExpiresAt:  now.Add(ttl).UTC().Truncate(time.Second),
+		Restricted: stored.Restriction != nil,
+	}
+
+	if stored.Kind == secretKindFields {
+		desc.Kind = apiKindFields
+	}
+
+	if stored.NotBefore != 0 {
+		notBefore := stored.activatesAt().UTC()
+		desc.NotBefore = &notBefore
+		if !stored.activeAt(now) {
+			desc.Status = apiStatusPending
+		}
+	}
+
+	return desc
+}
+
+// decodeAPIRequest decodes the JSON body of r into v. If the body can't be decoded,
+// an error response is written and false is returned.
+func decodeAPIRequest(w http.ResponseWriter, r *http.Request, v any) bool {
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		writeAPIError(w, http.StatusUnsupportedMediaType, apiErrUnsupportedMediaType, "request body must be application/json")
+		return false
+	}
+
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAPIRequestBytes))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeAPIError(w, http.StatusRequestEntityTooLarge, apiErrInvalidRequest, "request body is too large")
+			return false
+		}
+
+		msg := "request body is not valid JSON"
+		if err != io.EOF {
+			msg += ": " + err.Error()
+		}
+		writeAPIError(w, http.StatusBadRequest, apiErrInvalidRequest, msg)
+		return false
+	}
+
+	return true
+}
+
+// writeAPIError writes a v2 API error response.
+func writeAPIError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, apiV2Error{Error: apiV2ErrorDetail{Code: code, Message: message}})
+}
+
+// writeAPIGone writes the response for a secret that no longer exists for reason.
+func writeAPIGone(w http.ResponseWriter, reason string) {
+	writeJSON(w, http.StatusGone, apiV2Error{Error: apiV2ErrorDetail{
+		Code:    apiErrGone,
+		Message: "the secret no longer exists",
+		Reason:  reason,
+	}})
+}
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/concerthall/gosnappass/internal/apitoken"
+	"github.com/gorilla/mux"
+	"golang.org/x/exp/slog"
+)
+
+var apiPrincipalContextKey contextKey = "apiPrincipal"
+
+// errInvalidCredentials is returned by an apiAuthenticator when a request carries
+// credentials it recognizes, but which are not valid.
+var errInvalidCredentials = errors.New("invalid credentials")
+
+// apiPrincipal is an authenticated API client.
+type apiPrincipal struct {
+	// method is how the client authenticated, e.g. "token".
+	method string
+	// name identifies the client in logs. It is never a credential.
+	name   string
+	scopes []string
+}
+
+// allows returns true if the principal was granted scope.
+func (p *apiPrincipal) allows(scope string) bool {
+	for _, s := range p.scopes {
+		if s == scope || s == apitoken.ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// String identifies the principal in logs.
+func (p *apiPrincipal) String() string {
+	return p.method + ":" + p.name
+}
+
+// apiAuthenticator authenticates API clients with one kind of credential.
+type apiAuthenticator interface {
+	// authenticate returns the principal of r, or nil if r carries no credential of
+	// the kind the authenticator handles. errInvalidCredentials is returned for
+	// credentials of that kind which are not valid.
+	authenticate(r *http.Request) (*apiPrincipal, error)
+}
+
+// apiTokenAuthenticator authenticates bearer tokens minted with the token command.
+type apiTokenAuthenticator struct {
+	store *apitoken.Store
+}
+
+func (a apiTokenAuthenticator) authenticate(r *http.Request) (*apiPrincipal, error) {
+	bearer := bearerToken(r)
+	if !strings.HasPrefix(bearer, apitoken.Prefix) {
+		return nil, nil
+	}
+
+	// A tokens file that fails to load, e.g. while it is being replaced by hand,
+	// doesn't lock clients out: the tokens loaded before are still verified.
+	if err := a.store.Reload(); err != nil {
+		slog.FromContext(r.Context()).Error("unable to reload API tokens, verifying those loaded before", err)
+	}
+
+	token, ok := a.store.Verify(bearer)
+	if !ok {
+		return nil, errInvalidCredentials
+	}
+
+	return &apiPrincipal{method: "token", name: token.Name, scopes: token.Scopes}, nil
+}
+
+// bearerToken returns the bearer token in the Authorization header of r, if any.
+func bearerToken(r *http.Request) string {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// newAPIAuthMW generates a middleware authenticating API requests with the first of
+// authenticators that recognizes their credentials. Requests without credentials are
+// passed on unauthenticated, and rejected by requireScope if they reach an endpoint
+// that needs them. Requests with invalid credentials are rejected.
+//
+// Requests for pages are never authenticated, so that credentials browsers send on
+// their own, such as client certificates, can't act on behalf of their users.
+func newAPIAuthMW(authenticators []apiAuthenticator) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !strings.HasPrefix(r.URL.Path, "/api/") {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			logger := slog.FromContext(r.Context())
+			for _, a := range authenticators {
+				p, err := a.authenticate(r)
+				if err == errInvalidCredentials {
+					writeAPIUnauthorized(w, "invalid credentials")
+					return
+				}
+
+				if err != nil {
+					logger.Error("unable to authenticate request", err)
+					writeAPIError(w, http.StatusInternalServerError, apiErrInternal, "unable to authenticate request")
+					return
+				}
+
+				if p != nil {
+					setLoggedPrincipal(r.Context(), p)
+					r = r.WithContext(context.WithValue(r.Context(), apiPrincipalContextKey, p))
+					break
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// principalFromContext returns the API client authenticated for the request with
+// ctx, or nil if there isn't one.
+func principalFromContext(ctx context.Context) *apiPrincipal {
+	p, _ := ctx.Value(apiPrincipalContextKey).(*apiPrincipal)
+	return p
+}
+
+// requireScope wraps next so that it is only served to API clients granted scope,
+// and which presented a client certificate if cfg requires one. Endpoints are open
+// to everyone when no authenticators are configured.
+func (cfg routerConfig) requireScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	if len(cfg.apiAuthenticators) == 0 {
+		return next
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if cfg.clientCertRequired && verifiedClientCert(r) == nil {
+			writeAPIUnauthorized(w, "a client certificate is required")
+			return
+		}
+
+		p := principalFromContext(r.Context())
+		if p == nil {
+			writeAPIUnauthorized(w, "authentication required")
+			return
+		}
+
+		if !p.allows(scope) {
+			slog.FromContext(r.Context()).Info("denied API request lacking scope", "principal", p.String(), "scope", scope)
+			writeAPIError(w, http.StatusForbidden, apiErrInsufficientScope, "the "+scope+" scope is required")
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// writeAPIUnauthorized writes the response for a request without valid credentials.
+func writeAPIUnauthorized(w http.ResponseWriter, message string) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="gosnappass"`)
+	writeAPIError(w, http.StatusUnauthorized, apiErrUnauthorized, message)
+}
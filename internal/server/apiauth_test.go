@@ -0,0 +1,92 @@
+package server
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/concerthall/gosnappass/internal/apitoken"
+)
+
+func TestAPITokens(t *testing.T) {
+	tokensFile := filepath.Join(t.TempDir(), "tokens.json")
+	creator, err := apitoken.Mint(tokensFile, "ci", []string{apitoken.ScopeCreate})
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin, err := apitoken.Mint(tokensFile, "ops", []string{apitoken.ScopeAdmin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := apitoken.NewStore(tokensFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logs := &syncBuffer{}
+	ts := newTestServer(t, WithAPITokens(store), LogTo(logs))
+	bearer := func(token string) http.Header {
+		return http.Header{"Authorization": {"Bearer " + token}}
+	}
+	create := func(t *testing.T, header http.Header) apiV2Secret {
+		t.Helper()
+		created := apiV2Secret{}
+		resp := ts.postJSON(t, "/api/v2/secrets", map[string]any{"secret": "s3cr3t"}, header, &created)
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("creating a secret: got status %d", resp.StatusCode)
+		}
+		return created
+	}
+
+	created := create(t, bearer(creator))
+	if !strings.Contains(logs.String(), `"event":"secret.created","actor":"token:ci"`) {
+		t.Errorf("the token wasn't audited: %s", logs)
+	}
+
+	reveal := func(t *testing.T, header http.Header) int {
+		t.Helper()
+		return ts.postJSON(t, "/api/v2/secrets/"+created.ID+"/reveal", apiV2KeyRequest{Key: created.Key}, header, nil).StatusCode
+	}
+
+	tests := []struct {
+		name   string
+		header http.Header
+		want   int
+	}{
+		{"without a token", nil, http.StatusUnauthorized},
+		{"unknown token", bearer(apitoken.Prefix + "forged"), http.StatusUnauthorized},
+		{"without the scope", bearer(creator), http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status := reveal(t, tt.header); status != tt.want {
+				t.Errorf("got status %d, want %d", status, tt.want)
+			}
+		})
+	}
+
+	// The tokens loaded before are still verified while the file is missing.
+	if err := os.Rename(tokensFile, tokensFile+".bak"); err != nil {
+		t.Fatal(err)
+	}
+	create(t, bearer(creator))
+	if !strings.Contains(logs.String(), "unable to reload API tokens") {
+		t.Errorf("the reload failure wasn't logged: %s", logs)
+	}
+	if err := os.Rename(tokensFile+".bak", tokensFile); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := apitoken.Revoke(tokensFile, "ci"); err != nil {
+		t.Fatal(err)
+	}
+	if resp := ts.postJSON(t, "/api/v2/secrets", map[string]any{"secret": "s3cr3t"}, bearer(creator), nil); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("with a revoked token: got status %d", resp.StatusCode)
+	}
+
+	if status := reveal(t, bearer(admin)); status != http.StatusOK {
+		t.Errorf("with the admin scope: got status %d", status)
+	}
+}
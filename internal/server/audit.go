@@ -0,0 +1,40 @@
+package server
+
+import (
+	"context"
+	"net/http"
+
+	"golang.org/x/exp/slog"
+)
+
+// Audit events, recorded for each secret and login.
+const (
+	auditSecretCreated  = "secret.created"
+	auditSecretRevealed = "secret.revealed"
+	auditSecretDeleted  = "secret.deleted"
+	auditUserLogin      = "user.login"
+	auditUserLogout     = "user.logout"
+)
+
+// anonymousActor is the actor of requests made without credentials or a login.
+const anonymousActor = "anonymous"
+
+// auditEvent records event, performed by actor, in the log of ctx. Events never
+// contain secrets, keys or labels, only the database IDs of secrets.
+func auditEvent(ctx context.Context, event, actor string, args ...any) {
+	slog.FromContext(ctx).Info("audit", append([]any{"event", event, "actor", actor}, args...)...)
+}
+
+// actor identifies who made r in audit events: its API credential, the user logged
+// in, or anonymousActor.
+func (cfg routerConfig) actor(r *http.Request) string {
+	if p := principalFromContext(r.Context()); p != nil {
+		return p.String()
+	}
+
+	if id := cfg.identity(r); id != nil {
+		return "user:" + id.name()
+	}
+
+	return anonymousActor
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// base58Alphabet is the bitcoin base58 alphabet. It leaves out 0, O, I and l, which
+// are easily confused when read aloud or typed by hand.
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+var bigRadix = big.NewInt(58)
+
+// base58Encode encodes b using base58Alphabet. Leading zero bytes are encoded as
+// leading '1' characters.
+func base58Encode(b []byte) string {
+	n := new(big.Int).SetBytes(b)
+	mod := new(big.Int)
+
+	out := []byte{}
+	for n.Sign() > 0 {
+		n.DivMod(n, bigRadix, mod)
+		out = append(out, base58Alphabet[mod.Int64()])
+	}
+
+	for _, c := range b {
+		if c != 0 {
+			break
+		}
+		out = append(out, base58Alphabet[0])
+	}
+
+	// digits were produced least significant first
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+
+	return string(out)
+}
+
+// base58Decode decodes s into exactly size bytes. It returns an error if s contains
+// characters outside of base58Alphabet or encodes more than size bytes.
+func base58Decode(s string, size int) ([]byte, error) {
+	n := new(big.Int)
+	for _, c := range s {
+		i := strings.IndexRune(base58Alphabet, c)
+		if i < 0 {
+			return nil, fmt.Errorf("invalid base58 character %q", c)
+		}
+		n.Mul(n, bigRadix)
+		n.Add(n, big.NewInt(int64(i)))
+	}
+
+	b := n.Bytes()
+	if len(b) > size {
+		return nil, fmt.Errorf("base58 value exceeds %d bytes", size)
+	}
+
+	out := make([]byte, size)
+	copy(out[size-len(b):], b)
+	return out, nil
+}
@@ -0,0 +1,152 @@
+package server
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+
+	"github.com/concerthall/gosnappass/internal/apitoken"
+)
+
+// ClientCertRule grants scopes to client certificates whose subject and SANs match.
+type ClientCertRule struct {
+	// Name describes the rule in logs.
+	Name string `json:"name"`
+	// Subject is a pattern the distinguished name of the subject must match, e.g.
+	// "CN=deployer,O=Example". Patterns use the syntax of path.Match.
+	Subject string `json:"subject,omitempty"`
+	// SAN is a pattern any of the DNS names, email addresses, IP addresses or URIs of
+	// the certificate must match, e.g. "spiffe://mesh.example/ns/prod/sa/*".
+	SAN    string   `json:"san,omitempty"`
+	Scopes []string `json:"scopes"`
+}
+
+// LoadClientCertRules reads the JSON file at file, of the form {"rules": [...]}.
+func LoadClientCertRules(file string) ([]ClientCertRule, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	f := struct {
+		Rules []ClientCertRule `json:"rules"`
+	}{}
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("unable to parse client certificate rules file %s: %w", file, err)
+	}
+
+	for _, rule := range f.Rules {
+		if rule.Subject == "" && rule.SAN == "" {
+			return nil, fmt.Errorf("client certificate rule %q must match a subject or a SAN", rule.Name)
+		}
+
+		for _, pattern := range []string{rule.Subject, rule.SAN} {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("client certificate rule %q has an invalid pattern %q", rule.Name, pattern)
+			}
+		}
+
+		for _, scope := range rule.Scopes {
+			if !apitoken.ValidScope(scope) {
+				return nil, fmt.Errorf("client certificate rule %q grants an unknown scope %q", rule.Name, scope)
+			}
+		}
+	}
+
+	return f.Rules, nil
+}
+
+// ClientCertAuthenticator authenticates API clients with certificates signed by a
+// trusted CA, presented during the TLS handshake. The scopes of a certificate are
+// granted by the rules it matches. Certificates are only requested when the server
+// serves TLS itself, see WithTLS.
+type ClientCertAuthenticator struct {
+	clientCAs *x509.CertPool
+	rules     []ClientCertRule
+}
+
+// NewClientCertAuthenticator returns an authenticator trusting the certificates
+// signed by the CAs in the PEM bundle clientCAFile, granted scopes by rules.
+func NewClientCertAuthenticator(clientCAFile string, rules []ClientCertRule) (*ClientCertAuthenticator, error) {
+	clientCAs, err := loadCertPool(clientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	return &ClientCertAuthenticator{clientCAs: clientCAs, rules: rules}, nil
+}
+
+func (a *ClientCertAuthenticator) authenticate(r *http.Request) (*apiPrincipal, error) {
+	cert := verifiedClientCert(r)
+	if cert == nil {
+		return nil, nil
+	}
+
+	p := &apiPrincipal{method: "cert", name: clientCertName(cert)}
+	for _, rule := range a.rules {
+		if rule.matches(cert) {
+			p.scopes = append(p.scopes, rule.Scopes...)
+		}
+	}
+
+	return p, nil
+}
+
+// matches returns true if cert matches every pattern of the rule.
+func (rule ClientCertRule) matches(cert *x509.Certificate) bool {
+	if rule.Subject != "" {
+		if ok, _ := path.Match(rule.Subject, cert.Subject.String()); !ok {
+			return false
+		}
+	}
+
+	if rule.SAN != "" {
+		for _, san := range clientCertSANs(cert) {
+			if ok, _ := path.Match(rule.SAN, san); ok {
+				return true
+			}
+		}
+		return false
+	}
+
+	return true
+}
+
+// verifiedClientCert returns the certificate the client of r presented, if the TLS
+// handshake verified it against the trusted CAs.
+func verifiedClientCert(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return r.TLS.VerifiedChains[0][0]
+}
+
+// clientCertSANs returns the subject alternative names of cert, URIs first, since
+// service meshes identify workloads by URI.
+func clientCertSANs(cert *x509.Certificate) []string {
+	sans := []string{}
+	for _, uri := range cert.URIs {
+		sans = append(sans, uri.String())
+	}
+	sans = append(sans, cert.DNSNames...)
+	sans = append(sans, cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	return sans
+}
+
+// clientCertName identifies the client of cert in logs by its subject, or else by
+// its first SAN, since certificates of service meshes often have an empty subject.
+func clientCertName(cert *x509.Certificate) string {
+	if subject := cert.Subject.String(); subject != "" {
+		return subject
+	}
+
+	if sans := clientCertSANs(cert); len(sans) > 0 {
+		return sans[0]
+	}
+	return ""
+}
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCA issues the certificates of TLS tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns a certificate signed by the CA from template, with its key, both
+// PEM encoded.
+func (ca *testCA) issue(t *testing.T, template *x509.Certificate) (certPEM, keyPEM []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	template.SerialNumber = serial
+	template.NotBefore, template.NotAfter = time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// writeFile writes b to a file named name in dir, and returns its path.
+func writeFile(t *testing.T, dir, name string, b []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, b, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// newClientCertTestServer serves a server with opts over TLS, authenticating API
+// clients with certificates of the returned CA that match rules. Its client
+// presents a certificate of the CA for the URI
+// spiffe://mesh.example/ns/prod/sa/deployer.
+func newClientCertTestServer(t *testing.T, rules []ClientCertRule, opts ...ServerOption) *testServer {
+	t.Helper()
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	caFile := writeFile(t, dir, "ca.pem", ca.pem)
+
+	serverCert, serverKey := ca.issue(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "snappass.test"},
+		DNSNames:    []string{"snappass.test"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	certs, err := NewCertReloader(writeFile(t, dir, "server.pem", serverCert), writeFile(t, dir, "server-key.pem", serverKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authenticator, err := NewClientCertAuthenticator(caFile, rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := newTestServer(t, append([]ServerOption{WithTLS(certs), WithClientCertAuthenticator(authenticator)}, opts...)...)
+	ts.Close()
+	ts.Server = httptest.NewUnstartedServer(ts.srv.Handler())
+	ts.Server.TLS = servingTLSConfig(certs, ts.srv.tlsMinVersion, nil, authenticator.clientCAs)
+	ts.StartTLS()
+	t.Cleanup(ts.Close)
+
+	clientCert, clientKey := ca.issue(t, &x509.Certificate{
+		URIs:        []*url.URL{{Scheme: "spiffe", Host: "mesh.example", Path: "/ns/prod/sa/deployer"}},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	keyPair, err := tls.X509KeyPair(clientCert, clientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	ts.client.Transport = &http.Transport{TLSClientConfig: &tls.Config{
+		RootCAs:      roots,
+		ServerName:   "snappass.test",
+		Certificates: []tls.Certificate{keyPair},
+	}}
+	return ts
+}
+
+func TestClientCertAuthentication(t *testing.T) {
+	logs := &syncBuffer{}
+	ts := newClientCertTestServer(t, []ClientCertRule{
+		{Name: "deployers", SAN: "spiffe://mesh.example/ns/prod/sa/*", Scopes: []string{"create"}},
+	}, LogTo(logs))
+
+	created := ts.createSecret(t, "s3cr3t")
+	if !strings.Contains(logs.String(), `"event":"secret.created","actor":"cert:spiffe://mesh.example/ns/prod/sa/deployer"`) {
+		t.Errorf("the certificate wasn't audited: %s", logs)
+	}
+
+	resp := ts.postJSON(t, "/api/v2/secrets/"+created.ID+"/reveal", apiV2KeyRequest{Key: created.Key}, nil, nil)
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("revealing without the reveal scope: got status %d", resp.StatusCode)
+	}
+
+	withoutCert := ts.client.Transport.(*http.Transport).Clone()
+	withoutCert.TLSClientConfig.Certificates = nil
+	ts.client.Transport = withoutCert
+	resp = ts.postJSON(t, "/api/v2/secrets", map[string]any{"secret": "s3cr3t"}, nil, nil)
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("without a certificate: got status %d", resp.StatusCode)
+	}
+}
+
+// TestClientCertsDontBypassCSRF checks that pages ignore client certificates, which
+// browsers present on their own, even with requests forged by other sites.
+func TestClientCertsDontBypassCSRF(t *testing.T) {
+	ts := newClientCertTestServer(t, []ClientCertRule{
+		{Name: "admins", SAN: "spiffe://mesh.example/ns/prod/sa/*", Scopes: []string{"admin"}},
+	})
+	created := ts.createSecret(t, "s3cr3t")
+
+	form := http.Header{"Content-Type": {"application/x-www-form-urlencoded"}, "Origin": {"https://attacker.example"}}
+	create := url.Values{"password": {"s3cr3t"}, "ttl": {"hour"}}
+	if resp, _ := ts.do(t, http.MethodPost, "/", strings.NewReader(create.Encode()), form); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("creating from another site: got status %d", resp.StatusCode)
+	}
+
+	resp, body := ts.do(t, http.MethodPost, "/"+tokenOf(created.Link), nil, form)
+	if resp.StatusCode != http.StatusForbidden || strings.Contains(body, "s3cr3t") {
+		t.Errorf("revealing from another site: got status %d", resp.StatusCode)
+	}
+
+	if resp, body := ts.submitForm(t, "/"+tokenOf(created.Link), nil); resp.StatusCode != http.StatusOK || !strings.Contains(body, "s3cr3t") {
+		t.Errorf("revealing with the form: got status %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/concerthall/gosnappass/internal/view"
+	"golang.org/x/exp/slog"
+)
+
+// CreatorPolicy allows users logged in with the identity provider to create secrets.
+// A user is allowed if they belong to any of Groups, or their email address is in
+// any of Domains. When both are empty, every user who logs in is allowed.
+type CreatorPolicy struct {
+	Groups []string
+	// Domains are email domains, e.g. "corp.example". Addresses the provider didn't
+	// mark as verified never match.
+	Domains []string
+}
+
+// allows returns true if id may create secrets. A nil identity is never allowed.
+func (p *CreatorPolicy) allows(id *identity) bool {
+	if id == nil {
+		return false
+	}
+
+	if len(p.Groups) == 0 && len(p.Domains) == 0 {
+		return true
+	}
+
+	for _, g := range p.Groups {
+		for _, member := range id.Groups {
+			if g == member {
+				return true
+			}
+		}
+	}
+
+	at := strings.LastIndex(id.Email, "@")
+	if at < 0 || !id.EmailVerified {
+		return false
+	}
+
+	domain := id.Email[at+1:]
+	for _, d := range p.Domains {
+		if strings.EqualFold(d, domain) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// logoutCSRFToken returns the CSRF token of the form logging out the user of r, or
+// an empty string if the user can't log out, because they aren't logged in with
+// cfg's provider.
+func (cfg routerConfig) logoutCSRFToken(r *http.Request) string {
+	if cfg.oidc == nil || cfg.sessions.identity(r) == nil {
+		return ""
+	}
+	return csrfToken(r.Context())
+}
+
+// requireCreator wraps next, the handler of a page for creating secrets, so that
+// users are sent to log in first, and only users allowed by cfg's creator policy
+// are served. Everyone is served without a policy.
+func (cfg routerConfig) requireCreator(next http.HandlerFunc) http.HandlerFunc {
+	if cfg.creators == nil {
+		return next
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := cfg.identity(r)
+		if id == nil && cfg.oidc != nil {
+			loginRedirect(w, r)
+			return
+		}
+
+		if !cfg.creators.allows(id) {
+			logger := slog.FromContext(r.Context())
+			name := ""
+			if id != nil {
+				name = id.name()
+			}
+			logger.Info("denied creating a secret", "user", name)
+
+			w.WriteHeader(http.StatusForbidden)
+			if err := view.CreateNotAllowed(w, name, cfg.logoutCSRFToken(r)); err != nil {
+				logger.Error("unable to render view CreateNotAllowed", err)
+			}
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// requireCreatorAPI wraps next, the handler of an API endpoint creating secrets, so
+// that it is only served to API clients authenticated by a credential, or to users
+// logged in and allowed by cfg's creator policy. Credentials are limited by their
+// scopes instead, see requireScope.
+func (cfg routerConfig) requireCreatorAPI(next http.HandlerFunc) http.HandlerFunc {
+	if cfg.creators == nil {
+		return next
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if principalFromContext(r.Context()) != nil {
+			next(w, r)
+			return
+		}
+
+		id := cfg.identity(r)
+		if id == nil {
+			writeAPIError(w, http.StatusUnauthorized, apiErrLoginRequired, "creating secrets requires login")
+			return
+		}
+
+		if !cfg.creators.allows(id) {
+			slog.FromContext(r.Context()).Info("denied creating a secret", "user", id.name())
+			writeAPIError(w, http.StatusForbidden, apiErrForbidden, "not allowed to create secrets")
+			return
+		}
+
+		next(w, r)
+	}
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/concerthall/gosnappass/internal/view"
+	"golang.org/x/exp/slog"
+)
+
+const (
+	// csrfCookieName holds the token that forms must submit back.
+	csrfCookieName = "gosnappass_csrf"
+	// csrfFieldName is the form field carrying the token.
+	csrfFieldName = "csrf_token"
+	// csrfHeaderName is the header carrying the token, for scripts that don't submit
+	// a form.
+	csrfHeaderName = "X-CSRF-Token"
+
+	// csrfLifetime is how long a page can be left open before submitting it fails.
+	csrfLifetime = 24 * time.Hour
+)
+
+var csrfTokenContextKey contextKey = "csrfToken"
+
+// csrfProtect wraps next, the handler of a browser page, so that unsafe requests are
+// only served when they carry the token of the CSRF cookie and don't come from
+// another origin. The cookie is signed, and set on the first request without one.
+//
+// The API isn't wrapped. It only accepts JSON, which browsers don't send across
+// origins without the server's consent, and authenticates with bearer tokens rather
+// than cookies. Requests to pages with a bearer token in their Authorization header
+// are exempt too, since browsers never add one on their own. Credentials browsers do
+// send on their own, such as cookies and client certificates, never exempt requests.
+func (cfg routerConfig) csrfProtect(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := slog.FromContext(r.Context())
+
+		token := ""
+		if err := cfg.sessions.get(r, csrfCookieName, &token); err != nil {
+			token = ""
+		}
+
+		// A cookie that is only being set now can't have been submitted.
+		submittable := token
+		if token == "" {
+			b, err := randomBytes(32)
+			if err != nil {
+				logger.Error("unable to generate CSRF token", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			token = base64.RawURLEncoding.EncodeToString(b)
+			if err := cfg.sessions.set(w, csrfCookieName, token, csrfLifetime); err != nil {
+				logger.Error("unable to set CSRF cookie", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+		}
+
+		if !isSafeMethod(r.Method) && bearerToken(r) == "" {
+			if reason := cfg.csrfCheck(r, submittable); reason != "" {
+				logger.Info("rejected request failing CSRF checks", "reason", reason)
+				w.WriteHeader(http.StatusForbidden)
+				if err := view.RequestNotVerified(w); err != nil {
+					logger.Error("unable to render view RequestNotVerified", err)
+				}
+				return
+			}
+		}
+
+		next(w, r.WithContext(context.WithValue(r.Context(), csrfTokenContextKey, token)))
+	}
+}
+
+// csrfCheck returns why r fails the CSRF checks against the token of its cookie, or
+// an empty string if it passes them.
+func (cfg routerConfig) csrfCheck(r *http.Request, token string) string {
+	if !cfg.sameOrigin(r) {
+		return "cross-origin request"
+	}
+
+	if token == "" {
+		return "missing or expired cookie"
+	}
+
+	submitted := r.Header.Get(csrfHeaderName)
+	if submitted == "" {
+		submitted = r.PostFormValue(csrfFieldName)
+	}
+
+	if subtle.ConstantTimeCompare([]byte(submitted), []byte(token)) != 1 {
+		return "missing or mismatched token"
+	}
+
+	return ""
+}
+
+// sameOrigin returns false if the Origin header of r, or else its Referer, names
+// another host than the application's. Requests carrying neither are accepted, and
+// left to the token check.
+func (cfg routerConfig) sameOrigin(r *http.Request) bool {
+	source := r.Header.Get("Origin")
+	if source == "" {
+		source = r.Header.Get("Referer")
+	}
+
+	if source == "" {
+		return true
+	}
+
+	// Browsers send an Origin of "null" from sandboxed and privacy sensitive contexts,
+	// which has no host and so never matches.
+	u, err := url.Parse(source)
+	if err != nil {
+		return false
+	}
+
+	return strings.EqualFold(u.Host, r.Host) || strings.EqualFold(u.Host, requestHost(r)) || (cfg.hostOverride != "" && strings.EqualFold(u.Host, cfg.hostOverride))
+}
+
+// csrfToken returns the token that forms served with ctx must submit.
+func csrfToken(ctx context.Context) string {
+	token, _ := ctx.Value(csrfTokenContextKey).(string)
+	return token
+}
+
+// isSafeMethod returns true for methods that must not change state, and so need no
+// CSRF protection.
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/concerthall/gosnappass/internal/apitoken"
+)
+
+func TestCSRFProtection(t *testing.T) {
+	tokensFile := filepath.Join(t.TempDir(), "tokens.json")
+	token, err := apitoken.Mint(tokensFile, "ci", []string{apitoken.ScopeCreate})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := apitoken.NewStore(tokensFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := newTestServer(t, WithAPITokens(store))
+
+	form := url.Values{"password": {"s3cr3t"}, "ttl": {"hour"}}
+	post := func(t *testing.T, form url.Values, header http.Header) int {
+		t.Helper()
+		h := http.Header{"Content-Type": {"application/x-www-form-urlencoded"}}
+		for name, values := range header {
+			h[name] = values
+		}
+		resp, _ := ts.do(t, http.MethodPost, "/", strings.NewReader(form.Encode()), h)
+		return resp.StatusCode
+	}
+
+	t.Run("form with its token", func(t *testing.T) {
+		if resp, _ := ts.submitForm(t, "/", url.Values{"password": {"s3cr3t"}, "ttl": {"hour"}}); resp.StatusCode != http.StatusOK {
+			t.Errorf("got status %d", resp.StatusCode)
+		}
+	})
+
+	t.Run("without a token", func(t *testing.T) {
+		if status := post(t, form, nil); status != http.StatusForbidden {
+			t.Errorf("got status %d", status)
+		}
+	})
+
+	t.Run("from another origin", func(t *testing.T) {
+		withToken := url.Values{"password": {"s3cr3t"}, "ttl": {"hour"}, csrfFieldName: {ts.csrfToken(t, "/")}}
+		if status := post(t, withToken, http.Header{"Origin": {"https://attacker.example"}}); status != http.StatusForbidden {
+			t.Errorf("got status %d", status)
+		}
+	})
+
+	t.Run("bearer token", func(t *testing.T) {
+		if status := post(t, form, http.Header{"Authorization": {"Bearer " + token}}); status != http.StatusOK {
+			t.Errorf("got status %d", status)
+		}
+	})
+
+	t.Run("basic credentials", func(t *testing.T) {
+		if status := post(t, form, http.Header{"Authorization": {"Basic dXNlcjpwYXNz"}}); status != http.StatusForbidden {
+			t.Errorf("got status %d", status)
+		}
+	})
+}
+
+func TestCSRFTokensSpanServersWithTheSessionSecret(t *testing.T) {
+	tests := []struct {
+		name   string
+		secret string
+		want   int
+	}{
+		{"same secret", "session-secret", http.StatusOK},
+		{"another secret", "another-secret", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			served := newTestServer(t, WithSessionSecret("session-secret"))
+			other := newTestServer(t, WithSessionSecret(tt.secret))
+
+			// Cookies aren't scoped to a port, so the replicas share the cookie of the
+			// form.
+			other.client.Jar = served.client.Jar
+			form := url.Values{"password": {"s3cr3t"}, "ttl": {"hour"}, csrfFieldName: {served.csrfToken(t, "/")}}
+			resp, _ := other.do(t, http.MethodPost, "/", strings.NewReader(form.Encode()), http.Header{"Content-Type": {"application/x-www-form-urlencoded"}})
+			if resp.StatusCode != tt.want {
+				t.Errorf("got status %d, want %d", resp.StatusCode, tt.want)
+			}
+		})
+	}
+}
@@ -1,26 +1,20 @@
 package server
 
 import (
+	"encoding/json"
 	"errors"
 
 	"github.com/fernet/fernet-go"
 )
 
-// Encrypt takes the plaintext secret, generates a key and produces a
-// token.
-func Encrypt(secret string) (token, key string, err error) {
-	fernetkey := fernet.Key{}
-	err = fernetkey.Generate()
+// EncryptWithKey produces a token from the plaintext secret using key.
+func EncryptWithKey(secret string, key *fernet.Key) (token string, err error) {
+	tokenBytes, err := fernet.EncryptAndSign([]byte(secret), key)
 	if err != nil {
-		return "", "", err
-	}
-
-	tokenBytes, err := fernet.EncryptAndSign([]byte(secret), &fernetkey)
-	if err != nil {
-		return "", "", err
+		return "", err
 	}
 
-	return string(tokenBytes), fernetkey.Encode(), nil
+	return string(tokenBytes), nil
 }
 
 // Decrypt decodes key and decrypts token with it.
@@ -37,3 +31,41 @@ func Decrypt(token string, key string) (string, error) {
 
 	return string(msg), nil
 }
+
+// SecretField is a single named value within a structured secret, such as the
+// username or password of a credential.
+type SecretField struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// structuredSecret is the plaintext payload encrypted for structured secrets.
+type structuredSecret struct {
+	Fields []SecretField `json:"fields"`
+}
+
+// encodeFields returns the plaintext payload of a structured secret.
+func encodeFields(fields []SecretField) (string, error) {
+	payload, err := json.Marshal(structuredSecret{Fields: fields})
+	if err != nil {
+		return "", err
+	}
+
+	return string(payload), nil
+}
+
+// DecryptFields decrypts token with key and decodes the structured secret it
+// contains.
+func DecryptFields(token string, key string) ([]SecretField, error) {
+	payload, err := Decrypt(token, key)
+	if err != nil {
+		return nil, err
+	}
+
+	s := structuredSecret{}
+	if err := json.Unmarshal([]byte(payload), &s); err != nil {
+		return nil, err
+	}
+
+	return s.Fields, nil
+}
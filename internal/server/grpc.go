@@ -0,0 +1,385 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	gosnappassv1 "github.com/concerthall/gosnappass/api/gosnappass/v1"
+	"github.com/google/uuid"
+	"golang.org/x/exp/slog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// grpcStates maps the reasons recorded in tombstones to the state of the secret.
+var grpcStates = map[string]gosnappassv1.State{
+	goneExpired:  gosnappassv1.State_STATE_EXPIRED,
+	goneRevealed: gosnappassv1.State_STATE_REVEALED,
+	goneDeleted:  gosnappassv1.State_STATE_REVOKED,
+}
+
+// grpcStateVerbs describes the reasons recorded in tombstones in error messages.
+var grpcStateVerbs = map[string]string{
+	goneExpired:  "expired",
+	goneRevealed: "revealed",
+	goneDeleted:  "revoked",
+}
+
+// secretService implements the gRPC SecretService on the same database as the HTTP
+// handlers.
+type secretService struct {
+	gosnappassv1.UnimplementedSecretServiceServer
+	cfg routerConfig
+}
+
+// newGRPCServer returns a gRPC server for SecretService, authenticating clients with
+// the certificates required by creds.
+func newGRPCServer(logger *slog.Logger, creds credentials.TransportCredentials, cfg routerConfig) *grpc.Server {
+	s := grpc.NewServer(
+		grpc.Creds(creds),
+		grpc.UnaryInterceptor(newGRPCLogInterceptor(logger)),
+	)
+	gosnappassv1.RegisterSecretServiceServer(s, &secretService{cfg: cfg})
+	return s
+}
+
+// newGRPCLogInterceptor injects logger into the context of each call, and logs the
+// call once it is served, like logRequestMW does for HTTP requests.
+func newGRPCLogInterceptor(logger *slog.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		requestID := uuid.New()
+		ctx = context.WithValue(ctx, requestIDContextKey, requestID)
+		ctx = slog.NewContext(ctx, logger)
+
+		t := time.Now()
+		resp, err := handler(ctx, req)
+		logger.Info("rpc served",
+			"duration_microseconds", time.Since(t).Microseconds(),
+			"method", info.FullMethod,
+			"code", status.Code(err).String(),
+			"client", grpcClientName(ctx),
+			"requestID", requestID,
+		)
+		return resp, err
+	}
+}
+
+// grpcClientName returns the subject of the certificate the client authenticated
+// with.
+func grpcClientName(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.PeerCertificates) == 0 {
+		return ""
+	}
+
+	return tlsInfo.State.PeerCertificates[0].Subject.String()
+}
+
+// grpcActor identifies the client of a call in audit events by the subject of its
+// certificate.
+func grpcActor(ctx context.Context) string {
+	return "cert:" + grpcClientName(ctx)
+}
+
+func (s *secretService) CreateSecret(ctx context.Context, req *gosnappassv1.CreateSecretRequest) (*gosnappassv1.CreateSecretResponse, error) {
+	logger := slog.FromContext(ctx)
+
+	ttl := defaultAPIV2TTL * time.Second
+	if req.Ttl != nil {
+		if err := req.Ttl.CheckValid(); err != nil {
+			return nil, status.Error(codes.InvalidArgument, "ttl is invalid")
+		}
+		ttl = req.Ttl.AsDuration()
+	}
+	if ttl < time.Second || ttl > maxAPITTL*time.Second {
+		return nil, status.Error(codes.InvalidArgument, "ttl must be between 1 second and 2 weeks")
+	}
+
+	// The label is shown to anyone holding the link, so it is never logged.
+	label := strings.TrimSpace(req.Label)
+	if utf8.RuneCountInString(label) > s.cfg.labelMaxLength {
+		return nil, status.Error(codes.InvalidArgument, "label is too long")
+	}
+
+	restriction := parseRevealRestriction(strings.Join(req.RestrictTo, ","))
+	if restriction != nil && s.cfg.oidc == nil {
+		return nil, status.Error(codes.InvalidArgument, "restrict_to requires a configured identity provider")
+	}
+
+	var plaintext, kind string
+	switch content := req.Content.(type) {
+	case *gosnappassv1.CreateSecretRequest_Text:
+		if content.Text == "" {
+			return nil, status.Error(codes.InvalidArgument, "text must not be empty")
+		}
+		plaintext = content.Text
+	case *gosnappassv1.CreateSecretRequest_Fields:
+		fields := make([]SecretField, 0, len(content.Fields.GetFields()))
+		for _, f := range content.Fields.GetFields() {
+			if f.Name == "" {
+				return nil, status.Error(codes.InvalidArgument, "fields must have a name")
+			}
+			fields = append(fields, SecretField{Name: f.Name, Value: f.Value})
+		}
+		if len(fields) == 0 {
+			return nil, status.Error(codes.InvalidArgument, "fields must not be empty")
+		}
+
+		var err error
+		if plaintext, err = encodeFields(fields); err != nil {
+			logger.Error("error encoding structured secret", err)
+			return nil, status.Error(codes.Internal, "unable to store secret")
+		}
+		kind = secretKindFields
+	default:
+		return nil, status.Error(codes.InvalidArgument, "one of text and fields is required")
+	}
+
+	var notBefore time.Time
+	if req.NotBefore != nil {
+		if err := req.NotBefore.CheckValid(); err != nil {
+			return nil, status.Error(codes.InvalidArgument, "not_before is invalid")
+		}
+		if t := req.NotBefore.AsTime(); t.After(time.Now()) {
+			notBefore = t
+		}
+	}
+
+	secret := newSecret{
+		plaintext:   plaintext,
+		kind:        kind,
+		label:       label,
+		notBefore:   notBefore,
+		restriction: restriction,
+		ttl:         ttl,
+		creator:     grpcActor(ctx),
+	}
+
+	secretLink, err := createSecret(ctx, s.cfg, secret)
+	if err != nil {
+		logger.Error("unable to store secret", err)
+		return nil, status.Error(codes.Internal, "unable to store secret")
+	}
+
+	resp := &gosnappassv1.CreateSecretResponse{
+		Secret: grpcSecret(describeSecret(secretLink.publicID, secret.metadata(), secret.expiration())),
+		Key:    secretLink.publicKey,
+	}
+
+	// Without a request, the host of the link is only known when it is overridden.
+	if s.cfg.hostOverride != "" {
+		scheme := s.cfg.proto
+		if scheme == "" {
+			scheme = "http"
+		}
+		resp.Link = s.cfg.secretURLOnHost(scheme, s.cfg.hostOverride, secretLink.token)
+	}
+
+	return resp, nil
+}
+
+func (s *secretService) RevealSecret(ctx context.Context, req *gosnappassv1.RevealSecretRequest) (*gosnappassv1.RevealSecretResponse, error) {
+	logger := slog.FromContext(ctx)
+
+	id, stored, err := s.lookup(ctx, req.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	// Restricted secrets are revealed by users logged in to the web interface, which
+	// gRPC clients are not.
+	if stored.Restriction != nil {
+		return nil, status.Error(codes.PermissionDenied, "restricted secrets can only be revealed in the browser")
+	}
+
+	if !stored.activeAt(time.Now()) {
+		return nil, status.Error(codes.FailedPrecondition, "the secret can't be revealed until "+stored.activatesAt().UTC().Format(time.RFC3339))
+	}
+
+	plaintext, err := s.decrypt(req.Id, req.Key, stored)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := takeSecret(ctx, s.cfg.redisKeyPrefix, id, stored.Token); err != nil {
+		// another request revealed the secret after we looked it up.
+		if err == errSecretNotFound {
+			return nil, grpcGone(goneRevealed)
+		}
+
+		logger.Error("error deleting from the database: ", err)
+		return nil, status.Error(codes.Internal, "unable to reveal secret")
+	}
+	auditEvent(ctx, auditSecretRevealed, grpcActor(ctx), "secret", id)
+
+	resp := &gosnappassv1.RevealSecretResponse{Id: req.Id, Kind: gosnappassv1.Kind_KIND_TEXT, Label: stored.Label}
+	if stored.Kind == secretKindFields {
+		structured := structuredSecret{}
+		if err := json.Unmarshal([]byte(plaintext), &structured); err != nil {
+			logger.Error("error decoding structured secret", err)
+			return nil, status.Error(codes.Internal, "unable to decode secret")
+		}
+
+		fields := &gosnappassv1.Fields{}
+		for _, f := range structured.Fields {
+			fields.Fields = append(fields.Fields, &gosnappassv1.Field{Name: f.Name, Value: f.Value})
+		}
+		resp.Kind = gosnappassv1.Kind_KIND_FIELDS
+		resp.Content = &gosnappassv1.RevealSecretResponse_Fields{Fields: fields}
+	} else {
+		resp.Content = &gosnappassv1.RevealSecretResponse_Text{Text: plaintext}
+	}
+
+	return resp, nil
+}
+
+func (s *secretService) GetStatus(ctx context.Context, req *gosnappassv1.GetStatusRequest) (*gosnappassv1.GetStatusResponse, error) {
+	logger := slog.FromContext(ctx)
+
+	id, err := parseSecretID(req.Id, s.cfg.redisKeyPrefix)
+	if err != nil {
+		return nil, status.Error(codes.NotFound, "secret not found")
+	}
+
+	stored, err := lookupSecret(ctx, id)
+	if err == errSecretNotFound {
+		// Secrets that no longer exist are described by their state alone.
+		reason, gone, err := secretGone(ctx, s.cfg.redisKeyPrefix, id)
+		if err != nil {
+			logger.Error("unable to query tombstone from database", err)
+			return nil, status.Error(codes.Internal, "unable to query secret")
+		}
+		if !gone {
+			return nil, status.Error(codes.NotFound, "secret not found")
+		}
+
+		return &gosnappassv1.GetStatusResponse{Secret: &gosnappassv1.Secret{Id: req.Id, State: grpcStates[reason]}}, nil
+	}
+	if err != nil {
+		logger.Error("unable to query key from database", err)
+		return nil, status.Error(codes.Internal, "unable to query secret")
+	}
+
+	ttl, err := secretTTL(ctx, id)
+	if err != nil && err != errSecretNotFound {
+		logger.Error("unable to query key TTL from database", err)
+		return nil, status.Error(codes.Internal, "unable to query secret")
+	}
+
+	return &gosnappassv1.GetStatusResponse{Secret: grpcSecret(describeSecret(req.Id, stored, ttl))}, nil
+}
+
+func (s *secretService) RevokeSecret(ctx context.Context, req *gosnappassv1.RevokeSecretRequest) (*gosnappassv1.RevokeSecretResponse, error) {
+	logger := slog.FromContext(ctx)
+
+	id, stored, err := s.lookup(ctx, req.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.decrypt(req.Id, req.Key, stored); err != nil {
+		return nil, err
+	}
+
+	if err := deleteSecret(ctx, s.cfg.redisKeyPrefix, id); err != nil {
+		if err == errSecretNotFound {
+			return nil, grpcGone(goneRevealed)
+		}
+
+		logger.Error("unable to delete key from database", err)
+		return nil, status.Error(codes.Internal, "unable to revoke secret")
+	}
+	auditEvent(ctx, auditSecretDeleted, grpcActor(ctx), "secret", id)
+
+	return &gosnappassv1.RevokeSecretResponse{}, nil
+}
+
+// lookup returns the database ID and the stored secret identified by publicID, or a
+// status error if it doesn't exist.
+func (s *secretService) lookup(ctx context.Context, publicID string) (string, storedSecret, error) {
+	logger := slog.FromContext(ctx)
+
+	id, err := parseSecretID(publicID, s.cfg.redisKeyPrefix)
+	if err != nil {
+		return "", storedSecret{}, status.Error(codes.NotFound, "secret not found")
+	}
+
+	stored, err := lookupSecret(ctx, id)
+	if err == nil {
+		return id, stored, nil
+	}
+
+	if err != errSecretNotFound {
+		logger.Error("unable to query key from database", err)
+		return "", storedSecret{}, status.Error(codes.Internal, "unable to query secret")
+	}
+
+	reason, gone, err := secretGone(ctx, s.cfg.redisKeyPrefix, id)
+	if err != nil {
+		logger.Error("unable to query tombstone from database", err)
+		return "", storedSecret{}, status.Error(codes.Internal, "unable to query secret")
+	}
+
+	if gone {
+		return "", storedSecret{}, grpcGone(reason)
+	}
+	return "", storedSecret{}, status.Error(codes.NotFound, "secret not found")
+}
+
+// decrypt decrypts stored with the key part of its link, or returns a status error if
+// the key doesn't decrypt it.
+func (s *secretService) decrypt(publicID, publicKey string, stored storedSecret) (string, error) {
+	if publicKey == "" {
+		return "", status.Error(codes.InvalidArgument, "key is required")
+	}
+
+	plaintext, err := decryptWithLinkKey(publicID, publicKey, s.cfg.redisKeyPrefix, stored)
+	if err != nil {
+		return "", status.Error(codes.PermissionDenied, "key does not decrypt this secret")
+	}
+
+	return plaintext, nil
+}
+
+// grpcGone returns the status error for a secret that no longer exists for reason.
+func grpcGone(reason string) error {
+	return status.Errorf(codes.NotFound, "the secret was %s", grpcStateVerbs[reason])
+}
+
+// grpcSecret converts the API description of a secret to its gRPC message.
+func grpcSecret(desc apiV2Secret) *gosnappassv1.Secret {
+	secret := &gosnappassv1.Secret{
+		Id:         desc.ID,
+		Kind:       gosnappassv1.Kind_KIND_TEXT,
+		State:      gosnappassv1.State_STATE_ACTIVE,
+		Label:      desc.Label,
+		Ttl:        durationpb.New(time.Duration(desc.TTL) * time.Second),
+		ExpireTime: timestamppb.New(desc.ExpiresAt),
+		Restricted: desc.Restricted,
+	}
+
+	if desc.Kind == apiKindFields {
+		secret.Kind = gosnappassv1.Kind_KIND_FIELDS
+	}
+	if desc.Status == apiStatusPending {
+		secret.State = gosnappassv1.State_STATE_PENDING
+	}
+	if desc.NotBefore != nil {
+		secret.NotBefore = timestamppb.New(*desc.NotBefore)
+	}
+
+	return secret
+}
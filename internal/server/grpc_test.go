@@ -0,0 +1,274 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	gosnappassv1 "github.com/concerthall/gosnappass/api/gosnappass/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// grpcTestServer is a server under test, reached over gRPC in memory by a client
+// presenting a certificate for the subject CN=deployer.
+type grpcTestServer struct {
+	*testServer
+	client gosnappassv1.SecretServiceClient
+	// dial connects to the server with tlsConfig.
+	dial func(t *testing.T, tlsConfig *tls.Config) *grpc.ClientConn
+	// clientTLS is the TLS configuration of client.
+	clientTLS *tls.Config
+}
+
+// newGRPCTestServer serves the gRPC service of a server with opts until the test
+// ends, requiring mutual TLS as MutualTLSConfig sets it up.
+func newGRPCTestServer(t *testing.T, opts ...ServerOption) *grpcTestServer {
+	t.Helper()
+	dir := t.TempDir()
+	ca := newTestCA(t)
+
+	serverCert, serverKey := ca.issue(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "snappass.test"},
+		DNSNames:    []string{"snappass.test"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	serverTLS, err := MutualTLSConfig(writeFile(t, dir, "server.pem", serverCert), writeFile(t, dir, "server-key.pem", serverKey), writeFile(t, dir, "ca.pem", ca.pem))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := newTestServer(t, append([]ServerOption{WithGRPC("bufconn", serverTLS)}, opts...)...)
+	lis := bufconn.Listen(1 << 20)
+	go ts.srv.grpcServer.Serve(lis)
+	t.Cleanup(ts.srv.grpcServer.Stop)
+
+	clientCert, clientKey := ca.issue(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "deployer"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	keyPair, err := tls.X509KeyPair(clientCert, clientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	gts := &grpcTestServer{
+		testServer: ts,
+		clientTLS:  &tls.Config{RootCAs: roots, ServerName: "snappass.test", Certificates: []tls.Certificate{keyPair}},
+	}
+	gts.dial = func(t *testing.T, tlsConfig *tls.Config) *grpc.ClientConn {
+		t.Helper()
+		conn, err := grpc.DialContext(context.Background(), "bufconn",
+			grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+			grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+	gts.client = gosnappassv1.NewSecretServiceClient(gts.dial(t, gts.clientTLS))
+	return gts
+}
+
+// create creates a text secret with req, and returns the response.
+func (gts *grpcTestServer) create(t *testing.T, req *gosnappassv1.CreateSecretRequest) *gosnappassv1.CreateSecretResponse {
+	t.Helper()
+	if req.Content == nil {
+		req.Content = &gosnappassv1.CreateSecretRequest_Text{Text: "s3cr3t"}
+	}
+
+	resp, err := gts.client.CreateSecret(context.Background(), req)
+	if err != nil {
+		t.Fatalf("creating a secret: %v", err)
+	}
+	return resp
+}
+
+// state returns the state of the secret with id.
+func (gts *grpcTestServer) state(t *testing.T, id string) gosnappassv1.State {
+	t.Helper()
+	resp, err := gts.client.GetStatus(context.Background(), &gosnappassv1.GetStatusRequest{Id: id})
+	if err != nil {
+		t.Fatalf("getting the status: %v", err)
+	}
+	return resp.Secret.State
+}
+
+// expectCode fails the test unless err has code.
+func expectCode(t *testing.T, err error, code codes.Code) {
+	t.Helper()
+	if status.Code(err) != code {
+		t.Errorf("got error %v, want code %s", err, code)
+	}
+}
+
+func TestGRPCCreateSecret(t *testing.T) {
+	logs := &syncBuffer{}
+	gts := newGRPCTestServer(t, WithHostOverride("snappass.example.com"), WithProto("https"), WithLinkStyle(LinkStyleShort), LogTo(logs))
+	ctx := context.Background()
+
+	created := gts.create(t, &gosnappassv1.CreateSecretRequest{Label: " database ", Ttl: durationpb.New(time.Hour)})
+	if created.Link != "https://snappass.example.com/"+created.Secret.Id+"-"+created.Key {
+		t.Errorf("got link %q", created.Link)
+	}
+	if s := created.Secret; s.Label != "database" || s.Kind != gosnappassv1.Kind_KIND_TEXT || s.State != gosnappassv1.State_STATE_ACTIVE || s.Ttl.AsDuration() != time.Hour {
+		t.Errorf("got secret %+v", s)
+	}
+	if !strings.Contains(logs.String(), `"event":"secret.created","actor":"cert:CN=deployer"`) {
+		t.Errorf("the certificate wasn't audited: %s", logs)
+	}
+
+	for name, req := range map[string]*gosnappassv1.CreateSecretRequest{
+		"without content": {},
+		"empty text":      {Content: &gosnappassv1.CreateSecretRequest_Text{}},
+		"empty fields":    {Content: &gosnappassv1.CreateSecretRequest_Fields{Fields: &gosnappassv1.Fields{}}},
+		"ttl too long":    {Content: &gosnappassv1.CreateSecretRequest_Text{Text: "s3cr3t"}, Ttl: durationpb.New(15 * 24 * time.Hour)},
+		"label too long":  {Content: &gosnappassv1.CreateSecretRequest_Text{Text: "s3cr3t"}, Label: strings.Repeat("a", defaultLabelMaxLength+1)},
+		"restricted":      {Content: &gosnappassv1.CreateSecretRequest_Text{Text: "s3cr3t"}, RestrictTo: []string{"alice@corp.example"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := gts.client.CreateSecret(ctx, req)
+			expectCode(t, err, codes.InvalidArgument)
+		})
+	}
+}
+
+func TestGRPCRevealSecret(t *testing.T) {
+	gts := newGRPCTestServer(t)
+	ctx := context.Background()
+	created, other := gts.create(t, &gosnappassv1.CreateSecretRequest{}), gts.create(t, &gosnappassv1.CreateSecretRequest{})
+
+	_, err := gts.client.RevealSecret(ctx, &gosnappassv1.RevealSecretRequest{Id: created.Secret.Id, Key: other.Key})
+	expectCode(t, err, codes.PermissionDenied)
+	_, err = gts.client.RevealSecret(ctx, &gosnappassv1.RevealSecretRequest{Id: created.Secret.Id})
+	expectCode(t, err, codes.InvalidArgument)
+
+	revealed, err := gts.client.RevealSecret(ctx, &gosnappassv1.RevealSecretRequest{Id: created.Secret.Id, Key: created.Key})
+	if err != nil {
+		t.Fatalf("revealing after a wrong key: %v", err)
+	}
+	if revealed.GetText() != "s3cr3t" || revealed.Kind != gosnappassv1.Kind_KIND_TEXT {
+		t.Errorf("got %+v", revealed)
+	}
+
+	_, err = gts.client.RevealSecret(ctx, &gosnappassv1.RevealSecretRequest{Id: created.Secret.Id, Key: created.Key})
+	expectCode(t, err, codes.NotFound)
+
+	fields := gts.create(t, &gosnappassv1.CreateSecretRequest{Content: &gosnappassv1.CreateSecretRequest_Fields{Fields: &gosnappassv1.Fields{
+		Fields: []*gosnappassv1.Field{{Name: "username", Value: "admin"}, {Name: "password", Value: "s3cr3t"}},
+	}}})
+	revealed, err = gts.client.RevealSecret(ctx, &gosnappassv1.RevealSecretRequest{Id: fields.Secret.Id, Key: fields.Key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := revealed.GetFields().GetFields(); revealed.Kind != gosnappassv1.Kind_KIND_FIELDS || len(got) != 2 || got[0].Name != "username" || got[1].Value != "s3cr3t" {
+		t.Errorf("got %+v", revealed)
+	}
+}
+
+func TestGRPCRevealPendingSecret(t *testing.T) {
+	gts := newGRPCTestServer(t)
+	ctx := context.Background()
+
+	created := gts.create(t, &gosnappassv1.CreateSecretRequest{NotBefore: timestamppb.New(time.Now().Add(time.Hour))})
+	if created.Secret.State != gosnappassv1.State_STATE_PENDING || created.Secret.NotBefore == nil {
+		t.Errorf("got secret %+v", created.Secret)
+	}
+
+	_, err := gts.client.RevealSecret(ctx, &gosnappassv1.RevealSecretRequest{Id: created.Secret.Id, Key: created.Key})
+	expectCode(t, err, codes.FailedPrecondition)
+
+	if state := gts.state(t, created.Secret.Id); state != gosnappassv1.State_STATE_PENDING {
+		t.Errorf("after an early reveal: got state %s", state)
+	}
+}
+
+func TestGRPCGetStatus(t *testing.T) {
+	gts := newGRPCTestServer(t)
+	ctx := context.Background()
+
+	active := gts.create(t, &gosnappassv1.CreateSecretRequest{})
+	revealed := gts.create(t, &gosnappassv1.CreateSecretRequest{})
+	if _, err := gts.client.RevealSecret(ctx, &gosnappassv1.RevealSecretRequest{Id: revealed.Secret.Id, Key: revealed.Key}); err != nil {
+		t.Fatal(err)
+	}
+	revoked := gts.create(t, &gosnappassv1.CreateSecretRequest{})
+	if _, err := gts.client.RevokeSecret(ctx, &gosnappassv1.RevokeSecretRequest{Id: revoked.Secret.Id, Key: revoked.Key}); err != nil {
+		t.Fatal(err)
+	}
+	expired := gts.create(t, &gosnappassv1.CreateSecretRequest{Ttl: durationpb.New(time.Minute)})
+	testRedis.FastForward(2 * time.Minute)
+
+	tests := []struct {
+		name string
+		id   string
+		want gosnappassv1.State
+	}{
+		{"active", active.Secret.Id, gosnappassv1.State_STATE_ACTIVE},
+		{"revealed", revealed.Secret.Id, gosnappassv1.State_STATE_REVEALED},
+		{"revoked", revoked.Secret.Id, gosnappassv1.State_STATE_REVOKED},
+		{"expired", expired.Secret.Id, gosnappassv1.State_STATE_EXPIRED},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if state := gts.state(t, tt.id); state != tt.want {
+				t.Errorf("got state %s, want %s", state, tt.want)
+			}
+		})
+	}
+
+	_, err := gts.client.GetStatus(ctx, &gosnappassv1.GetStatusRequest{Id: DefaultRedisKeyPrefix + "00000000-0000-0000-0000-000000000000"})
+	expectCode(t, err, codes.NotFound)
+	_, err = gts.client.GetStatus(ctx, &gosnappassv1.GetStatusRequest{Id: "not-an-id"})
+	expectCode(t, err, codes.NotFound)
+}
+
+func TestGRPCRevokeSecret(t *testing.T) {
+	logs := &syncBuffer{}
+	gts := newGRPCTestServer(t, LogTo(logs))
+	ctx := context.Background()
+	created, other := gts.create(t, &gosnappassv1.CreateSecretRequest{}), gts.create(t, &gosnappassv1.CreateSecretRequest{})
+
+	_, err := gts.client.RevokeSecret(ctx, &gosnappassv1.RevokeSecretRequest{Id: created.Secret.Id, Key: other.Key})
+	expectCode(t, err, codes.PermissionDenied)
+	if state := gts.state(t, created.Secret.Id); state != gosnappassv1.State_STATE_ACTIVE {
+		t.Errorf("after revoking with a wrong key: got state %s", state)
+	}
+
+	if _, err := gts.client.RevokeSecret(ctx, &gosnappassv1.RevokeSecretRequest{Id: created.Secret.Id, Key: created.Key}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(logs.String(), `"event":"secret.deleted","actor":"cert:CN=deployer"`) {
+		t.Errorf("the revocation wasn't audited: %s", logs)
+	}
+
+	_, err = gts.client.RevealSecret(ctx, &gosnappassv1.RevealSecretRequest{Id: created.Secret.Id, Key: created.Key})
+	expectCode(t, err, codes.NotFound)
+	_, err = gts.client.RevokeSecret(ctx, &gosnappassv1.RevokeSecretRequest{Id: created.Secret.Id, Key: created.Key})
+	expectCode(t, err, codes.NotFound)
+}
+
+func TestGRPCRequiresClientCertificate(t *testing.T) {
+	gts := newGRPCTestServer(t)
+
+	withoutCert := gts.clientTLS.Clone()
+	withoutCert.Certificates = nil
+	client := gosnappassv1.NewSecretServiceClient(gts.dial(t, withoutCert))
+
+	_, err := client.CreateSecret(context.Background(), &gosnappassv1.CreateSecretRequest{Content: &gosnappassv1.CreateSecretRequest_Text{Text: "s3cr3t"}})
+	expectCode(t, err, codes.Unavailable)
+}
@@ -6,10 +6,9 @@ import (
 	"net/url"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/concerthall/gosnappass/internal/view"
-	"github.com/go-redis/redis/v8"
-	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	"golang.org/x/exp/slog"
 )
@@ -20,23 +19,42 @@ var timeConversion = map[string]int{
 	"two weeks": 1209600, "week": 604800, "day": 86400, "hour": 3600,
 }
 
-// tokenSeparator separates the token ID and the key used to decrypt it in the URL.
-const tokenSeparator = "~"
+// structuredFieldNames are the fields offered by the structured mode of the form, in
+// display order. The form submits each as "field_" followed by the lowercased name.
+var structuredFieldNames = []string{"Username", "Password", "URL", "Notes"}
 
-// indexHandler handles requests to /. A user will see a form requesting the credential
-// they wish to have stored, and for what duration. GET
-func indexHandler(w http.ResponseWriter, r *http.Request) {
-	logger := slog.FromContext(r.Context())
-	if err := view.Index(w); err != nil {
-		logger.Error("unable to render index view", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+// notBeforeLocalLayout is the layout submitted by datetime-local inputs. Values in
+// this layout carry no timezone and are interpreted as UTC.
+const notBeforeLocalLayout = "2006-01-02T15:04"
+
+// newIndexHandler produces an indexHandler rendering the form according to cfg.
+func newIndexHandler(cfg routerConfig) http.HandlerFunc {
+	opts := view.IndexOptions{
+		LabelMaxLength: cfg.labelMaxLength,
+		Restrictions:   cfg.oidc != nil,
+	}
+
+	// indexHandler handles requests to /. A user will see a form requesting the credential
+	// they wish to have stored, and for what duration. GET
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := slog.FromContext(r.Context())
+		opts := opts
+		opts.CSRFToken = csrfToken(r.Context())
+		if id := cfg.identity(r); id != nil {
+			opts.SignedInAs = id.name()
+		}
+		opts.LogoutCSRFToken = cfg.logoutCSRFToken(r)
+		if err := view.Index(w, opts); err != nil {
+			logger.Error("unable to render index view", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
 	}
 }
 
-// newSetPasswordHandler produces a setPasswordHandler with proto and hostOverrides to be
-// used for the returned link, and keyPrefix used for redis keys.
-func newSetPasswordHandler(proto, hostOverride, keyPrefix, urlPrefix string) http.HandlerFunc {
+// newSetPasswordHandler produces a setPasswordHandler using cfg's proto and hostOverride
+// for the returned link, and cfg's redisKeyPrefix for redis keys.
+func newSetPasswordHandler(cfg routerConfig) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		logger := slog.FromContext(r.Context())
 		err := r.ParseForm()
@@ -46,7 +64,6 @@ func newSetPasswordHandler(proto, hostOverride, keyPrefix, urlPrefix string) htt
 			return
 		}
 
-		secret := r.FormValue("password")
 		ttl := r.FormValue("ttl")
 
 		var ittl int
@@ -56,134 +73,317 @@ func newSetPasswordHandler(proto, hostOverride, keyPrefix, urlPrefix string) htt
 			return
 		}
 
-		token, key, err := Encrypt(secret)
+		// The label is shown to anyone holding the link, so it is never logged.
+		label := strings.TrimSpace(r.FormValue("label"))
+		if utf8.RuneCountInString(label) > cfg.labelMaxLength {
+			logger.Info("rejected secret with an oversized label", "maxLength", cfg.labelMaxLength)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		notBefore, err := parseNotBefore(r.FormValue("not_before"), r.FormValue("not_before_local"))
 		if err != nil {
-			logger.Error("error encrypting secret", err)
+			logger.Error("unable to parse activation time", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		// Restricted secrets can only be revealed after logging in, which requires an
+		// identity provider.
+		restriction := parseRevealRestriction(r.FormValue("restrict_to"))
+		if restriction != nil && cfg.oidc == nil {
+			logger.Info("rejected a restricted secret without a configured identity provider")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		plaintext, kind := r.FormValue("password"), ""
+		if r.FormValue("mode") == secretKindFields {
+			fields := structuredFieldsFromForm(r)
+			if len(fields) == 0 {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			kind = secretKindFields
+			if plaintext, err = encodeFields(fields); err != nil {
+				logger.Error("error encoding structured secret", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+		}
+
+		secretLink, err := createSecret(r.Context(), cfg, newSecret{
+			plaintext:   plaintext,
+			kind:        kind,
+			label:       label,
+			notBefore:   notBefore,
+			restriction: restriction,
+			ttl:         time.Duration(ittl) * time.Second,
+			creator:     cfg.actor(r),
+		})
+		if err != nil {
+			logger.Error("unable to store secret", err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 
-		id := keyPrefix + uuid.New().String()
+		link := cfg.secretURL(r, secretLink.token)
 
-		db := RedisClient()
-		set := db.Set(r.Context(), id, token, time.Duration(ittl)*time.Second)
-		if set.Err() != nil {
-			logger.Error("unable to set key with ttl", err)
+		if err := view.Confirm(w, link, !cfg.disableQRCode); err != nil {
+			logger.Error("unable to render view Confirm", err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
+	}
+}
 
-		// use the host override if set
-		host := r.Host
-		if hostOverride != "" {
-			host = hostOverride
+// newShowConfirmationHandler produces a showConfirmationHandler resolving tokens with
+// cfg's redisKeyPrefix.
+func newShowConfirmationHandler(cfg routerConfig) http.HandlerFunc {
+	// showConfirmationHandler is the UI shown to the user when accessing the access string
+	// on this server with an http GET request.
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := slog.FromContext(r.Context())
+		// get the variables from the request's PATH, using gorilla mux's variable system
+		vars := mux.Vars(r)
+
+		// split the token into the id and its key
+		id, _, err := parseToken(vars["token"], cfg.redisKeyPrefix)
+		if err != nil {
+			logger.Error("unable to split token in URL", err)
+			cfg.recordProbe(r, probeMalformed)
+			view.CredentialExpiredOrNotFound(w)
+			return
 		}
-		// normalize the proto TODO: should we do this elsewhere?
-		if proto == "" {
-			proto = "http"
+
+		stored, err := lookupSecret(r.Context(), id)
+		if err != nil {
+			if err == errSecretNotFound {
+				cfg.recordNotFound(r, id)
+				view.CredentialExpiredOrNotFound(w)
+				return
+			}
+
+			logger.Error("unable to query key from datadbase", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
 		}
 
-		link, _ := url.JoinPath(
-			fmt.Sprintf("%s://%s/", proto, host),
-			urlPrefix,
-			strings.Join([]string{id, url.PathEscape(key)}, tokenSeparator))
+		if !cfg.authorizeReveal(w, r, stored) {
+			return
+		}
 
-		if err := view.Confirm(w, link); err != nil {
+		if err := view.PreviewPassword(w, stored.Label, activationTime(stored), csrfToken(r.Context())); err != nil {
+			logger.Error("unable to render view PreviewPassword", err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 	}
 }
 
-// showConfirmationHandler is the UI shown to the user when accessing the access string
-// on this server with an http GET request.
-func showConfirmationHandler(w http.ResponseWriter, r *http.Request) {
-	logger := slog.FromContext(r.Context())
-	// get the variables from the request's PATH, using gorilla mux's variable system
-	vars := mux.Vars(r)
+// newGetPasswordHandler produces a getPasswordHandler resolving tokens with cfg's
+// redisKeyPrefix.
+func newGetPasswordHandler(cfg routerConfig) http.HandlerFunc {
+	// getPasswordHandler is the UI shown to the user containing their password. POST.
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := slog.FromContext(r.Context())
+		// get the variables from the request's PATH, using gorilla mux's variable system
+		vars := mux.Vars(r)
 
-	// split the token into the id and its key
-	id, _, err := splitToken(vars["token"])
-	if err != nil {
-		logger.Error("unable to split token in URL", err)
-		view.CredentialExpiredOrNotFound(w)
-		return
+		// split the token into the id and its key
+		id, key, err := parseToken(vars["token"], cfg.redisKeyPrefix)
+		if err != nil {
+			logger.Error("unable to split token in URL", err)
+			cfg.recordProbe(r, probeMalformed)
+			view.CredentialExpiredOrNotFound(w)
+			return
+		}
+
+		stored, err := lookupSecret(r.Context(), id)
+		if err != nil {
+			// We'll throw a 404 for this because it's possible the key timed out while
+			// another view for this key was loaded.
+			if err == errSecretNotFound {
+				cfg.recordNotFound(r, id)
+				view.CredentialExpiredOrNotFound(w)
+				return
+			}
+
+			// Otherwise, there's some error with the database itself.
+			logger.Error("error reading from the database: ", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		// Check the restriction, activation time and key before removing the secret, so
+		// that an unauthorized or early reveal, or a wrong key, doesn't burn it.
+		if !cfg.authorizeReveal(w, r, stored) {
+			return
+		}
+
+		if !stored.activeAt(time.Now()) {
+			w.WriteHeader(http.StatusForbidden)
+			if err := view.PreviewPassword(w, stored.Label, stored.activatesAt(), csrfToken(r.Context())); err != nil {
+				logger.Error("unable to render view PreviewPassword", err)
+			}
+			return
+		}
+
+		var decrypted string
+		var fields []SecretField
+		if stored.Kind == secretKindFields {
+			fields, err = DecryptFields(stored.Token, key)
+		} else {
+			decrypted, err = Decrypt(stored.Token, key)
+		}
+		if err != nil {
+			// A wrong key is the client's error, refused as by the v2 API.
+			logger.Info("rejected reveal with a wrong key")
+			cfg.recordProbe(r, probeInvalidKey)
+			w.WriteHeader(http.StatusForbidden)
+			view.CredentialExpiredOrNotFound(w)
+			return
+		}
+
+		if err := takeSecret(r.Context(), cfg.redisKeyPrefix, id, stored.Token); err != nil {
+			// another request revealed the secret after we looked it up.
+			if err == errSecretNotFound {
+				view.CredentialExpiredOrNotFound(w)
+				return
+			}
+
+			logger.Error("error deleting from the database: ", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		auditEvent(r.Context(), auditSecretRevealed, cfg.actor(r), "secret", id)
+
+		if stored.Kind == secretKindFields {
+			viewFields := make([]view.Field, 0, len(fields))
+			for _, f := range fields {
+				viewFields = append(viewFields, view.Field{Name: f.Name, Value: f.Value})
+			}
+
+			if err := view.ShowFields(w, viewFields); err != nil {
+				logger.Error("error rendering ShowFields view", err)
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+			return
+		}
+
+		if err := view.ShowPassword(w, decrypted); err != nil {
+			logger.Error("error rendering ShowPassword view", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
 	}
+}
 
-	db := RedisClient()
-	c := db.Exists(r.Context(), id)
-	if c.Err() != nil {
-		logger.Error("unable to query key from datadbase", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+// secretURL returns the link to the secret identified by token, using cfg's
+// hostOverride, or else the host of r. The scheme and host of r are those reported
+// by a trusted proxy, if any.
+func (cfg routerConfig) secretURL(r *http.Request, token string) string {
+	// use the host override if set
+	host := requestHost(r)
+	if cfg.hostOverride != "" {
+		host = cfg.hostOverride
 	}
 
-	// Zero when it doesn't exist in the db
-	if c.Val() == 0 {
-		view.CredentialExpiredOrNotFound(w)
-		return
+	return cfg.secretURLOnHost(cfg.requestScheme(r), host, token)
+}
+
+// secretURLOnHost returns the link to the secret identified by token on host, using
+// scheme and cfg's pathPrefix.
+func (cfg routerConfig) secretURLOnHost(scheme, host, token string) string {
+	link, _ := url.JoinPath(
+		fmt.Sprintf("%s://%s/", scheme, host),
+		cfg.pathPrefix,
+		token)
+
+	return link
+}
+
+// authorizeReveal checks that the user of r may reveal stored. Users who aren't
+// logged in are sent to log in first. If the user may not reveal stored, a response
+// is written and false is returned.
+func (cfg routerConfig) authorizeReveal(w http.ResponseWriter, r *http.Request, stored storedSecret) bool {
+	if stored.Restriction == nil {
+		return true
 	}
 
-	if err := view.PreviewPassword(w); err != nil {
-		logger.Error("unable to render view PreviewPassword", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+	id := cfg.identity(r)
+	if id == nil && cfg.oidc != nil {
+		loginRedirect(w, r)
+		return false
 	}
-}
 
-// getPasswordHandler is the UI shown to the user containing their password. POST.
-func getPasswordHandler(w http.ResponseWriter, r *http.Request) {
-	logger := slog.FromContext(r.Context())
-	// get the variables from the request's PATH, using gorilla mux's variable system
-	vars := mux.Vars(r)
+	if stored.Restriction.allows(id) {
+		return true
+	}
 
-	// split the token into the id and its key
-	id, key, err := splitToken(vars["token"])
-	if err != nil {
-		logger.Error("unable to split token in URL", err)
-		view.CredentialExpiredOrNotFound(w)
-		return
+	logger := slog.FromContext(r.Context())
+	name := ""
+	if id != nil {
+		name = id.name()
 	}
+	logger.Info("denied reveal of a restricted secret", "user", name)
 
-	key, _ = url.PathUnescape(key)
+	w.WriteHeader(http.StatusForbidden)
+	if err := view.NotAuthorized(w, name); err != nil {
+		logger.Error("unable to render view NotAuthorized", err)
+	}
+	return false
+}
 
-	db := RedisClient()
-	c := db.GetDel(r.Context(), id)
-	err = c.Err()
-	if err != nil {
-		// redis.Nil implies the key didn't exist at access time. We'll throw a 404 for this
-		// because it's possible the key timed out while another view for this key was loaded.
-		if err == redis.Nil {
-			view.CredentialExpiredOrNotFound(w)
-			return
+// structuredFieldsFromForm returns the non-empty structured fields submitted with
+// r, in display order.
+func structuredFieldsFromForm(r *http.Request) []SecretField {
+	fields := []SecretField{}
+	for _, name := range structuredFieldNames {
+		value := r.FormValue("field_" + strings.ToLower(name))
+		if value == "" {
+			continue
 		}
+		fields = append(fields, SecretField{Name: name, Value: value})
+	}
 
-		// Otherwise, there's some error with the database itself.
-		logger.Error("error getdel-ing from the database: ", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+	return fields
+}
+
+// parseNotBefore returns the activation time submitted with a new secret. An RFC 3339
+// value takes precedence over a datetime-local value, which is interpreted as UTC.
+// Times that are unset or already past produce the zero time, meaning the secret is
+// available immediately.
+func parseNotBefore(rfc3339, local string) (time.Time, error) {
+	var t time.Time
+	var err error
+	switch {
+	case rfc3339 != "":
+		t, err = time.Parse(time.RFC3339, rfc3339)
+	case local != "":
+		t, err = time.Parse(notBeforeLocalLayout, local)
+	default:
+		return time.Time{}, nil
 	}
 
-	token := c.Val()
-	decrypted, err := Decrypt(token, key)
 	if err != nil {
-		logger.Error("error decrypting the secret", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+		return time.Time{}, err
 	}
 
-	if err := view.ShowPassword(w, decrypted); err != nil {
-		logger.Error("error rendering ShowPassword view", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+	if !t.After(time.Now()) {
+		return time.Time{}, nil
 	}
+
+	return t, nil
 }
 
-func splitToken(t string) (string, string, error) {
-	spl := strings.Split(t, tokenSeparator)
-	if len(spl) != 2 {
-		return "", "", fmt.Errorf("unable to split token: '%s' using separator '%s'", t, tokenSeparator)
+// activationTime returns the time at which stored may be revealed, or the zero time
+// if it may be revealed now.
+func activationTime(stored storedSecret) time.Time {
+	if stored.activeAt(time.Now()) {
+		return time.Time{}
 	}
 
-	return spl[0], spl[1], nil
+	return stored.activatesAt()
 }
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+var passwordLinkPattern = regexp.MustCompile(`id="password-link" value="([^"]+)"`)
+
+// submitSecret submits the form of the index page with form, and returns the
+// response and the link on the confirmation page, if any.
+func (ts *testServer) submitSecret(t *testing.T, form url.Values) (*http.Response, string, string) {
+	t.Helper()
+	if form.Get("ttl") == "" {
+		form.Set("ttl", "hour")
+	}
+
+	resp, body := ts.submitForm(t, "/", form)
+	link := ""
+	if m := passwordLinkPattern.FindStringSubmatch(body); m != nil {
+		link = m[1]
+	}
+	return resp, body, link
+}
+
+func TestNotYetActiveSecret(t *testing.T) {
+	ts := newTestServer(t)
+	notBefore := time.Now().Add(time.Hour)
+
+	resp, _, link := ts.submitSecret(t, url.Values{"password": {"s3cr3t"}, "not_before": {notBefore.Format(time.RFC3339)}})
+	if resp.StatusCode != http.StatusOK || link == "" {
+		t.Fatalf("creating a secret: got status %d", resp.StatusCode)
+	}
+	token := tokenOf(link)
+
+	resp, body := ts.get(t, "/"+token)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, "can't be revealed until") || strings.Contains(body, "revealSecret") {
+		t.Errorf("previewing: got status %d, body %s", resp.StatusCode, body)
+	}
+
+	form := url.Values{csrfFieldName: {ts.csrfToken(t, "/")}}
+	resp, body = ts.do(t, http.MethodPost, "/"+token, strings.NewReader(form.Encode()), http.Header{"Content-Type": {"application/x-www-form-urlencoded"}})
+	if resp.StatusCode != http.StatusForbidden || !strings.Contains(body, "can't be revealed until") || strings.Contains(body, "s3cr3t") {
+		t.Errorf("revealing early: got status %d, body %s", resp.StatusCode, body)
+	}
+
+	id, _, err := parseToken(token, DefaultRedisKeyPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lookupSecret(context.Background(), id); err != nil {
+		t.Errorf("revealing early burned the secret: %v", err)
+	}
+	// The hour the secret may be revealed for starts once it is active.
+	if ttl := testRedis.TTL(id); ttl <= time.Hour {
+		t.Errorf("got TTL %s, want it counted from the activation", ttl)
+	}
+}
+
+func TestSecretLabel(t *testing.T) {
+	logs := &syncBuffer{}
+	ts := newTestServer(t, WithLabelMaxLength(12), LogTo(logs))
+
+	resp, _, link := ts.submitSecret(t, url.Values{"password": {"s3cr3t"}, "label": {"staging DB admin"}})
+	if resp.StatusCode != http.StatusBadRequest || link != "" {
+		t.Errorf("with an over-length label: got status %d", resp.StatusCode)
+	}
+
+	// The length is counted in characters, not bytes.
+	resp, _, link = ts.submitSecret(t, url.Values{"password": {"s3cr3t"}, "label": {" <b>\"é</b>&x "}})
+	if resp.StatusCode != http.StatusOK || link == "" {
+		t.Fatalf("with a hostile label: got status %d", resp.StatusCode)
+	}
+
+	_, body := ts.get(t, "/"+tokenOf(link))
+	if !strings.Contains(body, "Label: <strong>&lt;b&gt;&#34;é&lt;/b&gt;&amp;x</strong>") || strings.Contains(body, "<b>") {
+		t.Errorf("the label wasn't escaped on the preview: %s", body)
+	}
+
+	for _, label := range []string{"staging DB admin", "é</b>"} {
+		if strings.Contains(logs.String(), label) {
+			t.Errorf("the label %q was logged: %s", label, logs)
+		}
+	}
+}
+
+func TestConfirmationQRCode(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []ServerOption
+		want bool
+	}{
+		{"enabled", nil, true},
+		{"disabled", []ServerOption{WithoutQRCode()}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, tt.opts...)
+			resp, body, link := ts.submitSecret(t, url.Values{"password": {"s3cr3t"}})
+			if resp.StatusCode != http.StatusOK || link == "" {
+				t.Fatalf("creating a secret: got status %d", resp.StatusCode)
+			}
+
+			if got := strings.Contains(body, "<svg"); got != tt.want {
+				t.Errorf("got a QR code %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strings"
+
+	"github.com/concerthall/gosnappass/internal/view"
+	"github.com/gorilla/mux"
+	"golang.org/x/exp/slog"
+)
+
+// cspNoncePlaceholder is replaced in the Content-Security-Policy by the nonce of each
+// response, which the scripts of its page carry.
+const cspNoncePlaceholder = "{nonce}"
+
+// SecurityHeaders are the headers set on every response. Empty headers are not set.
+type SecurityHeaders struct {
+	// ContentSecurityPolicy may contain cspNoncePlaceholder, e.g. in script-src.
+	ContentSecurityPolicy string
+	// ReferrerPolicy keeps browsers from leaking links, which carry secret keys, to
+	// the sites they link to.
+	ReferrerPolicy string
+	FrameOptions   string
+	// StrictTransportSecurity is only set on responses served over https.
+	StrictTransportSecurity string
+	RobotsTag               string
+	// CacheControl is set on every page, but not on static assets, so that secrets
+	// and links aren't kept in the browser's cache.
+	CacheControl string
+}
+
+// DefaultSecurityHeaders returns the headers a server sets unless WithSecurityHeaders
+// sets others.
+func DefaultSecurityHeaders() SecurityHeaders {
+	return SecurityHeaders{
+		ContentSecurityPolicy: "default-src 'self'; script-src 'nonce-" + cspNoncePlaceholder + "'; " +
+			"img-src 'self' data:; object-src 'none'; base-uri 'self'; form-action 'self'; frame-ancestors 'none'",
+		ReferrerPolicy:          "no-referrer",
+		FrameOptions:            "DENY",
+		StrictTransportSecurity: "max-age=31536000",
+		RobotsTag:               "noindex, nofollow",
+		CacheControl:            "no-store",
+	}
+}
+
+// nonceWriter gives the pages written to it the nonce allowed by the
+// Content-Security-Policy of their response.
+type nonceWriter struct {
+	http.ResponseWriter
+	nonce string
+}
+
+var _ view.ScriptNoncer = nonceWriter{}
+
+func (w nonceWriter) ScriptNonce() string {
+	return w.nonce
+}
+
+// newSecurityHeadersMW generates a middleware setting headers on every response.
+// Responses are considered served over https if scheme returns https for them.
+func newSecurityHeadersMW(headers SecurityHeaders, scheme func(*http.Request) string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h := w.Header()
+			if headers.ContentSecurityPolicy != "" {
+				policy := headers.ContentSecurityPolicy
+				if strings.Contains(policy, cspNoncePlaceholder) {
+					b, err := randomBytes(16)
+					if err != nil {
+						slog.FromContext(r.Context()).Error("unable to generate CSP nonce", err)
+						w.WriteHeader(http.StatusInternalServerError)
+						return
+					}
+
+					nonce := base64.RawURLEncoding.EncodeToString(b)
+					policy = strings.ReplaceAll(policy, cspNoncePlaceholder, nonce)
+					w = nonceWriter{ResponseWriter: w, nonce: nonce}
+				}
+				h.Set("Content-Security-Policy", policy)
+			}
+
+			setHeader(h, "Referrer-Policy", headers.ReferrerPolicy)
+			setHeader(h, "X-Frame-Options", headers.FrameOptions)
+			setHeader(h, "X-Robots-Tag", headers.RobotsTag)
+			h.Set("X-Content-Type-Options", "nosniff")
+
+			if scheme(r) == "https" {
+				setHeader(h, "Strict-Transport-Security", headers.StrictTransportSecurity)
+			}
+
+			if !strings.HasPrefix(r.URL.Path, "/static/") && r.URL.Path != "/favicon.ico" {
+				setHeader(h, "Cache-Control", headers.CacheControl)
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// setHeader sets the header key to value, unless value is empty.
+func setHeader(h http.Header, key, value string) {
+	if value != "" {
+		h.Set(key, value)
+	}
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"net/http"
+	"regexp"
+	"testing"
+)
+
+var (
+	cspNoncePattern    = regexp.MustCompile(`script-src 'nonce-([^']+)'`)
+	scriptNoncePattern = regexp.MustCompile(`<script nonce="([^"]*)"`)
+)
+
+func TestCSPNonce(t *testing.T) {
+	ts := newTestServer(t)
+	created := ts.createSecret(t, "s3cr3t")
+
+	nonces := map[string]bool{}
+	for _, path := range []string{"/", "/", "/" + tokenOf(created.Link)} {
+		resp, body := ts.get(t, path)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET %s: got status %d", path, resp.StatusCode)
+		}
+
+		match := cspNoncePattern.FindStringSubmatch(resp.Header.Get("Content-Security-Policy"))
+		if match == nil {
+			t.Fatalf("GET %s: no nonce in policy %q", path, resp.Header.Get("Content-Security-Policy"))
+		}
+		nonce := match[1]
+		if nonces[nonce] {
+			t.Errorf("GET %s: nonce %q was served before", path, nonce)
+		}
+		nonces[nonce] = true
+
+		scripts := scriptNoncePattern.FindAllStringSubmatch(body, -1)
+		if len(scripts) == 0 {
+			t.Errorf("GET %s: no scripts", path)
+		}
+		for _, script := range scripts {
+			if script[1] != nonce {
+				t.Errorf("GET %s: got script nonce %q, want %q", path, script[1], nonce)
+			}
+		}
+	}
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	custom := SecurityHeaders{StrictTransportSecurity: "max-age=60; includeSubDomains", CacheControl: "private, no-cache"}
+
+	tests := []struct {
+		name             string
+		opts             []ServerOption
+		path             string
+		wantHSTS         string
+		wantCacheControl string
+	}{
+		{"over http", nil, "/", "", "no-store"},
+		{"over https", []ServerOption{WithProto("https")}, "/", "max-age=31536000", "no-store"},
+		{"static asset", []ServerOption{WithProto("https")}, "/static/snappass/css/custom.css", "max-age=31536000", ""},
+		{"configured", []ServerOption{WithProto("https"), WithSecurityHeaders(custom)}, "/", "max-age=60; includeSubDomains", "private, no-cache"},
+		{"configured over http", []ServerOption{WithSecurityHeaders(custom)}, "/", "", "private, no-cache"},
+		{"unset", []ServerOption{WithProto("https"), WithSecurityHeaders(SecurityHeaders{})}, "/", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, tt.opts...)
+			resp, _ := ts.get(t, tt.path)
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("got status %d", resp.StatusCode)
+			}
+
+			if hsts := resp.Header.Get("Strict-Transport-Security"); hsts != tt.wantHSTS {
+				t.Errorf("got Strict-Transport-Security %q, want %q", hsts, tt.wantHSTS)
+			}
+			if cacheControl := resp.Header.Get("Cache-Control"); cacheControl != tt.wantCacheControl {
+				t.Errorf("got Cache-Control %q, want %q", cacheControl, tt.wantCacheControl)
+			}
+			if resp.Header.Get("X-Content-Type-Options") != "nosniff" {
+				t.Errorf("got X-Content-Type-Options %q", resp.Header.Get("X-Content-Type-Options"))
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/exp/slog"
+)
+
+// ParsePublicURL parses the URL the server is reached at, e.g.
+// "https://snappass.example.com/sharepassword/". It must be an http or https URL
+// with a host, and no credentials, query or fragment.
+func ParsePublicURL(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public URL %q: %w", s, err)
+	}
+
+	switch {
+	case u.Scheme != "http" && u.Scheme != "https":
+		return nil, fmt.Errorf("the public URL %q must use http or https", s)
+	case u.Opaque != "" || !validHost(u.Host):
+		return nil, fmt.Errorf("the public URL %q must have a valid host", s)
+	case u.User != nil || u.RawQuery != "" || u.Fragment != "" || u.ForceQuery:
+		return nil, fmt.Errorf("the public URL %q must not have credentials, a query or a fragment", s)
+	}
+
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	return u, nil
+}
+
+// hostAllowed returns true if host, which may carry a port, is in allowed. Entries
+// without a port allow every port.
+func hostAllowed(allowed []string, host string) bool {
+	name := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		name = h
+	}
+	name = strings.TrimSuffix(strings.TrimPrefix(name, "["), "]")
+
+	for _, a := range allowed {
+		if strings.EqualFold(a, host) || strings.EqualFold(strings.TrimSuffix(strings.TrimPrefix(a, "["), "]"), name) {
+			return true
+		}
+	}
+	return false
+}
+
+// newAllowedHostsMW produces a middleware rejecting requests for hosts other than
+// allowed, so that links are never built from a host chosen by the client. The host
+// of a request is the one reported by a trusted proxy, if any.
+func newAllowedHostsMW(allowed []string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !hostAllowed(allowed, requestHost(r)) {
+				slog.FromContext(r.Context()).Info("rejected request for a host that isn't allowed", "host", requestHost(r))
+				http.Error(w, http.StatusText(http.StatusMisdirectedRequest), http.StatusMisdirectedRequest)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
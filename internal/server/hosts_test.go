@@ -0,0 +1,71 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestRunRequiresPublicHost(t *testing.T) {
+	err := New(LogTo(io.Discard)).Run()
+	if err == nil || !strings.Contains(err.Error(), "Host of requests") {
+		t.Errorf("got error %v", err)
+	}
+}
+
+func TestAllowedHosts(t *testing.T) {
+	ts := newTestServer(t, WithAllowedHosts([]string{"127.0.0.1"}))
+
+	created := ts.createSecret(t, "s3cr3t")
+	if u, err := url.Parse(created.Link); err != nil || u.Hostname() != "127.0.0.1" {
+		t.Errorf("got link %q", created.Link)
+	}
+
+	if resp := ts.createOnHost(t, "attacker.example", nil); resp.StatusCode != http.StatusMisdirectedRequest {
+		t.Errorf("for another host: got status %d", resp.StatusCode)
+	}
+}
+
+func TestPublicURL(t *testing.T) {
+	u, err := ParsePublicURL("https://snappass.example.com/sharepassword/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := newTestServer(t, WithPublicURL(u))
+
+	created := apiV2Secret{}
+	if resp := ts.createOnHost(t, "attacker.example", &created); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("got status %d", resp.StatusCode)
+	}
+	if !strings.HasPrefix(created.Link, "https://snappass.example.com/sharepassword/") {
+		t.Errorf("got link %q", created.Link)
+	}
+}
+
+// createOnHost creates a secret with the v2 API in a request for host, and decodes
+// the response into out unless it is nil.
+func (ts *testServer) createOnHost(t *testing.T, host string, out any) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/v2/secrets", strings.NewReader(`{"secret": "s3cr3t"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Host = host
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := ts.client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp
+}
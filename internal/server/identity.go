@@ -0,0 +1,84 @@
+package server
+
+import (
+	"strings"
+)
+
+// groupRestrictionPrefix marks an entry of a reveal restriction as a group rather
+// than a user, e.g. "group:sre".
+const groupRestrictionPrefix = "group:"
+
+// identity is an authenticated user, as asserted by the identity provider.
+type identity struct {
+	// Subject is the provider's unique identifier for the user.
+	Subject string `json:"sub"`
+	// Email is the user's email address, if the provider shared it.
+	Email string `json:"email,omitempty"`
+	// EmailVerified is true if the provider asserted that the user owns Email.
+	// Unverified addresses never match restrictions or policies.
+	EmailVerified bool `json:"email_verified,omitempty"`
+	// Groups are the groups the user belongs to, if the provider shared them.
+	Groups []string `json:"groups,omitempty"`
+}
+
+// name returns a human readable name for the identity.
+func (id *identity) name() string {
+	if id.Email != "" {
+		return id.Email
+	}
+	return id.Subject
+}
+
+// revealRestriction limits who may reveal a secret. A user is allowed if they match
+// any of Users or belong to any of Groups.
+type revealRestriction struct {
+	// Users are email addresses or subjects of users allowed to reveal the secret.
+	Users []string `json:"users,omitempty"`
+	// Groups are groups whose members are allowed to reveal the secret.
+	Groups []string `json:"groups,omitempty"`
+}
+
+// parseRevealRestriction parses a comma or whitespace separated list of users and
+// groups, e.g. "alice@corp, group:sre". It returns nil if the list is empty.
+func parseRevealRestriction(s string) *revealRestriction {
+	r := revealRestriction{}
+	for _, entry := range strings.FieldsFunc(s, func(c rune) bool { return c == ',' || c == ' ' || c == '\n' || c == '\t' || c == '\r' }) {
+		if group := strings.TrimPrefix(entry, groupRestrictionPrefix); group != entry {
+			if group != "" {
+				r.Groups = append(r.Groups, group)
+			}
+			continue
+		}
+		r.Users = append(r.Users, entry)
+	}
+
+	if len(r.Users) == 0 && len(r.Groups) == 0 {
+		return nil
+	}
+
+	return &r
+}
+
+// allows returns true if id may reveal a secret restricted by r. A nil identity is
+// never allowed, and users are only matched by a verified email address.
+func (r *revealRestriction) allows(id *identity) bool {
+	if id == nil {
+		return false
+	}
+
+	for _, u := range r.Users {
+		if u == id.Subject || (id.Email != "" && id.EmailVerified && strings.EqualFold(u, id.Email)) {
+			return true
+		}
+	}
+
+	for _, g := range r.Groups {
+		for _, member := range id.Groups {
+			if g == member {
+				return true
+			}
+		}
+	}
+
+	return false
+}
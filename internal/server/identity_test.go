@@ -0,0 +1,52 @@
+package server
+
+import "testing"
+
+func TestRevealRestrictionAllows(t *testing.T) {
+	r := parseRevealRestriction("alice@corp.example, bob, group:sre")
+
+	tests := []struct {
+		name string
+		id   *identity
+		want bool
+	}{
+		{"nobody", nil, false},
+		{"verified email", &identity{Subject: "1", Email: "Alice@corp.example", EmailVerified: true}, true},
+		{"unverified email", &identity{Subject: "1", Email: "alice@corp.example"}, false},
+		{"subject", &identity{Subject: "bob"}, true},
+		{"group", &identity{Subject: "2", Groups: []string{"sre"}}, true},
+		{"other user", &identity{Subject: "3", Email: "mallory@corp.example", EmailVerified: true, Groups: []string{"dev"}}, false},
+	}
+
+	for _, test := range tests {
+		if got := r.allows(test.id); got != test.want {
+			t.Errorf("%s: allows() = %t, want %t", test.name, got, test.want)
+		}
+	}
+}
+
+func TestCreatorPolicyAllows(t *testing.T) {
+	p := CreatorPolicy{Groups: []string{"sre"}, Domains: []string{"corp.example"}}
+
+	tests := []struct {
+		name string
+		id   *identity
+		want bool
+	}{
+		{"nobody", nil, false},
+		{"verified domain", &identity{Subject: "1", Email: "alice@CORP.example", EmailVerified: true}, true},
+		{"unverified domain", &identity{Subject: "1", Email: "alice@corp.example"}, false},
+		{"group", &identity{Subject: "2", Groups: []string{"sre"}}, true},
+		{"other domain", &identity{Subject: "3", Email: "alice@corp.example.evil", EmailVerified: true}, false},
+	}
+
+	for _, test := range tests {
+		if got := p.allows(test.id); got != test.want {
+			t.Errorf("%s: allows() = %t, want %t", test.name, got, test.want)
+		}
+	}
+
+	if !(&CreatorPolicy{}).allows(&identity{Subject: "anyone"}) {
+		t.Errorf("an empty policy should allow anyone logged in")
+	}
+}
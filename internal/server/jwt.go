@@ -0,0 +1,233 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/concerthall/gosnappass/internal/apitoken"
+	"github.com/coreos/go-oidc/v3/oidc"
+)
+
+// JWTIssuer trusts the JWTs of an issuer, such as a CI system, and grants scopes to
+// them according to their claims.
+type JWTIssuer struct {
+	// Issuer must match the iss claim of the issuer's tokens exactly.
+	Issuer string `json:"issuer"`
+	// Audience must be among the aud claim of tokens. It should be unique to this
+	// application, so that tokens meant for other services are not accepted.
+	Audience string `json:"audience"`
+	// JWKSURL is where the issuer publishes its signing keys. If empty, it is
+	// discovered from the issuer.
+	JWKSURL string    `json:"jwks_url,omitempty"`
+	Rules   []JWTRule `json:"rules"`
+}
+
+// JWTRule grants scopes to tokens whose claims match.
+type JWTRule struct {
+	// Name describes the rule in logs.
+	Name string `json:"name"`
+	// Claims maps claim names to the patterns their values must match, in the syntax
+	// of path.Match. A token matches the rule if it matches every claim. A claim
+	// listing several values matches if any of them does.
+	Claims map[string]string `json:"claims"`
+	Scopes []string          `json:"scopes"`
+}
+
+// LoadJWTIssuers reads the JSON file at file, of the form {"issuers": [...]}.
+func LoadJWTIssuers(file string) ([]JWTIssuer, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	f := struct {
+		Issuers []JWTIssuer `json:"issuers"`
+	}{}
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("unable to parse JWT issuers file %s: %w", file, err)
+	}
+
+	for _, iss := range f.Issuers {
+		if iss.Issuer == "" || iss.Audience == "" {
+			return nil, errors.New("JWT issuers must have an issuer and an audience")
+		}
+
+		for _, rule := range iss.Rules {
+			if len(rule.Claims) == 0 {
+				return nil, fmt.Errorf("rule %q of issuer %s must match at least one claim", rule.Name, iss.Issuer)
+			}
+
+			for _, pattern := range rule.Claims {
+				if _, err := path.Match(pattern, ""); err != nil {
+					return nil, fmt.Errorf("rule %q of issuer %s has an invalid pattern %q", rule.Name, iss.Issuer, pattern)
+				}
+			}
+
+			for _, scope := range rule.Scopes {
+				if !apitoken.ValidScope(scope) {
+					return nil, fmt.Errorf("rule %q of issuer %s grants an unknown scope %q", rule.Name, iss.Issuer, scope)
+				}
+			}
+		}
+	}
+
+	return f.Issuers, nil
+}
+
+// JWTAuthenticator authenticates API clients with JWTs from trusted issuers. The
+// signing keys of each issuer are cached, and fetched again when a token is signed
+// with an unknown key.
+type JWTAuthenticator struct {
+	issuers map[string]jwtIssuer
+}
+
+type jwtIssuer struct {
+	verifier *oidc.IDTokenVerifier
+	rules    []JWTRule
+}
+
+// NewJWTAuthenticator returns an authenticator trusting issuers. The signing keys of
+// issuers without a JWKSURL are discovered with ctx.
+func NewJWTAuthenticator(ctx context.Context, issuers []JWTIssuer) (*JWTAuthenticator, error) {
+	a := &JWTAuthenticator{issuers: map[string]jwtIssuer{}}
+	for _, iss := range issuers {
+		jwksURL := iss.JWKSURL
+		if jwksURL == "" {
+			discovered, err := discoverJWKSURL(ctx, iss.Issuer)
+			if err != nil {
+				return nil, err
+			}
+			jwksURL = discovered
+		}
+
+		// The key set outlives ctx, which only bounds discovery.
+		keySet := oidc.NewRemoteKeySet(context.Background(), jwksURL)
+		a.issuers[iss.Issuer] = jwtIssuer{
+			verifier: oidc.NewVerifier(iss.Issuer, keySet, &oidc.Config{ClientID: iss.Audience}),
+			rules:    iss.Rules,
+		}
+	}
+
+	return a, nil
+}
+
+// discoverJWKSURL returns the JWKS URL published in the discovery document of issuer.
+func discoverJWKSURL(ctx context.Context, issuer string) (string, error) {
+	provider, err := oidc.NewProvider(ctx, issuer)
+	if err != nil {
+		return "", fmt.Errorf("unable to discover JWT issuer %s: %w", issuer, err)
+	}
+
+	claims := struct {
+		JWKSURL string `json:"jwks_uri"`
+	}{}
+	if err := provider.Claims(&claims); err != nil {
+		return "", err
+	}
+
+	return claims.JWKSURL, nil
+}
+
+func (a *JWTAuthenticator) authenticate(r *http.Request) (*apiPrincipal, error) {
+	bearer := bearerToken(r)
+	if bearer == "" || strings.HasPrefix(bearer, apitoken.Prefix) || strings.Count(bearer, ".") != 2 {
+		return nil, nil
+	}
+
+	// The issuer is read before the signature is verified, only to choose the keys
+	// to verify it with.
+	iss, err := unverifiedIssuer(bearer)
+	if err != nil {
+		return nil, errInvalidCredentials
+	}
+
+	issuer, ok := a.issuers[iss]
+	if !ok {
+		return nil, errInvalidCredentials
+	}
+
+	token, err := issuer.verifier.Verify(r.Context(), bearer)
+	if err != nil {
+		return nil, errInvalidCredentials
+	}
+
+	claims := map[string]any{}
+	if err := token.Claims(&claims); err != nil {
+		return nil, errInvalidCredentials
+	}
+
+	p := &apiPrincipal{method: "jwt", name: token.Subject}
+	for _, rule := range issuer.rules {
+		if rule.matches(claims) {
+			p.scopes = append(p.scopes, rule.Scopes...)
+		}
+	}
+
+	return p, nil
+}
+
+// matches returns true if claims match every claim pattern of the rule.
+func (rule JWTRule) matches(claims map[string]any) bool {
+	for name, pattern := range rule.Claims {
+		if !claimMatches(claims[name], pattern) {
+			return false
+		}
+	}
+	return true
+}
+
+// claimMatches returns true if the claim value v, or any of its values if it is a
+// list, matches pattern. Numbers are matched in decimal notation, e.g. 1000000, and
+// booleans as true or false. Objects never match.
+func claimMatches(v any, pattern string) bool {
+	switch v := v.(type) {
+	case []any:
+		for _, item := range v {
+			if claimMatches(item, pattern) {
+				return true
+			}
+		}
+		return false
+	case string:
+		ok, _ := path.Match(pattern, v)
+		return ok
+	case float64:
+		ok, _ := path.Match(pattern, strconv.FormatFloat(v, 'f', -1, 64))
+		return ok
+	case bool:
+		ok, _ := path.Match(pattern, strconv.FormatBool(v))
+		return ok
+	default:
+		return false
+	}
+}
+
+// unverifiedIssuer returns the iss claim of the JWT token without verifying it.
+func unverifiedIssuer(token string) (string, error) {
+	parts := strings.Split(token, ".")
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", err
+	}
+
+	claims := struct {
+		Issuer string `json:"iss"`
+	}{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return "", err
+	}
+
+	if claims.Issuer == "" {
+		return "", errors.New("token has no issuer")
+	}
+
+	return claims.Issuer, nil
+}
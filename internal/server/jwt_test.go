@@ -0,0 +1,242 @@
+package server
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testIssuer stands in for the OIDC issuer of a CI system. It publishes a discovery
+// document and the JWKS of its signing keys, and signs JWTs with them. Tests add
+// the other endpoints of the discovery document to mux as needed.
+type testIssuer struct {
+	*httptest.Server
+	mux  *http.ServeMux
+	mu   sync.Mutex
+	keys map[string]*rsa.PrivateKey
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	t.Helper()
+	iss := &testIssuer{mux: http.NewServeMux(), keys: map[string]*rsa.PrivateKey{}}
+	iss.addKey(t, "key-1")
+
+	iss.mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                                iss.URL,
+			"authorization_endpoint":                iss.URL + "/authorize",
+			"token_endpoint":                        iss.URL + "/token",
+			"jwks_uri":                              iss.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	iss.mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		iss.mu.Lock()
+		defer iss.mu.Unlock()
+
+		keys := []map[string]string{}
+		for kid, key := range iss.keys {
+			keys = append(keys, map[string]string{
+				"kty": "RSA",
+				"alg": "RS256",
+				"use": "sig",
+				"kid": kid,
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"keys": keys})
+	})
+
+	iss.Server = httptest.NewServer(iss.mux)
+	t.Cleanup(iss.Close)
+	return iss
+}
+
+// addKey generates a signing key published as kid.
+func (iss *testIssuer) addKey(t *testing.T, kid string) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	iss.mu.Lock()
+	defer iss.mu.Unlock()
+	iss.keys[kid] = key
+}
+
+// sign returns a JWT of claims signed with the key published as kid. The iss, aud
+// and exp claims default to the issuer, "gosnappass" and an hour from now.
+func (iss *testIssuer) sign(t *testing.T, kid string, claims map[string]any) string {
+	t.Helper()
+	iss.mu.Lock()
+	key := iss.keys[kid]
+	iss.mu.Unlock()
+
+	return signJWT(t, key, kid, iss.defaultClaims(claims))
+}
+
+func (iss *testIssuer) defaultClaims(claims map[string]any) map[string]any {
+	c := map[string]any{
+		"iss": iss.URL,
+		"aud": "gosnappass",
+		"exp": time.Now().Add(time.Hour).Unix(),
+		"iat": time.Now().Unix(),
+	}
+	for name, v := range claims {
+		c[name] = v
+	}
+	return c
+}
+
+// signJWT returns a JWT of claims signed with key using RS256.
+func signJWT(t *testing.T, key *rsa.PrivateKey, kid string, claims map[string]any) string {
+	t.Helper()
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": kid})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestClaimMatches(t *testing.T) {
+	tests := []struct {
+		name    string
+		claim   any
+		pattern string
+		want    bool
+	}{
+		{"string", "my-org/app", "my-org/app", true},
+		{"glob", "my-org/app", "my-org/*", true},
+		{"glob does not cross slashes", "my-org/app/sub", "my-org/*", false},
+		{"list", []any{"a", "b"}, "b", true},
+		{"list without match", []any{"a", "b"}, "c", false},
+		{"integer", float64(42), "42", true},
+		{"large integer", float64(1000000), "1000000", true},
+		{"large integer in exponent notation", float64(1000000), "1e+06", false},
+		{"fraction", 1.5, "1.5", true},
+		{"boolean", true, "true", true},
+		{"object", map[string]any{"a": "b"}, "*", false},
+		{"object in list", []any{map[string]any{"a": "b"}}, "*", false},
+		{"missing", nil, "*", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := claimMatches(tt.claim, tt.pattern); got != tt.want {
+				t.Errorf("claimMatches(%#v, %q) = %t, want %t", tt.claim, tt.pattern, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJWTAuthentication(t *testing.T) {
+	iss := newTestIssuer(t)
+	authenticator, err := NewJWTAuthenticator(context.Background(), []JWTIssuer{{
+		Issuer:   iss.URL,
+		Audience: "gosnappass",
+		Rules: []JWTRule{
+			{Name: "deploys", Claims: map[string]string{"repository": "my-org/*", "environment": "production"}, Scopes: []string{"create"}},
+			{Name: "release runs", Claims: map[string]string{"run_number": "1000000"}, Scopes: []string{"create"}},
+			{Name: "objects", Claims: map[string]string{"context": "*"}, Scopes: []string{"create"}},
+		},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := newTestServer(t, WithJWTAuthenticator(authenticator))
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deploy := map[string]any{"sub": "repo:my-org/app", "repository": "my-org/app", "environment": "production"}
+	withClaims := func(extra map[string]any) map[string]any {
+		c := map[string]any{}
+		for name, v := range deploy {
+			c[name] = v
+		}
+		for name, v := range extra {
+			c[name] = v
+		}
+		return c
+	}
+
+	tests := []struct {
+		name  string
+		token func(t *testing.T) string
+		want  int
+	}{
+		{"matching claims", func(t *testing.T) string {
+			return iss.sign(t, "key-1", deploy)
+		}, http.StatusCreated},
+		{"environment in a list", func(t *testing.T) string {
+			return iss.sign(t, "key-1", withClaims(map[string]any{"environment": []string{"staging", "production"}}))
+		}, http.StatusCreated},
+		{"large numeric claim", func(t *testing.T) string {
+			return iss.sign(t, "key-1", map[string]any{"sub": "run", "run_number": 1000000})
+		}, http.StatusCreated},
+		{"no matching rule", func(t *testing.T) string {
+			return iss.sign(t, "key-1", withClaims(map[string]any{"environment": "staging"}))
+		}, http.StatusForbidden},
+		{"object claim", func(t *testing.T) string {
+			return iss.sign(t, "key-1", map[string]any{"sub": "run", "context": map[string]any{"ref": "main"}})
+		}, http.StatusForbidden},
+		{"wrong audience", func(t *testing.T) string {
+			return iss.sign(t, "key-1", withClaims(map[string]any{"aud": "another-service"}))
+		}, http.StatusUnauthorized},
+		{"expired", func(t *testing.T) string {
+			return iss.sign(t, "key-1", withClaims(map[string]any{"exp": time.Now().Add(-time.Hour).Unix()}))
+		}, http.StatusUnauthorized},
+		{"unknown issuer", func(t *testing.T) string {
+			return iss.sign(t, "key-1", withClaims(map[string]any{"iss": "https://issuer.invalid"}))
+		}, http.StatusUnauthorized},
+		{"unpublished key", func(t *testing.T) string {
+			return signJWT(t, otherKey, "key-1", iss.defaultClaims(deploy))
+		}, http.StatusUnauthorized},
+		{"rotated key", func(t *testing.T) string {
+			iss.addKey(t, "key-2")
+			return iss.sign(t, "key-2", deploy)
+		}, http.StatusCreated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{"Authorization": {"Bearer " + tt.token(t)}}
+			resp := ts.postJSON(t, "/api/v2/secrets", map[string]any{"secret": "s3cr3t"}, header, nil)
+			if resp.StatusCode != tt.want {
+				t.Errorf("got status %d, want %d", resp.StatusCode, tt.want)
+			}
+		})
+	}
+
+	resp := ts.postJSON(t, "/api/v2/secrets", map[string]any{"secret": "s3cr3t"}, nil, nil)
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("without a JWT: got status %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+}
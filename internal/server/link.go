@@ -0,0 +1,238 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/concerthall/gosnappass/internal/linktoken"
+	"github.com/fernet/fernet-go"
+	"github.com/google/uuid"
+)
+
+// Link styles select how the ID and key of a secret are encoded in its link. Links
+// of every style are always accepted, regardless of the style used for new links.
+const (
+	// LinkStyleLong produces the snappass-compatible <prefix><uuid>~<fernet key> link.
+	LinkStyleLong = "long"
+	// LinkStyleShort produces a <base58 id>-<base58 key> link.
+	LinkStyleShort = "short"
+	// LinkStyleWords produces a link made of words from wordList separated by dashes.
+	LinkStyleWords = "words"
+)
+
+const (
+	// shortIDBytes and shortKeyBytes are the sizes of the random ID and key encoded
+	// in short links.
+	shortIDBytes  = 8
+	shortKeyBytes = 16
+
+	// wordIDBytes and wordKeyBytes are the sizes of the random ID and key encoded in
+	// word links, at one word per byte. IDs are large enough that they can't be
+	// enumerated.
+	wordIDBytes  = linktoken.WordIDCount
+	wordKeyBytes = linktoken.WordKeyCount
+
+	// tokenSeparator separates the ID and key of long links, and compactSeparator the
+	// parts of short and word links.
+	tokenSeparator   = linktoken.Separator
+	compactSeparator = linktoken.CompactSeparator
+)
+
+// linkKeyInfo binds keys derived from short and word links to their use.
+const linkKeyInfo = "gosnappass link key"
+
+// wordIndex maps each word in wordList to the byte it encodes.
+var wordIndex = func() map[string]byte {
+	m := make(map[string]byte, len(wordList))
+	for i, w := range wordList {
+		m[w] = byte(i)
+	}
+	return m
+}()
+
+// secretLink identifies a new secret in the database, and carries the key used to
+// encrypt it along with the token that encodes both in the secret's link.
+type secretLink struct {
+	// id is the database key of the secret.
+	id string
+	// key encrypts the secret.
+	key *fernet.Key
+	// token is the path segment of the link.
+	token string
+	// publicID and publicKey are the two parts of token. The ID alone identifies the
+	// secret in the API, and the key is needed to reveal it.
+	publicID  string
+	publicKey string
+}
+
+// newSecretLink generates the ID and key of a new secret using style. Database IDs
+// are prefixed with keyPrefix.
+func newSecretLink(style, keyPrefix string) (secretLink, error) {
+	switch style {
+	case LinkStyleShort:
+		b, err := randomBytes(shortIDBytes + shortKeyBytes)
+		if err != nil {
+			return secretLink{}, err
+		}
+
+		id, key := base58Encode(b[:shortIDBytes]), base58Encode(b[shortIDBytes:])
+		return secretLink{
+			id:        keyPrefix + id,
+			key:       deriveLinkKey(b[shortIDBytes:]),
+			token:     id + compactSeparator + key,
+			publicID:  id,
+			publicKey: key,
+		}, nil
+	case LinkStyleWords:
+		b, err := randomBytes(wordIDBytes + wordKeyBytes)
+		if err != nil {
+			return secretLink{}, err
+		}
+
+		words := make([]string, len(b))
+		for i, c := range b {
+			words[i] = wordList[c]
+		}
+		id := strings.Join(words[:wordIDBytes], compactSeparator)
+		return secretLink{
+			id:        keyPrefix + id,
+			key:       deriveLinkKey(b[wordIDBytes:]),
+			token:     strings.Join(words, compactSeparator),
+			publicID:  id,
+			publicKey: strings.Join(words[wordIDBytes:], compactSeparator),
+		}, nil
+	case LinkStyleLong, "":
+		key := fernet.Key{}
+		if err := key.Generate(); err != nil {
+			return secretLink{}, err
+		}
+
+		id := keyPrefix + uuid.New().String()
+		return secretLink{
+			id:        id,
+			key:       &key,
+			token:     strings.Join([]string{id, url.PathEscape(key.Encode())}, tokenSeparator),
+			publicID:  id,
+			publicKey: key.Encode(),
+		}, nil
+	}
+
+	return secretLink{}, fmt.Errorf("unknown link style %q", style)
+}
+
+// parseToken returns the database ID of the secret identified by the token t, and the
+// encoded fernet key that decrypts it. Tokens of every link style are accepted.
+func parseToken(t, keyPrefix string) (id, key string, err error) {
+	id, key, style, err := linktoken.Split(t)
+	if err != nil {
+		return "", "", err
+	}
+
+	switch style {
+	case linktoken.Long:
+		// Long tokens carry the database key itself, so make sure they can't be used to
+		// reach keys other than secrets.
+		if !isLongID(id, keyPrefix) {
+			return "", "", fmt.Errorf("token ID is not a secret ID with prefix '%s'", keyPrefix)
+		}
+
+		key, err = url.PathUnescape(key)
+		if err != nil {
+			return "", "", err
+		}
+
+		return id, key, nil
+	case linktoken.Short:
+		if _, err := base58Decode(id, shortIDBytes); err != nil {
+			return "", "", fmt.Errorf("invalid short token ID: %w", err)
+		}
+
+		b, err := base58Decode(key, shortKeyBytes)
+		if err != nil {
+			return "", "", fmt.Errorf("invalid short token key: %w", err)
+		}
+
+		return keyPrefix + id, deriveLinkKey(b).Encode(), nil
+	}
+
+	words := strings.Split(strings.ToLower(t), compactSeparator)
+	b := make([]byte, len(words))
+	for i, w := range words {
+		c, ok := wordIndex[w]
+		if !ok {
+			return "", "", fmt.Errorf("unknown word at position %d in token", i)
+		}
+		b[i] = c
+	}
+
+	return keyPrefix + strings.Join(words[:wordIDBytes], compactSeparator), deriveLinkKey(b[wordIDBytes:]).Encode(), nil
+}
+
+// parseSecretID returns the database ID of the secret identified by publicID, the ID
+// part of a token of any link style.
+func parseSecretID(publicID, keyPrefix string) (string, error) {
+	if isLongID(publicID, keyPrefix) {
+		return publicID, nil
+	}
+
+	if _, err := base58Decode(publicID, shortIDBytes); err == nil {
+		return keyPrefix + publicID, nil
+	}
+
+	words := strings.Split(strings.ToLower(publicID), compactSeparator)
+	if len(words) != wordIDBytes {
+		return "", fmt.Errorf("unable to parse secret ID")
+	}
+
+	for _, w := range words {
+		if _, ok := wordIndex[w]; !ok {
+			return "", fmt.Errorf("unable to parse secret ID")
+		}
+	}
+
+	return keyPrefix + strings.Join(words, compactSeparator), nil
+}
+
+// joinToken reassembles the token of a link from its ID and key parts.
+func joinToken(publicID, publicKey, keyPrefix string) string {
+	if isLongID(publicID, keyPrefix) {
+		return publicID + tokenSeparator + url.PathEscape(publicKey)
+	}
+	return publicID + compactSeparator + publicKey
+}
+
+// isLongID returns true if id is the ID part of a long token: keyPrefix followed by a
+// UUID.
+func isLongID(id, keyPrefix string) bool {
+	if !strings.HasPrefix(id, keyPrefix) {
+		return false
+	}
+
+	_, err := uuid.Parse(strings.TrimPrefix(id, keyPrefix))
+	return err == nil
+}
+
+// deriveLinkKey derives the fernet key for a secret from the key material carried in
+// a short or word link. The material is shorter than a fernet key so that the link
+// stays compact.
+func deriveLinkKey(material []byte) *fernet.Key {
+	mac := hmac.New(sha256.New, material)
+	mac.Write([]byte(linkKeyInfo))
+
+	key := fernet.Key{}
+	copy(key[:], mac.Sum(nil))
+	return &key
+}
+
+// randomBytes returns n bytes from a cryptographically secure source.
+func randomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
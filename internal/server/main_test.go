@@ -0,0 +1,165 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/concerthall/gosnappass/internal/config"
+)
+
+// testRedis is the database of every test. RedisClient connects to it once, so
+// tests share it, and newTestServer empties it.
+var testRedis *miniredis.Miniredis
+
+func TestMain(m *testing.M) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		panic(err)
+	}
+	testRedis = mr
+	os.Setenv(config.EnvRedisURL, "redis://"+mr.Addr())
+
+	code := m.Run()
+	mr.Close()
+	os.Exit(code)
+}
+
+// testServer is a server under test, reached over HTTP by a client keeping cookies
+// and not following redirects.
+type testServer struct {
+	*httptest.Server
+	srv    *Server
+	client *http.Client
+}
+
+// newTestServer serves a server with opts on an empty database until the test ends.
+func newTestServer(t *testing.T, opts ...ServerOption) *testServer {
+	t.Helper()
+	testRedis.FlushAll()
+
+	srv := New(append([]ServerOption{LogTo(io.Discard)}, opts...)...)
+	ts := &testServer{Server: httptest.NewServer(srv.router), srv: srv}
+	t.Cleanup(ts.Close)
+
+	ts.client = &http.Client{
+		Jar:           newCookieJar(t),
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	return ts
+}
+
+// newCookieJar returns an empty cookie jar, e.g. to forget the cookies of a test
+// server's client.
+func newCookieJar(t *testing.T) http.CookieJar {
+	t.Helper()
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return jar
+}
+
+// do sends a request for path with body, and returns the response with its body
+// read.
+func (ts *testServer) do(t *testing.T, method, path string, body io.Reader, header http.Header) (*http.Response, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, ts.URL+path, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	resp, err := ts.client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(b)
+}
+
+// get requests path.
+func (ts *testServer) get(t *testing.T, path string) (*http.Response, string) {
+	t.Helper()
+	return ts.do(t, http.MethodGet, path, nil, nil)
+}
+
+// postJSON posts v, encoded as JSON, to path, and decodes the response into out
+// unless it is nil.
+func (ts *testServer) postJSON(t *testing.T, path string, v any, header http.Header, out any) *http.Response {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := http.Header{"Content-Type": {"application/json"}}
+	for name, values := range header {
+		h[name] = values
+	}
+
+	resp, body := ts.do(t, http.MethodPost, path, bytes.NewReader(b), h)
+	if out != nil {
+		if err := json.Unmarshal([]byte(body), out); err != nil {
+			t.Fatalf("unable to decode response %q: %s", body, err)
+		}
+	}
+	return resp
+}
+
+var csrfTokenPattern = regexp.MustCompile(`(?:name="csrf_token" value|data-csrf-token)="([^"]+)"`)
+
+// csrfToken returns the CSRF token of the form on the page at path.
+func (ts *testServer) csrfToken(t *testing.T, path string) string {
+	t.Helper()
+	_, body := ts.get(t, path)
+	m := csrfTokenPattern.FindStringSubmatch(body)
+	if m == nil {
+		t.Fatalf("no CSRF token on page %s: %s", path, body)
+	}
+	return m[1]
+}
+
+// submitForm posts form to path as the form on the page at path would, with its
+// CSRF token.
+func (ts *testServer) submitForm(t *testing.T, path string, form url.Values) (*http.Response, string) {
+	t.Helper()
+	if form == nil {
+		form = url.Values{}
+	}
+	form.Set(csrfFieldName, ts.csrfToken(t, path))
+
+	return ts.do(t, http.MethodPost, path, strings.NewReader(form.Encode()), http.Header{"Content-Type": {"application/x-www-form-urlencoded"}})
+}
+
+// createSecret creates a text secret with the v2 API.
+func (ts *testServer) createSecret(t *testing.T, secret string) apiV2Secret {
+	t.Helper()
+	created := apiV2Secret{}
+	resp := ts.postJSON(t, "/api/v2/secrets", map[string]any{"secret": secret}, nil, &created)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("creating a secret: got status %d", resp.StatusCode)
+	}
+	return created
+}
+
+// tokenOf returns the token of link, its last path segment.
+func tokenOf(link string) string {
+	return link[strings.LastIndex(link, "/")+1:]
+}
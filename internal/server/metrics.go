@@ -0,0 +1,66 @@
+package server
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metricsTimeout bounds the database queries made while metrics are scraped.
+const metricsTimeout = 5 * time.Second
+
+// metrics are the Prometheus metrics of a server. Each server has its own registry,
+// so that servers in the same process don't share counters.
+type metrics struct {
+	registry      *prometheus.Registry
+	probeFailures *prometheus.CounterVec
+	bans          prometheus.Counter
+}
+
+func newMetrics(keyPrefix string) *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		probeFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gosnappass_probe_failures_total",
+			Help: "Requests for secrets with malformed tokens, unknown IDs or wrong keys, by reason.",
+		}, []string{"reason"}),
+		bans: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "gosnappass_bans_total",
+			Help: "Clients banned for probing for secrets.",
+		}),
+	}
+
+	// Bans are shared by replicas, so the number of banned clients is read from the
+	// database rather than counted by each replica.
+	banned := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "gosnappass_banned_clients",
+		Help: "Clients currently banned for probing for secrets.",
+	}, func() float64 {
+		ctx, cancel := context.WithTimeout(context.Background(), metricsTimeout)
+		defer cancel()
+
+		n, err := RedisClient().ZCount(ctx, banIndexKey(keyPrefix), strconv.FormatInt(time.Now().UnixMilli(), 10), "+inf").Result()
+		if err != nil && err != redis.Nil {
+			return math.NaN()
+		}
+		return float64(n)
+	})
+
+	for _, reason := range []string{probeMalformed, probeNotFound, probeInvalidKey} {
+		m.probeFailures.WithLabelValues(reason)
+	}
+
+	m.registry.MustRegister(m.probeFailures, m.bans, banned)
+	return m
+}
+
+// handler serves the metrics in the Prometheus exposition format.
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
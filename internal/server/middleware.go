@@ -39,21 +39,43 @@ func newInjectLoggerMW(logger *slog.Logger) mux.MiddlewareFunc {
 	}
 }
 
+var requestLogContextKey contextKey = "requestLog"
+
+// requestLog collects details learned while serving a request, which logRequestMW
+// includes when logging it.
+type requestLog struct {
+	principal string
+}
+
+// setLoggedPrincipal records p as the client of the request with ctx.
+func setLoggedPrincipal(ctx context.Context, p *apiPrincipal) {
+	if l, ok := ctx.Value(requestLogContextKey).(*requestLog); ok {
+		l.principal = p.String()
+	}
+}
+
 // logRequestMW logs requests with the structured logger found in r.Context().
 func logRequestMW(next http.Handler) http.Handler {
 	return http.HandlerFunc(
 		func(w http.ResponseWriter, r *http.Request) {
 			logger := slog.FromContext(r.Context())
+			rl := &requestLog{}
+			r = r.WithContext(context.WithValue(r.Context(), requestLogContextKey, rl))
 			sr := newStatusRecorder(w)
 			t := time.Now()
 			next.ServeHTTP(sr, r)
-			logger.Info("request served",
+
+			args := []any{
 				"duration_microseconds", time.Since(t).Microseconds(),
 				"method", r.Method,
 				"path", r.URL.String(),
 				"status", sr.status,
 				"requestID", r.Context().Value(requestIDContextKey),
-			)
+			}
+			if rl.principal != "" {
+				args = append(args, "principal", rl.principal)
+			}
+			logger.Info("request served", args...)
 		},
 	)
 }
@@ -0,0 +1,236 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/exp/slog"
+	"golang.org/x/oauth2"
+)
+
+// defaultGroupsClaim is the ID token claim listing the groups of a user.
+const defaultGroupsClaim = "groups"
+
+// OIDCConfig configures login with an OpenID Connect identity provider.
+type OIDCConfig struct {
+	// IssuerURL is the provider's issuer, used for discovery.
+	IssuerURL string
+	// ClientID and ClientSecret are the credentials of this application at the
+	// provider.
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the public URL of this application's /auth/callback endpoint.
+	RedirectURL string
+	// GroupsClaim is the ID token claim listing the groups of a user. Defaults to
+	// "groups".
+	GroupsClaim string
+}
+
+// OIDCProvider logs users in using the authorization code flow with PKCE.
+type OIDCProvider struct {
+	oauth2      oauth2.Config
+	verifier    *oidc.IDTokenVerifier
+	groupsClaim string
+}
+
+// NewOIDCProvider discovers the provider described by cfg.
+func NewOIDCProvider(ctx context.Context, cfg OIDCConfig) (*OIDCProvider, error) {
+	provider, err := oidc.NewProvider(ctx, cfg.IssuerURL)
+	if err != nil {
+		return nil, fmt.Errorf("unable to discover OIDC provider %s: %w", cfg.IssuerURL, err)
+	}
+
+	groupsClaim := cfg.GroupsClaim
+	if groupsClaim == "" {
+		groupsClaim = defaultGroupsClaim
+	}
+
+	return &OIDCProvider{
+		oauth2: oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			RedirectURL:  cfg.RedirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       []string{oidc.ScopeOpenID, "email", "profile"},
+		},
+		verifier:    provider.Verifier(&oidc.Config{ClientID: cfg.ClientID}),
+		groupsClaim: groupsClaim,
+	}, nil
+}
+
+// loginState is stored in a cookie while the user logs in at the provider.
+type loginState struct {
+	State    string `json:"state"`
+	Verifier string `json:"verifier"`
+	// Next is the path to return to after login, relative to the application root.
+	Next string `json:"next"`
+}
+
+// loginRedirect sends the user to the login endpoint, returning to the page of r
+// afterwards. Pages are served one path segment below the application root, so the
+// login endpoint is reached relative to them.
+func loginRedirect(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, "auth/login?"+url.Values{"next": {r.URL.Path}}.Encode(), http.StatusSeeOther)
+}
+
+// newLoginHandler produces a handler that starts a login at cfg's provider.
+func newLoginHandler(cfg routerConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := slog.FromContext(r.Context())
+
+		state, err := randomString()
+		if err != nil {
+			logger.Error("unable to generate login state", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		ls := loginState{
+			State:    state,
+			Verifier: oauth2.GenerateVerifier(),
+			Next:     safeNextPath(r.URL.Query().Get("next")),
+		}
+
+		if err := cfg.sessions.set(w, loginCookieName, ls, loginLifetime); err != nil {
+			logger.Error("unable to store login state", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, cfg.oidc.oauth2.AuthCodeURL(ls.State, oauth2.S256ChallengeOption(ls.Verifier)), http.StatusFound)
+	}
+}
+
+// newCallbackHandler produces a handler that completes a login at cfg's provider and
+// stores the user's identity in a session.
+func newCallbackHandler(cfg routerConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := slog.FromContext(r.Context())
+
+		ls := loginState{}
+		if err := cfg.sessions.get(r, loginCookieName, &ls); err != nil {
+			logger.Info("login callback without a valid login state", "error", err.Error())
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		cfg.sessions.clear(w, loginCookieName)
+
+		if r.URL.Query().Get("state") != ls.State {
+			logger.Info("login callback with a mismatched state")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		if e := r.URL.Query().Get("error"); e != "" {
+			logger.Info("login failed at the provider", "error", e)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		id, err := cfg.oidc.exchange(r.Context(), r.URL.Query().Get("code"), ls.Verifier)
+		if err != nil {
+			logger.Error("unable to complete login", err)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		if err := cfg.sessions.set(w, sessionCookieName, id, sessionLifetime); err != nil {
+			logger.Error("unable to store session", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		auditEvent(r.Context(), auditUserLogin, "user:"+id.name())
+		http.Redirect(w, r, cfg.appPath(ls.Next), http.StatusSeeOther)
+	}
+}
+
+// newLogoutHandler produces a handler that ends the session of the logged in user.
+// Sessions live in cookies only, so the user remains logged in at the provider.
+func newLogoutHandler(cfg routerConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if id := cfg.sessions.identity(r); id != nil {
+			auditEvent(r.Context(), auditUserLogout, "user:"+id.name())
+		}
+
+		cfg.sessions.clear(w, sessionCookieName)
+		http.Redirect(w, r, cfg.appPath("/"), http.StatusSeeOther)
+	}
+}
+
+// exchange trades an authorization code for the identity of the user.
+func (p *OIDCProvider) exchange(ctx context.Context, code, verifier string) (*identity, error) {
+	token, err := p.oauth2.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, err
+	}
+
+	raw, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, fmt.Errorf("token response did not contain an id_token")
+	}
+
+	idToken, err := p.verifier.Verify(ctx, raw)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := map[string]any{}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, err
+	}
+
+	id := identity{Subject: idToken.Subject}
+	if email, ok := claims["email"].(string); ok {
+		id.Email = email
+	}
+	// Providers that don't assert the email is verified may let users set any
+	// address, so only an explicit true claim counts.
+	if verified, ok := claims["email_verified"].(bool); ok && verified {
+		id.EmailVerified = true
+	}
+	if groups, ok := claims[p.groupsClaim].([]any); ok {
+		for _, g := range groups {
+			if s, ok := g.(string); ok {
+				id.Groups = append(id.Groups, s)
+			}
+		}
+	}
+
+	return &id, nil
+}
+
+// safeNextPath returns next if it is a path within this application, or the root
+// otherwise, so that logins can't be used to redirect users elsewhere.
+func safeNextPath(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.Contains(next, "\\") {
+		return "/"
+	}
+	return next
+}
+
+// appPath returns p, a path relative to the application root, under cfg's
+// pathPrefix. A trailing slash is kept, since proxies routing the prefix may only
+// match paths below it, e.g. /sharepass/ but not /sharepass.
+func (cfg routerConfig) appPath(p string) string {
+	joined := path.Join("/", cfg.pathPrefix, p)
+	if strings.HasSuffix(p, "/") && !strings.HasSuffix(joined, "/") {
+		joined += "/"
+	}
+	return joined
+}
+
+// randomString returns a random, URL safe string.
+func randomString() (string, error) {
+	b, err := randomBytes(32)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,352 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testIDP stands in for an OpenID Connect identity provider. It logs in whichever
+// user was set with setUser, without asking, and only completes logins with PKCE.
+type testIDP struct {
+	*testIssuer
+	mu    sync.Mutex
+	user  map[string]any
+	codes map[string]testAuthorization
+}
+
+// testAuthorization is an authorization code issued by a testIDP.
+type testAuthorization struct {
+	challenge string
+	claims    map[string]any
+}
+
+func newTestIDP(t *testing.T) *testIDP {
+	t.Helper()
+	idp := &testIDP{testIssuer: newTestIssuer(t), codes: map[string]testAuthorization{}}
+
+	idp.mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("response_type") != "code" || q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+			http.Error(w, "PKCE is required", http.StatusBadRequest)
+			return
+		}
+
+		code, err := randomString()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		idp.mu.Lock()
+		idp.codes[code] = testAuthorization{challenge: q.Get("code_challenge"), claims: idp.user}
+		idp.mu.Unlock()
+
+		redirect, err := url.Parse(q.Get("redirect_uri"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		redirect.RawQuery = url.Values{"code": {code}, "state": {q.Get("state")}}.Encode()
+		http.Redirect(w, r, redirect.String(), http.StatusFound)
+	})
+
+	idp.mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_request"})
+			return
+		}
+
+		// Codes are only exchanged once.
+		idp.mu.Lock()
+		authz, ok := idp.codes[r.PostForm.Get("code")]
+		delete(idp.codes, r.PostForm.Get("code"))
+		idp.mu.Unlock()
+
+		challenge := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if !ok || base64.RawURLEncoding.EncodeToString(challenge[:]) != authz.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "access-token",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     idp.sign(t, "key-1", authz.claims),
+		})
+	})
+
+	return idp
+}
+
+// setUser sets the ID token claims of the user who logs in next.
+func (idp *testIDP) setUser(claims map[string]any) {
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	idp.user = claims
+}
+
+// provider returns an OIDCProvider logging in with idp as the client "gosnappass".
+func (idp *testIDP) provider(t *testing.T) *OIDCProvider {
+	t.Helper()
+	p, err := NewOIDCProvider(context.Background(), OIDCConfig{
+		IssuerURL:    idp.URL,
+		ClientID:     "gosnappass",
+		ClientSecret: "client-secret",
+		RedirectURL:  "https://snappass.example.com/auth/callback",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// authorize starts a login at ts, and returns the query of the callback that idp
+// sends the user back with.
+func (ts *testServer) authorize(t *testing.T) url.Values {
+	t.Helper()
+	resp, _ := ts.get(t, "/auth/login?next=/")
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("starting a login: got status %d", resp.StatusCode)
+	}
+
+	resp, err := ts.client.Get(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorizing at the provider: got status %d", resp.StatusCode)
+	}
+	return callback.Query()
+}
+
+// login logs in at ts as the user with claims.
+func (ts *testServer) login(t *testing.T, idp *testIDP, claims map[string]any) {
+	t.Helper()
+	idp.setUser(claims)
+	resp, _ := ts.get(t, "/auth/callback?"+ts.authorize(t).Encode())
+	if resp.StatusCode != http.StatusSeeOther || resp.Header.Get("Location") != "/" {
+		t.Fatalf("completing the login: got status %d, Location %q", resp.StatusCode, resp.Header.Get("Location"))
+	}
+}
+
+// syncBuffer is a buffer that the server can log to while tests read it.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+var alice = map[string]any{"sub": "alice-id", "email": "alice@corp.example", "email_verified": true}
+
+func TestOIDCCreatorLogin(t *testing.T) {
+	idp := newTestIDP(t)
+	logs := &syncBuffer{}
+	ts := newTestServer(t, WithOIDCProvider(idp.provider(t)), WithCreatorLogin(CreatorPolicy{Domains: []string{"corp.example"}}), LogTo(logs))
+
+	resp, _ := ts.get(t, "/")
+	if resp.StatusCode != http.StatusSeeOther || !strings.HasPrefix(resp.Header.Get("Location"), "/auth/login?") {
+		t.Fatalf("before login: got status %d, Location %q", resp.StatusCode, resp.Header.Get("Location"))
+	}
+
+	apiErr := apiV2Error{}
+	resp = ts.postJSON(t, "/api/v2/secrets", map[string]any{"secret": "s3cr3t"}, nil, &apiErr)
+	if resp.StatusCode != http.StatusUnauthorized || apiErr.Error.Code != apiErrLoginRequired {
+		t.Errorf("creating with the API before login: got status %d, code %q", resp.StatusCode, apiErr.Error.Code)
+	}
+
+	ts.login(t, idp, alice)
+	if !strings.Contains(logs.String(), `"event":"user.login","actor":"user:alice@corp.example"`) {
+		t.Errorf("the login wasn't audited: %s", logs)
+	}
+
+	resp, body := ts.get(t, "/")
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, "alice@corp.example") {
+		t.Fatalf("after login: got status %d", resp.StatusCode)
+	}
+
+	if resp, _ := ts.submitForm(t, "/", url.Values{"password": {"s3cr3t"}, "ttl": {"hour"}}); resp.StatusCode != http.StatusOK {
+		t.Errorf("creating after login: got status %d", resp.StatusCode)
+	}
+
+	created := ts.createSecret(t, "s3cr3t")
+	if !strings.Contains(logs.String(), `"event":"secret.created","actor":"user:alice@corp.example"`) {
+		t.Errorf("the creator wasn't audited: %s", logs)
+	}
+
+	// Recipients don't log in.
+	anonymous, err := http.Get(created.Link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	anonymous.Body.Close()
+	if anonymous.StatusCode != http.StatusOK {
+		t.Errorf("previewing without login: got status %d", anonymous.StatusCode)
+	}
+
+	form := http.Header{"Content-Type": {"application/x-www-form-urlencoded"}}
+	if resp, _ := ts.do(t, http.MethodPost, "/auth/logout", nil, form); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("logging out without a CSRF token: got status %d", resp.StatusCode)
+	}
+
+	logout := url.Values{csrfFieldName: {ts.csrfToken(t, "/")}}
+	if resp, _ := ts.do(t, http.MethodPost, "/auth/logout", strings.NewReader(logout.Encode()), form); resp.StatusCode != http.StatusSeeOther {
+		t.Errorf("logging out: got status %d", resp.StatusCode)
+	}
+
+	if resp, _ := ts.get(t, "/"); resp.StatusCode != http.StatusSeeOther {
+		t.Errorf("after logout: got status %d", resp.StatusCode)
+	}
+	if !strings.Contains(logs.String(), `"event":"user.logout","actor":"user:alice@corp.example"`) {
+		t.Errorf("the logout wasn't audited: %s", logs)
+	}
+}
+
+func TestOIDCCreatorPolicy(t *testing.T) {
+	idp := newTestIDP(t)
+	ts := newTestServer(t, WithOIDCProvider(idp.provider(t)), WithCreatorLogin(CreatorPolicy{Groups: []string{"sre"}, Domains: []string{"corp.example"}}))
+
+	tests := []struct {
+		name   string
+		claims map[string]any
+		want   int
+	}{
+		{"verified email", alice, http.StatusOK},
+		{"group", map[string]any{"sub": "bob-id", "groups": []string{"dev", "sre"}}, http.StatusOK},
+		{"missing email_verified", map[string]any{"sub": "mallory-id", "email": "mallory@corp.example"}, http.StatusForbidden},
+		{"unverified email", map[string]any{"sub": "mallory-id", "email": "mallory@corp.example", "email_verified": false}, http.StatusForbidden},
+		{"email_verified string", map[string]any{"sub": "mallory-id", "email": "mallory@corp.example", "email_verified": "true"}, http.StatusForbidden},
+		{"other domain", map[string]any{"sub": "vendor-id", "email": "vendor@vendor.example", "email_verified": true}, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts.client.Jar = newCookieJar(t)
+			ts.login(t, idp, tt.claims)
+			if resp, _ := ts.get(t, "/"); resp.StatusCode != tt.want {
+				t.Errorf("got status %d, want %d", resp.StatusCode, tt.want)
+			}
+		})
+	}
+}
+
+func TestOIDCCallback(t *testing.T) {
+	idp := newTestIDP(t)
+	ts := newTestServer(t, WithOIDCProvider(idp.provider(t)), WithCreatorLogin(CreatorPolicy{}))
+	idp.setUser(alice)
+
+	t.Run("mismatched state", func(t *testing.T) {
+		callback := ts.authorize(t)
+		callback.Set("state", "forged")
+		if resp, _ := ts.get(t, "/auth/callback?"+callback.Encode()); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("got status %d", resp.StatusCode)
+		}
+	})
+
+	t.Run("without a login", func(t *testing.T) {
+		callback := ts.authorize(t)
+		ts.client.Jar = newCookieJar(t)
+		if resp, _ := ts.get(t, "/auth/callback?"+callback.Encode()); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("got status %d", resp.StatusCode)
+		}
+	})
+
+	t.Run("replayed code", func(t *testing.T) {
+		callback := ts.authorize(t)
+		if resp, _ := ts.get(t, "/auth/callback?"+callback.Encode()); resp.StatusCode != http.StatusSeeOther {
+			t.Fatalf("got status %d", resp.StatusCode)
+		}
+
+		// A new login, completed with the code of the previous one.
+		replayed := ts.authorize(t)
+		replayed.Set("code", callback.Get("code"))
+		if resp, _ := ts.get(t, "/auth/callback?"+replayed.Encode()); resp.StatusCode != http.StatusForbidden {
+			t.Errorf("got status %d", resp.StatusCode)
+		}
+	})
+
+	t.Run("provider error", func(t *testing.T) {
+		callback := ts.authorize(t)
+		callback.Del("code")
+		callback.Set("error", "access_denied")
+		if resp, _ := ts.get(t, "/auth/callback?"+callback.Encode()); resp.StatusCode != http.StatusForbidden {
+			t.Errorf("got status %d", resp.StatusCode)
+		}
+	})
+}
+
+func TestOIDCPathPrefix(t *testing.T) {
+	idp := newTestIDP(t)
+	ts := newTestServer(t, WithOIDCProvider(idp.provider(t)), WithCreatorLogin(CreatorPolicy{}), WithPathPrefix("/sharepass"))
+	idp.setUser(alice)
+
+	// Behind a proxy serving the server under /sharepass/, to which its cookies are
+	// scoped.
+	proxy := httptest.NewServer(http.StripPrefix("/sharepass", ts.srv.router))
+	t.Cleanup(proxy.Close)
+	ts.Server = proxy
+
+	resp, _ := ts.get(t, "/sharepass/auth/login?next=/")
+	authorized, err := ts.client.Get(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	authorized.Body.Close()
+	callback, err := url.Parse(authorized.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, _ = ts.get(t, "/sharepass/auth/callback?"+callback.RawQuery)
+	if resp.StatusCode != http.StatusSeeOther || resp.Header.Get("Location") != "/sharepass/" {
+		t.Errorf("completing the login: got status %d, Location %q", resp.StatusCode, resp.Header.Get("Location"))
+	}
+
+	form := url.Values{csrfFieldName: {ts.csrfToken(t, "/sharepass/")}}
+	resp, _ = ts.do(t, http.MethodPost, "/sharepass/auth/logout", strings.NewReader(form.Encode()), http.Header{"Content-Type": {"application/x-www-form-urlencoded"}})
+	if resp.StatusCode != http.StatusSeeOther || resp.Header.Get("Location") != "/sharepass/" {
+		t.Errorf("logging out: got status %d, Location %q", resp.StatusCode, resp.Header.Get("Location"))
+	}
+
+	tests := []struct {
+		prefix, p, want string
+	}{
+		{"", "/", "/"},
+		{"", "/token", "/token"},
+		{"/sharepass", "/", "/sharepass/"},
+		{"/sharepass/", "/", "/sharepass/"},
+		{"/sharepass/", "/token", "/sharepass/token"},
+		{"/sharepass", "/dir/", "/sharepass/dir/"},
+	}
+	for _, tt := range tests {
+		if got := (routerConfig{pathPrefix: tt.prefix}).appPath(tt.p); got != tt.want {
+			t.Errorf("appPath(%q) with prefix %q = %q, want %q", tt.p, tt.prefix, got, tt.want)
+		}
+	}
+}
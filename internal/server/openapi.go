@@ -0,0 +1,25 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/concerthall/gosnappass/internal/embedded"
+	"github.com/concerthall/gosnappass/internal/view"
+	"golang.org/x/exp/slog"
+)
+
+// openAPISpecHandler serves the OpenAPI specification of the JSON APIs. GET
+func openAPISpecHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(embedded.OpenAPISpec)
+}
+
+// apiDocsHandler renders the documentation of the JSON APIs. GET
+func apiDocsHandler(w http.ResponseWriter, r *http.Request) {
+	logger := slog.FromContext(r.Context())
+	if err := view.APIDocs(w); err != nil {
+		logger.Error("unable to render view APIDocs", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,246 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/concerthall/gosnappass/internal/apitoken"
+	"github.com/concerthall/gosnappass/internal/embedded"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+)
+
+func TestOpenAPISpecIsValid(t *testing.T) {
+	doc, err := openapi3.NewLoader().LoadFromData(embedded.OpenAPISpec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := doc.Validate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// specClient sends API requests to a test server, and fails the test if a request or
+// its response doesn't conform to the OpenAPI specification.
+type specClient struct {
+	ts     *testServer
+	router routers.Router
+}
+
+func newSpecClient(t *testing.T, ts *testServer) *specClient {
+	t.Helper()
+	doc, err := openapi3.NewLoader().LoadFromData(embedded.OpenAPISpec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc.Servers = openapi3.Servers{{URL: ts.URL}}
+	router, err := gorillamux.NewRouter(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &specClient{ts: ts, router: router}
+}
+
+// do sends a request with the JSON body, if it isn't nil, validating the request and
+// its response, and returns the status and the body decoded into out, unless it is
+// nil.
+func (c *specClient) do(t *testing.T, method, path string, body any, header http.Header, out any) int {
+	t.Helper()
+	return c.send(t, method, path, body, header, out, true)
+}
+
+// doInvalid sends a request that deliberately violates the specification, and only
+// validates its response.
+func (c *specClient) doInvalid(t *testing.T, method, path string, body any, header http.Header, out any) int {
+	t.Helper()
+	return c.send(t, method, path, body, header, out, false)
+}
+
+func (c *specClient) send(t *testing.T, method, path string, body any, header http.Header, out any, validateRequest bool) int {
+	t.Helper()
+	var b []byte
+	if body != nil {
+		var err error
+		if b, err = json.Marshal(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	req, err := http.NewRequest(method, c.ts.URL+path, bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	route, params, err := c.router.FindRoute(req)
+	if err != nil {
+		t.Fatalf("%s %s is not in the specification: %s", method, path, err)
+	}
+
+	ctx := context.Background()
+	input := &openapi3filter.RequestValidationInput{
+		Request:    req.Clone(ctx),
+		PathParams: params,
+		Route:      route,
+		Options:    &openapi3filter.Options{AuthenticationFunc: openapi3filter.NoopAuthenticationFunc},
+	}
+	input.Request.Body = io.NopCloser(bytes.NewReader(b))
+	if err := openapi3filter.ValidateRequest(ctx, input); validateRequest && err != nil {
+		t.Errorf("%s %s: the request doesn't conform to the specification: %s", method, path, err)
+	}
+
+	resp, err := c.ts.client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = openapi3filter.ValidateResponse(ctx, &openapi3filter.ResponseValidationInput{
+		RequestValidationInput: input,
+		Status:                 resp.StatusCode,
+		Header:                 resp.Header,
+		Body:                   io.NopCloser(bytes.NewReader(respBody)),
+	})
+	if err != nil {
+		t.Errorf("%s %s: the %d response doesn't conform to the specification: %s", method, path, resp.StatusCode, err)
+	}
+
+	if out != nil {
+		if err := json.Unmarshal(respBody, out); err != nil {
+			t.Fatalf("unable to decode response %q: %s", respBody, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestAPIConformsToOpenAPISpec(t *testing.T) {
+	ts := newTestServer(t)
+	c := newSpecClient(t, ts)
+
+	expect := func(t *testing.T, got, want int) {
+		t.Helper()
+		if got != want {
+			t.Errorf("got status %d, want %d", got, want)
+		}
+	}
+
+	t.Run("snappass API", func(t *testing.T) {
+		expect(t, c.do(t, http.MethodPost, "/api/set_password/", map[string]any{"password": "foobar", "ttl": 3600}, nil, nil), http.StatusOK)
+		expect(t, c.do(t, http.MethodPost, "/api/set_password/", map[string]any{"password": "foobar", "ttl": "60"}, nil, nil), http.StatusOK)
+		expect(t, c.doInvalid(t, http.MethodPost, "/api/set_password/", map[string]any{"ttl": 60}, nil, nil), http.StatusInternalServerError)
+	})
+
+	created := apiV2Secret{}
+	t.Run("create text", func(t *testing.T) {
+		expect(t, c.do(t, http.MethodPost, "/api/v2/secrets", map[string]any{"secret": "hunter2", "ttl": 600, "label": "db"}, nil, &created), http.StatusCreated)
+	})
+
+	fields := apiV2Secret{}
+	t.Run("create fields", func(t *testing.T) {
+		body := map[string]any{"fields": []map[string]string{{"name": "user", "value": "root"}, {"name": "password", "value": "hunter2"}}}
+		expect(t, c.do(t, http.MethodPost, "/api/v2/secrets", body, nil, &fields), http.StatusCreated)
+	})
+
+	pending := apiV2Secret{}
+	t.Run("create scheduled", func(t *testing.T) {
+		body := map[string]any{"secret": "later", "not_before": time.Now().Add(time.Hour).UTC().Format(time.RFC3339)}
+		expect(t, c.do(t, http.MethodPost, "/api/v2/secrets", body, nil, &pending), http.StatusCreated)
+	})
+
+	t.Run("create invalid", func(t *testing.T) {
+		expect(t, c.doInvalid(t, http.MethodPost, "/api/v2/secrets", map[string]any{"secret": "x", "ttl": 0}, nil, nil), http.StatusBadRequest)
+		expect(t, c.doInvalid(t, http.MethodPost, "/api/v2/secrets", map[string]any{"secret": ""}, nil, nil), http.StatusBadRequest)
+		expect(t, c.doInvalid(t, http.MethodPost, "/api/v2/secrets", map[string]any{"secret": "x", "fields": []map[string]string{{"name": "a", "value": "b"}}}, nil, nil), http.StatusBadRequest)
+	})
+
+	t.Run("status", func(t *testing.T) {
+		expect(t, c.do(t, http.MethodGet, "/api/v2/secrets/"+created.ID, nil, nil, nil), http.StatusOK)
+		expect(t, c.do(t, http.MethodGet, "/api/v2/secrets/"+pending.ID, nil, nil, nil), http.StatusOK)
+		expect(t, c.do(t, http.MethodGet, "/api/v2/secrets/snappass00000000-0000-0000-0000-000000000000", nil, nil, nil), http.StatusNotFound)
+	})
+
+	t.Run("reveal", func(t *testing.T) {
+		expect(t, c.do(t, http.MethodPost, "/api/v2/secrets/"+created.ID+"/reveal", apiV2KeyRequest{Key: fields.Key}, nil, nil), http.StatusForbidden)
+		expect(t, c.do(t, http.MethodPost, "/api/v2/secrets/"+pending.ID+"/reveal", apiV2KeyRequest{Key: pending.Key}, nil, nil), http.StatusConflict)
+		expect(t, c.do(t, http.MethodPost, "/api/v2/secrets/"+created.ID+"/reveal", apiV2KeyRequest{Key: created.Key}, nil, nil), http.StatusOK)
+		expect(t, c.do(t, http.MethodPost, "/api/v2/secrets/"+fields.ID+"/reveal", apiV2KeyRequest{Key: fields.Key}, nil, nil), http.StatusOK)
+		expect(t, c.do(t, http.MethodPost, "/api/v2/secrets/"+created.ID+"/reveal", apiV2KeyRequest{Key: created.Key}, nil, nil), http.StatusGone)
+		expect(t, c.do(t, http.MethodGet, "/api/v2/secrets/"+created.ID, nil, nil, nil), http.StatusGone)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		expect(t, c.do(t, http.MethodDelete, "/api/v2/secrets/"+pending.ID, apiV2KeyRequest{Key: fields.Key}, nil, nil), http.StatusForbidden)
+		expect(t, c.do(t, http.MethodDelete, "/api/v2/secrets/"+pending.ID, apiV2KeyRequest{Key: pending.Key}, nil, nil), http.StatusNoContent)
+		expect(t, c.do(t, http.MethodDelete, "/api/v2/secrets/"+pending.ID, apiV2KeyRequest{Key: pending.Key}, nil, nil), http.StatusGone)
+	})
+}
+
+func TestAPIErrorsConformToOpenAPISpec(t *testing.T) {
+	path := t.TempDir() + "/tokens.json"
+	create, err := apitoken.Mint(path, "creator", []string{apitoken.ScopeCreate})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reveal, err := apitoken.Mint(path, "revealer", []string{apitoken.ScopeReveal})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := apitoken.NewStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	limits := DefaultRateLimits()
+	limits.Create = RateLimit{Requests: 1, Window: time.Minute}
+	ts := newTestServer(t, WithAPITokens(store), WithRateLimits(limits), WithProbeBans(ProbeBans{Threshold: 1, Window: time.Minute, Duration: time.Minute}))
+	c := newSpecClient(t, ts)
+
+	bearer := func(token string) http.Header {
+		return http.Header{"Authorization": {"Bearer " + token}}
+	}
+	body := map[string]any{"secret": "x"}
+	unknown := "/api/v2/secrets/snappass00000000-0000-0000-0000-000000000000"
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   any
+		header http.Header
+		want   int
+	}{
+		{"without a token", http.MethodPost, "/api/v2/secrets", body, nil, http.StatusUnauthorized},
+		{"with an invalid token", http.MethodPost, "/api/v2/secrets", body, bearer(apitoken.Prefix + "invalid"), http.StatusUnauthorized},
+		{"without the create scope", http.MethodPost, "/api/v2/secrets", body, bearer(reveal), http.StatusForbidden},
+		{"create", http.MethodPost, "/api/v2/secrets", body, bearer(create), http.StatusCreated},
+		{"over the rate limit", http.MethodPost, "/api/v2/secrets", body, bearer(create), http.StatusTooManyRequests},
+		{"unknown secret", http.MethodGet, unknown, nil, bearer(reveal), http.StatusNotFound},
+		{"unknown secret again", http.MethodGet, unknown, nil, bearer(reveal), http.StatusNotFound},
+		{"banned", http.MethodGet, unknown, nil, bearer(reveal), http.StatusTooManyRequests},
+	}
+
+	for _, test := range tests {
+		if got := c.do(t, test.method, test.path, test.body, test.header, nil); got != test.want {
+			t.Errorf("%s: got status %d, want %d", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,303 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/concerthall/gosnappass/internal/view"
+	"github.com/go-redis/redis/v8"
+	"golang.org/x/exp/slog"
+)
+
+// Reasons a request for a secret counts as probing.
+const (
+	// probeMalformed is a token that can't be parsed.
+	probeMalformed = "malformed"
+	// probeNotFound is an ID that never existed, or whose tombstone is gone.
+	probeNotFound = "not_found"
+	// probeInvalidKey is a key that doesn't decrypt the secret.
+	probeInvalidKey = "invalid_key"
+)
+
+// ProbeBans bans clients that fail to look up secrets too often, since they are
+// likely guessing tokens. A zero Threshold bans nobody.
+type ProbeBans struct {
+	// Threshold is the number of failures a client may make per Window. The next
+	// failure bans it.
+	Threshold int
+	Window    time.Duration
+	// Duration is how long a client stays banned.
+	Duration time.Duration
+}
+
+// DefaultProbeBans returns the bans of a server unless WithProbeBans sets others.
+func DefaultProbeBans() ProbeBans {
+	return ProbeBans{Threshold: 20, Window: 10 * time.Minute, Duration: time.Hour}
+}
+
+// Ban is a client banned for probing for secrets.
+type Ban struct {
+	// Client is the API credential or IP address of the client, as it appears in
+	// logs.
+	Client   string
+	Failures int
+	Since    time.Time
+	Expires  time.Time
+}
+
+// storedBan is the value persisted in the database for each ban.
+type storedBan struct {
+	Failures int   `json:"failures"`
+	Since    int64 `json:"since"`
+}
+
+// banKey returns the database key of the ban of client.
+func banKey(keyPrefix, client string) string {
+	return auxKey(keyPrefix, "ban", client)
+}
+
+// banIndexKey returns the database key of the sorted set of banned clients, scored
+// by the unix time in milliseconds at which their bans expire.
+func banIndexKey(keyPrefix string) string {
+	return auxKey(keyPrefix, "bans", "index")
+}
+
+// probeCounterKey returns the database key counting the failures of client in the
+// window starting at start.
+func probeCounterKey(keyPrefix, client string, start time.Time) string {
+	return auxKey(keyPrefix, "probes", client+":"+strconv.FormatInt(start.Unix(), 10))
+}
+
+// probeTracker counts the failures of each client in fixed windows, and bans clients
+// exceeding the threshold. Counters and bans are kept in the database so that
+// replicas share them.
+type probeTracker struct {
+	bans      ProbeBans
+	keyPrefix string
+	metrics   *metrics
+}
+
+func newProbeTracker(bans ProbeBans, keyPrefix string, m *metrics) *probeTracker {
+	return &probeTracker{bans: bans, keyPrefix: keyPrefix, metrics: m}
+}
+
+// record counts a failure of client for reason, banning it if it exceeded the
+// threshold.
+func (pt *probeTracker) record(ctx context.Context, client, reason string) {
+	logger := slog.FromContext(ctx)
+	pt.metrics.probeFailures.WithLabelValues(reason).Inc()
+	if pt.bans.Threshold == 0 {
+		return
+	}
+
+	now := time.Now()
+	start := now.Truncate(pt.bans.Window)
+	key := probeCounterKey(pt.keyPrefix, client, start)
+
+	var incr *redis.IntCmd
+	_, err := RedisClient().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		incr = pipe.Incr(ctx, key)
+		pipe.PExpireAt(ctx, key, start.Add(pt.bans.Window))
+		return nil
+	})
+	if err != nil {
+		logger.Error("unable to count probe in the database", err)
+		return
+	}
+
+	// Only the failure crossing the threshold bans the client, so that concurrent
+	// failures don't extend the ban.
+	failures := int(incr.Val())
+	if failures != pt.bans.Threshold+1 {
+		return
+	}
+
+	if err := pt.ban(ctx, client, failures, now); err != nil {
+		logger.Error("unable to store ban in the database", err)
+		return
+	}
+
+	pt.metrics.bans.Inc()
+	logger.Warn("banned client probing for secrets", "client", client, "failures", failures, "duration", pt.bans.Duration.String())
+}
+
+// ban stores the ban of client, which made failures failures, from now.
+func (pt *probeTracker) ban(ctx context.Context, client string, failures int, now time.Time) error {
+	v, err := json.Marshal(storedBan{Failures: failures, Since: now.Unix()})
+	if err != nil {
+		return err
+	}
+
+	expires := now.Add(pt.bans.Duration)
+	_, err = RedisClient().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, banKey(pt.keyPrefix, client), v, pt.bans.Duration)
+		pipe.ZAdd(ctx, banIndexKey(pt.keyPrefix), &redis.Z{Score: float64(expires.UnixMilli()), Member: client})
+		pipe.ZRemRangeByScore(ctx, banIndexKey(pt.keyPrefix), "-inf", strconv.FormatInt(now.UnixMilli(), 10))
+		return nil
+	})
+	return err
+}
+
+// banned returns true and the remainder of the ban if client is banned.
+func (pt *probeTracker) banned(ctx context.Context, client string) (bool, time.Duration, error) {
+	ttl, err := RedisClient().PTTL(ctx, banKey(pt.keyPrefix, client)).Result()
+	if err != nil {
+		return false, 0, err
+	}
+
+	// PTTL is negative for missing keys.
+	if ttl <= 0 {
+		return false, 0, nil
+	}
+	return true, ttl, nil
+}
+
+// recordProbe counts a failure of the client of r to look up a secret for reason.
+func (cfg routerConfig) recordProbe(r *http.Request, reason string) {
+	if cfg.probes == nil {
+		return
+	}
+	cfg.probes.record(r.Context(), rateLimitClient(r), reason)
+}
+
+// recordNotFound counts a failure of the client of r to find the secret at id,
+// unless the secret existed recently. Links of secrets that are gone are expected to
+// be followed again, and don't count as probing.
+func (cfg routerConfig) recordNotFound(r *http.Request, id string) {
+	if cfg.probes == nil {
+		return
+	}
+
+	_, gone, err := secretGone(r.Context(), cfg.redisKeyPrefix, id)
+	if err != nil {
+		slog.FromContext(r.Context()).Error("unable to query tombstone from database", err)
+		return
+	}
+
+	if !gone {
+		cfg.recordProbe(r, probeNotFound)
+	}
+}
+
+// newProbeBanMW produces a middleware rejecting the requests of clients banned by
+// probes. Static assets are still served, so that the page explaining the ban is
+// styled. If the database fails, requests are let through.
+func newProbeBanMW(probes *probeTracker) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if strings.HasPrefix(r.URL.Path, "/static/") || r.URL.Path == "/favicon.ico" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			logger := slog.FromContext(r.Context())
+			client := rateLimitClient(r)
+			banned, retryAfter, err := probes.banned(r.Context(), client)
+			if err != nil {
+				logger.Error("unable to query ban from database", err)
+			}
+
+			if !banned {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			logger.Info("rejected request of banned client", "client", client)
+
+			// Retry-After is in whole seconds, rounded up so that retrying then succeeds.
+			retryAfter = (retryAfter + time.Second - 1).Truncate(time.Second)
+			w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter/time.Second)))
+			if strings.HasPrefix(r.URL.Path, "/api/") {
+				writeAPIError(w, http.StatusTooManyRequests, apiErrClientBanned, "too many requests for unknown secrets, retry after "+retryAfter.String())
+				return
+			}
+
+			w.WriteHeader(http.StatusTooManyRequests)
+			if err := view.Banned(w, retryAfter); err != nil {
+				logger.Error("unable to render view Banned", err)
+			}
+		})
+	}
+}
+
+// AdminBans returns the clients banned under keyPrefix, soonest to expire first.
+func AdminBans(ctx context.Context, keyPrefix string) ([]Ban, error) {
+	index := banIndexKey(keyPrefix)
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	if err := RedisClient().ZRemRangeByScore(ctx, index, "-inf", now).Err(); err != nil {
+		return nil, err
+	}
+
+	entries, err := RedisClient().ZRangeWithScores(ctx, index, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	bans := make([]Ban, 0, len(entries))
+	for _, e := range entries {
+		client, _ := e.Member.(string)
+		v, err := RedisClient().Get(ctx, banKey(keyPrefix, client)).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		stored := storedBan{}
+		if err := json.Unmarshal([]byte(v), &stored); err != nil {
+			return nil, err
+		}
+
+		bans = append(bans, Ban{
+			Client:   client,
+			Failures: stored.Failures,
+			Since:    time.Unix(stored.Since, 0),
+			Expires:  time.UnixMilli(int64(e.Score)),
+		})
+	}
+
+	return bans, nil
+}
+
+// AdminLiftBan lifts the ban of client under keyPrefix, and forgets its failures.
+func AdminLiftBan(ctx context.Context, keyPrefix, client string) error {
+	n, err := RedisClient().Del(ctx, banKey(keyPrefix, client)).Result()
+	if err != nil {
+		return err
+	}
+
+	if err := RedisClient().ZRem(ctx, banIndexKey(keyPrefix), client).Err(); err != nil {
+		return err
+	}
+
+	// Counters are suffixed with the start of their window. Other suffixes belong to
+	// clients whose name begins with this client's.
+	counterPrefix := auxKey(keyPrefix, "probes", client+":")
+	err = scanKeys(ctx, escapeGlob(counterPrefix)+"*", func(keys []string) error {
+		counters := []string{}
+		for _, k := range keys {
+			if _, err := strconv.ParseInt(strings.TrimPrefix(k, counterPrefix), 10, 64); err == nil {
+				counters = append(counters, k)
+			}
+		}
+
+		if len(counters) == 0 {
+			return nil
+		}
+		return RedisClient().Del(ctx, counters...).Err()
+	})
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return fmt.Errorf("client %q is not banned", client)
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testClient is the client of test servers, as bans name it.
+const testClient = "ip:127.0.0.1"
+
+// probe requests n malformed tokens from ts.
+func (ts *testServer) probe(t *testing.T, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if resp, _ := ts.get(t, "/not-a-token"); resp.StatusCode != http.StatusOK {
+			t.Fatalf("probing: got status %d", resp.StatusCode)
+		}
+	}
+}
+
+// scrape returns the metrics of ts.
+func (ts *testServer) scrape(t *testing.T) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	ts.srv.MetricsHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("scraping metrics: got status %d", w.Code)
+	}
+	return w.Body.String()
+}
+
+func TestProbeBans(t *testing.T) {
+	ts := newTestServer(t, WithProbeBans(ProbeBans{Threshold: 3, Window: time.Hour, Duration: time.Minute}))
+
+	// Links of secrets that are gone don't count.
+	revealed := ts.createSecret(t, "s3cr3t")
+	if resp := ts.postJSON(t, "/api/v2/secrets/"+revealed.ID+"/reveal", apiV2KeyRequest{Key: revealed.Key}, nil, nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("revealing: got status %d", resp.StatusCode)
+	}
+	for i := 0; i < 5; i++ {
+		ts.get(t, "/"+tokenOf(revealed.Link))
+	}
+
+	ts.probe(t, 3)
+	if resp, _ := ts.get(t, "/"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("at the threshold: got status %d", resp.StatusCode)
+	}
+
+	ts.probe(t, 1)
+	resp, body := ts.get(t, "/")
+	if resp.StatusCode != http.StatusTooManyRequests || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		t.Fatalf("over the threshold: got status %d, Content-Type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	if retryAfter := resp.Header.Get("Retry-After"); retryAfter != "60" {
+		t.Errorf("got Retry-After %q, want 60", retryAfter)
+	}
+	if strings.Contains(body, "client_banned") {
+		t.Errorf("the page got an API error")
+	}
+
+	apiErr := apiV2Error{}
+	resp = ts.postJSON(t, "/api/v2/secrets", map[string]any{"secret": "s3cr3t"}, nil, &apiErr)
+	if resp.StatusCode != http.StatusTooManyRequests || apiErr.Error.Code != apiErrClientBanned {
+		t.Errorf("API request while banned: got status %d, code %q", resp.StatusCode, apiErr.Error.Code)
+	}
+
+	if resp, _ := ts.get(t, "/static/snappass/css/custom.css"); resp.StatusCode != http.StatusOK {
+		t.Errorf("static asset while banned: got status %d", resp.StatusCode)
+	}
+
+	metrics := ts.scrape(t)
+	for _, want := range []string{
+		`gosnappass_probe_failures_total{reason="malformed"} 4`,
+		`gosnappass_probe_failures_total{reason="not_found"} 0`,
+		"gosnappass_bans_total 1",
+		"gosnappass_banned_clients 1",
+	} {
+		if !strings.Contains(metrics, want) {
+			t.Errorf("metrics lack %q: %s", want, metrics)
+		}
+	}
+
+	testRedis.FastForward(time.Minute + time.Second)
+	if resp, _ := ts.get(t, "/"); resp.StatusCode != http.StatusOK {
+		t.Errorf("after the ban expired: got status %d", resp.StatusCode)
+	}
+}
+
+func TestProbeBansCountWrongKeys(t *testing.T) {
+	ts := newTestServer(t, WithProbeBans(ProbeBans{Threshold: 1, Window: time.Hour, Duration: time.Hour}))
+	created, other := ts.createSecret(t, "s3cr3t"), ts.createSecret(t, "other")
+
+	for i := 0; i < 2; i++ {
+		ts.postJSON(t, "/api/v2/secrets/"+created.ID+"/reveal", apiV2KeyRequest{Key: other.Key}, nil, nil)
+	}
+
+	if resp, _ := ts.get(t, "/"); resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("after wrong keys: got status %d", resp.StatusCode)
+	}
+	if metrics := ts.scrape(t); !strings.Contains(metrics, `gosnappass_probe_failures_total{reason="invalid_key"} 2`) {
+		t.Errorf("wrong keys weren't counted: %s", metrics)
+	}
+}
+
+func TestAdminLiftBan(t *testing.T) {
+	ts := newTestServer(t, WithProbeBans(ProbeBans{Threshold: 2, Window: time.Hour, Duration: time.Hour}))
+	ctx := context.Background()
+
+	ts.probe(t, 3)
+	bans, err := AdminBans(ctx, DefaultRedisKeyPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bans) != 1 || bans[0].Client != testClient || bans[0].Failures != 3 || bans[0].Expires.Sub(bans[0].Since) < 59*time.Minute {
+		t.Fatalf("got bans %+v", bans)
+	}
+
+	if err := AdminLiftBan(ctx, DefaultRedisKeyPrefix, testClient); err != nil {
+		t.Fatal(err)
+	}
+	if resp, _ := ts.get(t, "/"); resp.StatusCode != http.StatusOK {
+		t.Errorf("after lifting the ban: got status %d", resp.StatusCode)
+	}
+	if bans, err := AdminBans(ctx, DefaultRedisKeyPrefix); err != nil || len(bans) != 0 {
+		t.Errorf("after lifting the ban: got bans %+v, %v", bans, err)
+	}
+	if err := AdminLiftBan(ctx, DefaultRedisKeyPrefix, testClient); err == nil {
+		t.Error("lifted a ban twice")
+	}
+
+	// The failures before the ban were forgotten, so the client gets the whole
+	// threshold again, and is banned again when it exceeds it.
+	ts.probe(t, 2)
+	if resp, _ := ts.get(t, "/"); resp.StatusCode != http.StatusOK {
+		t.Errorf("at the threshold after lifting the ban: got status %d", resp.StatusCode)
+	}
+	ts.probe(t, 1)
+	if resp, _ := ts.get(t, "/"); resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("over the threshold after lifting the ban: got status %d", resp.StatusCode)
+	}
+}
+
+func TestMetricsAreNotPublic(t *testing.T) {
+	ts := newTestServer(t)
+	if _, body := ts.get(t, "/metrics"); strings.Contains(body, "gosnappass_") {
+		t.Errorf("the public listener serves metrics")
+	}
+}
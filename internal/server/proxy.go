@@ -0,0 +1,335 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Headers set by authenticating proxies such as oauth2-proxy, naming the user they
+// authenticated.
+const (
+	forwardedUserHeader  = "X-Forwarded-User"
+	forwardedEmailHeader = "X-Forwarded-Email"
+)
+
+var forwardedContextKey contextKey = "forwarded"
+
+// forwardedRequest is what a trusted proxy reported about a request. Empty fields
+// weren't reported.
+type forwardedRequest struct {
+	// clientIP is the address of the client that connected to the first trusted
+	// proxy.
+	clientIP string
+	// proto and host are the scheme and host the client requested.
+	proto string
+	host  string
+	// user is the user the proxy authenticated, if proxy authentication is enabled.
+	user *identity
+}
+
+// ParseNetworks parses a comma separated list of CIDRs and IP addresses, e.g.
+// "10.0.0.0/8, 192.0.2.1".
+func ParseNetworks(s string) ([]*net.IPNet, error) {
+	networks := []*net.IPNet{}
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("%q is neither a CIDR nor an IP address", entry)
+			}
+
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("%q is neither a CIDR nor an IP address", entry)
+		}
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
+
+// newForwardedMW produces a middleware that reads the client address, scheme and
+// host of requests from the Forwarded header, or else the X-Forwarded-For,
+// X-Forwarded-Proto and X-Forwarded-Host headers. With proxyAuth, the user is read
+// from X-Forwarded-User and X-Forwarded-Email. These headers are only read from
+// requests made by proxies in trusted, and ignored otherwise.
+func newForwardedMW(trusted []*net.IPNet, proxyAuth bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !inNetworks(trusted, remoteIP(r)) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// Proxies append to these headers, so values before those of trusted proxies
+			// may have been sent by the client. The scheme and host are read from the
+			// element added by the proxy the client connected to.
+			fwd := forwardedRequest{}
+			if elements := parseForwarded(r.Header.Values("Forwarded")); len(elements) > 0 {
+				hops := make([]string, len(elements))
+				for i, e := range elements {
+					hops[i] = e["for"]
+				}
+
+				if i := clientHop(trusted, hops); i >= 0 {
+					fwd.clientIP = parseNode(hops[i]).String()
+					fwd.proto, fwd.host = elements[i]["proto"], elements[i]["host"]
+				}
+			} else {
+				hops := splitHeaderList(r.Header.Values("X-Forwarded-For"))
+				if i := clientHop(trusted, hops); i >= 0 {
+					fwd.clientIP = parseNode(hops[i]).String()
+				}
+				fwd.proto = lastHeaderValue(r.Header.Values("X-Forwarded-Proto"))
+				fwd.host = lastHeaderValue(r.Header.Values("X-Forwarded-Host"))
+			}
+
+			fwd.proto = strings.ToLower(fwd.proto)
+			if fwd.proto != "http" && fwd.proto != "https" {
+				fwd.proto = ""
+			}
+			if !validHost(fwd.host) {
+				fwd.host = ""
+			}
+
+			if proxyAuth {
+				user, email := r.Header.Get(forwardedUserHeader), r.Header.Get(forwardedEmailHeader)
+				if user == "" {
+					user = email
+				}
+				// The proxy authenticated the user, and vouches for their email.
+				if user != "" {
+					fwd.user = &identity{Subject: user, Email: email, EmailVerified: email != ""}
+				}
+			}
+
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), forwardedContextKey, &fwd)))
+		})
+	}
+}
+
+// forwardedFromContext returns what a trusted proxy reported about the request with
+// ctx, or nil if it wasn't made by a trusted proxy.
+func forwardedFromContext(ctx context.Context) *forwardedRequest {
+	fwd, _ := ctx.Value(forwardedContextKey).(*forwardedRequest)
+	return fwd
+}
+
+// clientHop returns the index of the client in hops, the addresses a request was
+// forwarded for, from the client to the last proxy. Trailing hops in trusted are
+// proxies, and the hop before them is the client. It returns -1 if that hop isn't an
+// IP address.
+func clientHop(trusted []*net.IPNet, hops []string) int {
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := parseNode(hops[i])
+		if ip == nil {
+			return -1
+		}
+
+		if i == 0 || !inNetworks(trusted, ip) {
+			return i
+		}
+	}
+	return -1
+}
+
+// clientIP returns the address of the client of r, as reported by a trusted proxy,
+// or else the address the request came from.
+func clientIP(r *http.Request) string {
+	if fwd := forwardedFromContext(r.Context()); fwd != nil && fwd.clientIP != "" {
+		return fwd.clientIP
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// requestHost returns the host the client of r requested, as reported by a trusted
+// proxy, or else the Host header.
+func requestHost(r *http.Request) string {
+	if fwd := forwardedFromContext(r.Context()); fwd != nil && fwd.host != "" {
+		return fwd.host
+	}
+	return r.Host
+}
+
+// requestScheme returns the scheme of cfg's public URL, or else cfg's proto, or else
+// the scheme the client of r requested, as reported by a trusted proxy, or else the
+// scheme r arrived with. Configured schemes take precedence over reported ones, as
+// configured hosts do in secretURL.
+func (cfg routerConfig) requestScheme(r *http.Request) string {
+	if cfg.publicURL != nil {
+		return cfg.publicURL.Scheme
+	}
+
+	if cfg.proto != "" {
+		return cfg.proto
+	}
+
+	if fwd := forwardedFromContext(r.Context()); fwd != nil && fwd.proto != "" {
+		return fwd.proto
+	}
+
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// remoteIP returns the address r came from, or nil if it isn't an IP address.
+func remoteIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+// inNetworks returns true if ip is in any of networks.
+func inNetworks(networks []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+
+	for _, n := range networks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseForwarded parses the values of RFC 7239 Forwarded headers into their
+// elements, each a map of lowercased parameter names to unquoted values.
+func parseForwarded(values []string) []map[string]string {
+	elements := []map[string]string{}
+	for _, v := range values {
+		for _, element := range splitQuoted(v, ',') {
+			pairs := map[string]string{}
+			for _, pair := range splitQuoted(element, ';') {
+				name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if !ok {
+					continue
+				}
+				pairs[strings.ToLower(name)] = unquote(value)
+			}
+
+			if len(pairs) > 0 {
+				elements = append(elements, pairs)
+			}
+		}
+	}
+	return elements
+}
+
+// splitQuoted splits s at each sep outside of quoted strings.
+func splitQuoted(s string, sep byte) []string {
+	parts := []string{}
+	quoted, escaped, start := false, false, 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case escaped:
+			escaped = false
+		case quoted && c == '\\':
+			escaped = true
+		case c == '"':
+			quoted = !quoted
+		case c == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// unquote returns the value of a token or quoted string.
+func unquote(s string) string {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return s
+	}
+
+	b := strings.Builder{}
+	for i := 1; i < len(s)-1; i++ {
+		if s[i] == '\\' && i+1 < len(s)-1 {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// parseNode returns the IP address of a Forwarded node or X-Forwarded-For entry,
+// which may carry a port, and brackets around IPv6 addresses. It returns nil for
+// obfuscated and unknown nodes.
+func parseNode(node string) net.IP {
+	if host, _, err := net.SplitHostPort(node); err == nil {
+		node = host
+	}
+	return net.ParseIP(strings.Trim(node, "[]"))
+}
+
+// splitHeaderList returns the entries of comma separated header values.
+func splitHeaderList(values []string) []string {
+	entries := []string{}
+	for _, v := range values {
+		for _, entry := range strings.Split(v, ",") {
+			entries = append(entries, strings.TrimSpace(entry))
+		}
+	}
+	return entries
+}
+
+// lastHeaderValue returns the last entry of comma separated header values, which was
+// set by the proxy nearest to the server.
+func lastHeaderValue(values []string) string {
+	entries := splitHeaderList(values)
+	if len(entries) == 0 {
+		return ""
+	}
+	return entries[len(entries)-1]
+}
+
+// validHost returns true if host is a host name or IP address, optionally with a
+// port, that can be put in a link. IPv6 addresses must be bracketed.
+func validHost(host string) bool {
+	if host == "" || strings.ContainsAny(host, "/\\@?#% \t") {
+		return false
+	}
+
+	name := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		name = h
+	} else if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		name = host[1 : len(host)-1]
+	}
+
+	if name == "" || strings.ContainsAny(name, "[]") {
+		return false
+	}
+
+	// Only IPv6 addresses have colons, and they are only valid in brackets.
+	bracketed := strings.HasPrefix(host, "[")
+	if bracketed || strings.Contains(name, ":") {
+		return bracketed && strings.Contains(name, ":") && net.ParseIP(name) != nil
+	}
+	return true
+}
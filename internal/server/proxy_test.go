@@ -0,0 +1,255 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// forwarded is what a handler behind newForwardedMW made of a request.
+type forwarded struct {
+	clientIP, host, scheme, user string
+}
+
+// serveForwarded serves a request with header, made from remoteAddr, through
+// newForwardedMW trusting proxies in 10.0.0.0/8, and returns what the handler made
+// of it.
+func serveForwarded(t *testing.T, remoteAddr string, header http.Header, proxyAuth bool) forwarded {
+	t.Helper()
+	trusted, err := ParseNetworks("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := forwarded{}
+	handler := newForwardedMW(trusted, proxyAuth)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = forwarded{clientIP: clientIP(r), host: requestHost(r), scheme: routerConfig{}.requestScheme(r)}
+		if fwd := forwardedFromContext(r.Context()); fwd != nil && fwd.user != nil {
+			got.user = fwd.user.name()
+		}
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "http://snappass.example.com/", nil)
+	r.RemoteAddr = remoteAddr
+	r.Header = header
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	return got
+}
+
+func TestForwardedMW(t *testing.T) {
+	tests := []struct {
+		name       string
+		remoteAddr string
+		header     http.Header
+		want       forwarded
+	}{
+		{
+			"untrusted peer",
+			"203.0.113.7:4711",
+			http.Header{
+				"X-Forwarded-For":   {"198.51.100.1"},
+				"X-Forwarded-Host":  {"attacker.example"},
+				"X-Forwarded-Proto": {"https"},
+				"X-Forwarded-User":  {"admin"},
+			},
+			forwarded{clientIP: "203.0.113.7", host: "snappass.example.com", scheme: "http"},
+		},
+		{
+			"untrusted peer with Forwarded",
+			"203.0.113.7:4711",
+			http.Header{"Forwarded": {"for=198.51.100.1;host=attacker.example;proto=https"}},
+			forwarded{clientIP: "203.0.113.7", host: "snappass.example.com", scheme: "http"},
+		},
+		{
+			"trusted proxy",
+			"10.0.0.1:4711",
+			http.Header{
+				"X-Forwarded-For":   {"203.0.113.7"},
+				"X-Forwarded-Host":  {"snappass.example.org"},
+				"X-Forwarded-Proto": {"https"},
+			},
+			forwarded{clientIP: "203.0.113.7", host: "snappass.example.org", scheme: "https"},
+		},
+		{
+			"client prepending hops",
+			"10.0.0.1:4711",
+			http.Header{"X-Forwarded-For": {"198.51.100.1, 198.51.100.2", "203.0.113.7"}},
+			forwarded{clientIP: "203.0.113.7", host: "snappass.example.com", scheme: "http"},
+		},
+		{
+			"chain of trusted proxies",
+			"10.0.0.1:4711",
+			http.Header{"X-Forwarded-For": {"198.51.100.1, 203.0.113.7, 10.0.0.3, 10.0.0.2"}},
+			forwarded{clientIP: "203.0.113.7", host: "snappass.example.com", scheme: "http"},
+		},
+		{
+			"client behind trusted proxies only",
+			"10.0.0.1:4711",
+			http.Header{"X-Forwarded-For": {"10.0.0.3, 10.0.0.2"}},
+			forwarded{clientIP: "10.0.0.3", host: "snappass.example.com", scheme: "http"},
+		},
+		{
+			"hop before the proxies isn't an address",
+			"10.0.0.1:4711",
+			http.Header{"X-Forwarded-For": {"203.0.113.7, unknown"}},
+			forwarded{clientIP: "10.0.0.1", host: "snappass.example.com", scheme: "http"},
+		},
+		{
+			"Forwarded chain with a prepended element",
+			"10.0.0.1:4711",
+			http.Header{"Forwarded": {
+				`for=198.51.100.1;host=attacker.example;proto=http, for="[2001:db8::7]:4711";host=snappass.example.org;proto=HTTPS`,
+				"for=10.0.0.2;host=internal.example",
+			}},
+			forwarded{clientIP: "2001:db8::7", host: "snappass.example.org", scheme: "https"},
+		},
+		{
+			"Forwarded with quoted separators",
+			"10.0.0.1:4711",
+			http.Header{"Forwarded": {`for="198.51.100.1,for=203.0.113.9";host="a;b", for="203.0.113.7";host="snappass.example.org"`}},
+			forwarded{clientIP: "203.0.113.7", host: "snappass.example.org", scheme: "http"},
+		},
+		{
+			"Forwarded takes precedence",
+			"10.0.0.1:4711",
+			http.Header{"Forwarded": {"for=203.0.113.7"}, "X-Forwarded-For": {"198.51.100.1"}, "X-Forwarded-Host": {"attacker.example"}},
+			forwarded{clientIP: "203.0.113.7", host: "snappass.example.com", scheme: "http"},
+		},
+		{
+			"obfuscated Forwarded node",
+			"10.0.0.1:4711",
+			http.Header{"Forwarded": {"for=_hidden;host=snappass.example.org"}},
+			forwarded{clientIP: "10.0.0.1", host: "snappass.example.com", scheme: "http"},
+		},
+		{
+			"unknown scheme",
+			"10.0.0.1:4711",
+			http.Header{"X-Forwarded-Proto": {"javascript"}},
+			forwarded{clientIP: "10.0.0.1", host: "snappass.example.com", scheme: "http"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := serveForwarded(t, tt.remoteAddr, tt.header, false); got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestForwardedMWRejectsInvalidHosts(t *testing.T) {
+	quote := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	for _, host := range []string{
+		"attacker.example@snappass.example.com",
+		"attacker.example/snappass.example.com",
+		"snappass.example.com%2F@attacker.example",
+		"attacker.example\\snappass.example.com",
+		"snappass.example.com?attacker.example",
+		"snappass.example.com#attacker.example",
+		"snappass example.com",
+		"[2001:db8::1",
+		"2001:db8::1",
+		"[snappass.example.com]",
+		":443",
+	} {
+		t.Run(host, func(t *testing.T) {
+			for name, header := range map[string]http.Header{
+				"X-Forwarded-Host": {"X-Forwarded-Host": {host}},
+				"Forwarded":        {"Forwarded": {`for=203.0.113.7;host="` + quote.Replace(host) + `"`}},
+			} {
+				if got := serveForwarded(t, "10.0.0.1:4711", header, false); got.host != "snappass.example.com" {
+					t.Errorf("%s: got host %q", name, got.host)
+				}
+			}
+		})
+	}
+
+	for _, host := range []string{"snappass.example.org", "snappass.example.org:8443", "[2001:db8::1]:8443", "[2001:db8::1]", "192.0.2.1"} {
+		if !validHost(host) {
+			t.Errorf("validHost(%q) = false", host)
+		}
+	}
+}
+
+func TestForwardedMWProxyAuth(t *testing.T) {
+	header := func() http.Header {
+		return http.Header{"X-Forwarded-User": {"alice"}, "X-Forwarded-Email": {"alice@corp.example"}}
+	}
+
+	if got := serveForwarded(t, "10.0.0.1:4711", header(), true); got.user != "alice@corp.example" {
+		t.Errorf("from a trusted proxy: got user %q", got.user)
+	}
+	if got := serveForwarded(t, "203.0.113.7:4711", header(), true); got.user != "" {
+		t.Errorf("from an untrusted peer: got user %q", got.user)
+	}
+	if got := serveForwarded(t, "10.0.0.1:4711", header(), false); got.user != "" {
+		t.Errorf("without proxy auth: got user %q", got.user)
+	}
+}
+
+func TestSplitQuoted(t *testing.T) {
+	tests := []struct {
+		s    string
+		want []string
+	}{
+		{"a,b", []string{"a", "b"}},
+		{`a="x,y",b`, []string{`a="x,y"`, "b"}},
+		{`a="x\",y",b`, []string{`a="x\",y"`, "b"}},
+		{`a="unterminated,b`, []string{`a="unterminated,b`}},
+		{"", []string{""}},
+	}
+
+	for _, tt := range tests {
+		if got := splitQuoted(tt.s, ','); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitQuoted(%q) = %q, want %q", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestParseForwarded(t *testing.T) {
+	got := parseForwarded([]string{`For="[2001:db8::7]:4711";Proto=https, for=192.0.2.1;host="snappass.example.org"`, "junk"})
+	want := []map[string]string{
+		{"for": "[2001:db8::7]:4711", "proto": "https"},
+		{"for": "192.0.2.1", "host": "snappass.example.org"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestSchemeAndHostPrecedence(t *testing.T) {
+	trusted, err := ParseNetworks("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		cfg  routerConfig
+		want string
+	}{
+		{"forwarded", routerConfig{}, "http://snappass.example.org/token"},
+		{"configured", routerConfig{proto: "https", hostOverride: "snappass.example.com"}, "https://snappass.example.com/token"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			link := ""
+			handler := newForwardedMW(trusted, false)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				link = tt.cfg.secretURL(r, "token")
+			}))
+
+			r := httptest.NewRequest(http.MethodGet, "http://10.0.0.5/", nil)
+			r.RemoteAddr = "10.0.0.1:4711"
+			r.Header.Set("Forwarded", "for=203.0.113.7;proto=http;host=snappass.example.org")
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+
+			if link != tt.want {
+				t.Errorf("got link %q, want %q", link, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,223 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/concerthall/gosnappass/internal/view"
+	"github.com/go-redis/redis/v8"
+	"golang.org/x/exp/slog"
+)
+
+// Actions with separate rate limit budgets.
+const (
+	rateLimitCreate  = "create"
+	rateLimitPreview = "preview"
+	rateLimitReveal  = "reveal"
+)
+
+// RateLimit allows a client Requests requests per Window. A zero RateLimit allows
+// every request.
+type RateLimit struct {
+	Requests int
+	Window   time.Duration
+}
+
+// ParseRateLimit parses a rate limit of the form "<requests>/<window>", e.g. "30/1m".
+// "off" allows every request.
+func ParseRateLimit(s string) (RateLimit, error) {
+	if s == "off" {
+		return RateLimit{}, nil
+	}
+
+	requests, window, ok := strings.Cut(s, "/")
+	n, err := strconv.Atoi(requests)
+	if !ok || err != nil || n <= 0 {
+		return RateLimit{}, fmt.Errorf("rate limits must be of the form <requests>/<window> or off, got %q", s)
+	}
+
+	d, err := time.ParseDuration(window)
+	if err != nil || d < time.Second {
+		return RateLimit{}, fmt.Errorf("rate limit windows must be durations of at least 1s, got %q", window)
+	}
+
+	return RateLimit{Requests: n, Window: d}, nil
+}
+
+// RateLimits are the budgets of each client. API clients are limited by their
+// credential, and everyone else by their IP address.
+type RateLimits struct {
+	// Create limits creating secrets.
+	Create RateLimit
+	// Preview limits the pages and API requests that describe a secret without
+	// revealing it.
+	Preview RateLimit
+	// Reveal limits revealing and deleting secrets.
+	Reveal RateLimit
+}
+
+// DefaultRateLimits returns the limits of a server unless WithRateLimits sets others.
+func DefaultRateLimits() RateLimits {
+	return RateLimits{
+		Create:  RateLimit{Requests: 30, Window: time.Minute},
+		Preview: RateLimit{Requests: 120, Window: time.Minute},
+		Reveal:  RateLimit{Requests: 60, Window: time.Minute},
+	}
+}
+
+// rateLimiter counts the requests of each client in fixed windows. Counters are kept
+// in the database so that replicas share them. When the database fails, counters
+// are kept in memory, and each replica limits clients on its own.
+type rateLimiter struct {
+	limits    RateLimits
+	keyPrefix string
+
+	mu        sync.Mutex
+	memory    map[string]*memoryCounter
+	lastPrune time.Time
+}
+
+type memoryCounter struct {
+	count int
+	end   time.Time
+}
+
+func newRateLimiter(limits RateLimits, keyPrefix string) *rateLimiter {
+	return &rateLimiter{limits: limits, keyPrefix: keyPrefix, memory: map[string]*memoryCounter{}}
+}
+
+// limit returns the budget of action.
+func (rl *rateLimiter) limit(action string) RateLimit {
+	switch action {
+	case rateLimitCreate:
+		return rl.limits.Create
+	case rateLimitPreview:
+		return rl.limits.Preview
+	case rateLimitReveal:
+		return rl.limits.Reveal
+	}
+	return RateLimit{}
+}
+
+// take counts a request of client for action. If the client exhausted its budget,
+// it returns false and how long until the budget is renewed.
+func (rl *rateLimiter) take(ctx context.Context, action, client string) (bool, time.Duration) {
+	limit := rl.limit(action)
+	if limit.Requests == 0 {
+		return true, 0
+	}
+
+	now := time.Now()
+	start := now.Truncate(limit.Window)
+	end := start.Add(limit.Window)
+	key := auxKey(rl.keyPrefix, "ratelimit", action+":"+client+":"+strconv.FormatInt(start.Unix(), 10))
+
+	var incr *redis.IntCmd
+	_, err := RedisClient().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		incr = pipe.Incr(ctx, key)
+		pipe.PExpireAt(ctx, key, end)
+		return nil
+	})
+
+	count := int(incr.Val())
+	if err != nil {
+		slog.FromContext(ctx).Error("unable to count request in the database, counting it in memory", err)
+		count = rl.takeMemory(key, now, end)
+	}
+
+	return count <= limit.Requests, end.Sub(now)
+}
+
+// takeMemory counts a request in the in-memory counter at key, which ends at end.
+func (rl *rateLimiter) takeMemory(key string, now, end time.Time) int {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if now.Sub(rl.lastPrune) > time.Minute {
+		for k, c := range rl.memory {
+			if !now.Before(c.end) {
+				delete(rl.memory, k)
+			}
+		}
+		rl.lastPrune = now
+	}
+
+	c, ok := rl.memory[key]
+	if !ok {
+		c = &memoryCounter{end: end}
+		rl.memory[key] = c
+	}
+	c.count++
+	return c.count
+}
+
+// rateLimit wraps next, the handler of a browser page, so that clients that exhausted
+// their budget for action are shown a page asking them to wait.
+func (cfg routerConfig) rateLimit(action string, next http.HandlerFunc) http.HandlerFunc {
+	return cfg.limitRequests(action, next, func(w http.ResponseWriter, retryAfter time.Duration) error {
+		w.WriteHeader(http.StatusTooManyRequests)
+		return view.RateLimited(w, retryAfter)
+	})
+}
+
+// rateLimitAPI wraps next, the handler of an API endpoint, so that clients that
+// exhausted their budget for action get an API error.
+func (cfg routerConfig) rateLimitAPI(action string, next http.HandlerFunc) http.HandlerFunc {
+	return cfg.limitRequests(action, next, func(w http.ResponseWriter, retryAfter time.Duration) error {
+		writeAPIError(w, http.StatusTooManyRequests, apiErrRateLimited, "too many requests, retry after "+retryAfter.String())
+		return nil
+	})
+}
+
+// limitRequests wraps next so that clients that exhausted their budget for action
+// get a 429 response, whose status and body are written by reject.
+func (cfg routerConfig) limitRequests(action string, next http.HandlerFunc, reject func(w http.ResponseWriter, retryAfter time.Duration) error) http.HandlerFunc {
+	if cfg.rateLimiter == nil || cfg.rateLimiter.limit(action).Requests == 0 {
+		return next
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		client := rateLimitClient(r)
+		ok, retryAfter := cfg.rateLimiter.take(r.Context(), action, client)
+		if ok {
+			next(w, r)
+			return
+		}
+
+		logger := slog.FromContext(r.Context())
+		logger.Info("rate limited request", "action", action, "client", client)
+
+		// Retry-After is in whole seconds, rounded up so that retrying then succeeds.
+		retryAfter = (retryAfter + time.Second - 1).Truncate(time.Second)
+		w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter/time.Second)))
+		if err := reject(w, retryAfter); err != nil {
+			logger.Error("unable to render view RateLimited", err)
+		}
+	}
+}
+
+// rateLimitClient identifies the client of r for rate limiting: its API credential,
+// or else its IP address, as reported by a trusted proxy. IPv6 clients are
+// identified by their /64 network, which is commonly assigned to a single client.
+func rateLimitClient(r *http.Request) string {
+	if p := principalFromContext(r.Context()); p != nil {
+		return p.String()
+	}
+
+	host := clientIP(r)
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return "ip:" + host
+	}
+
+	if ip.To4() == nil {
+		ip = ip.Mask(net.CIDRMask(64, 128))
+	}
+	return "ip:" + ip.String()
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimits(t *testing.T) {
+	limits := DefaultRateLimits()
+	limits.Create = RateLimit{Requests: 1, Window: time.Minute}
+	ts := newTestServer(t, WithRateLimits(limits))
+
+	ts.createSecret(t, "first")
+
+	resp, body := ts.do(t, http.MethodPost, "/api/v2/secrets", strings.NewReader(`{"secret": "second"}`), http.Header{"Content-Type": {"application/json"}})
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("over the limit: got status %d", resp.StatusCode)
+	}
+
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("got Content-Type %q, want application/json", ct)
+	}
+
+	apiErr := apiV2Error{}
+	if err := json.Unmarshal([]byte(body), &apiErr); err != nil || apiErr.Error.Code != apiErrRateLimited {
+		t.Errorf("got body %q, want a rate_limited error", body)
+	}
+
+	if retryAfter := resp.Header.Get("Retry-After"); retryAfter == "" || retryAfter == "0" {
+		t.Errorf("got Retry-After %q", retryAfter)
+	}
+
+	// Pages share the budget of the client, and explain the wait.
+	resp, body = ts.submitForm(t, "/", nil)
+	if resp.StatusCode != http.StatusTooManyRequests || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		t.Errorf("page over the limit: got status %d, Content-Type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	if strings.Contains(body, "rate_limited") {
+		t.Errorf("page over the limit got an API error")
+	}
+}
@@ -1,8 +1,11 @@
 package server
 
 import (
+	"net"
 	"net/http"
+	"net/url"
 
+	"github.com/concerthall/gosnappass/internal/apitoken"
 	"github.com/concerthall/gosnappass/internal/embedded"
 	"github.com/gorilla/mux"
 	"golang.org/x/exp/slog"
@@ -29,19 +32,58 @@ func router(logger *slog.Logger, cfg routerConfig) *mux.Router {
 		_, _ = w.Write(embedded.Favicon)
 	})
 
-	// Register all other handlers.
-	m.HandleFunc("/{token}", showConfirmationHandler).Methods(http.MethodGet)
-	m.HandleFunc("/{token}", getPasswordHandler).Methods(http.MethodPost)
-	m.HandleFunc("/", indexHandler).Methods(http.MethodGet)
-	m.HandleFunc("/", newSetPasswordHandler(cfg.proto, cfg.hostOverride, cfg.redisKeyPrefix, cfg.pathPrefix)).Methods(http.MethodPost)
+	// Login is only available with an identity provider.
+	if cfg.oidc != nil {
+		m.HandleFunc("/auth/login", newLoginHandler(cfg)).Methods(http.MethodGet)
+		m.HandleFunc("/auth/callback", newCallbackHandler(cfg)).Methods(http.MethodGet)
+		m.HandleFunc("/auth/logout", cfg.csrfProtect(newLogoutHandler(cfg))).Methods(http.MethodPost)
+	}
+
+	// The specification and documentation of the APIs.
+	m.HandleFunc("/api/openapi.json", openAPISpecHandler).Methods(http.MethodGet)
+	m.HandleFunc("/api/docs", apiDocsHandler).Methods(http.MethodGet)
+
+	// The snappass compatible API.
+	m.HandleFunc("/api/set_password/", cfg.rateLimitAPI(rateLimitCreate, cfg.requireScope(apitoken.ScopeCreate, cfg.requireCreatorAPI(newSnappassSetPasswordHandler(cfg))))).Methods(http.MethodPost)
+
+	// The v2 API.
+	m.HandleFunc("/api/v2/secrets", cfg.rateLimitAPI(rateLimitCreate, cfg.requireScope(apitoken.ScopeCreate, cfg.requireCreatorAPI(newAPIV2CreateHandler(cfg))))).Methods(http.MethodPost)
+	m.HandleFunc("/api/v2/secrets/{id}", cfg.rateLimitAPI(rateLimitPreview, cfg.requireScope(apitoken.ScopeReveal, newAPIV2StatusHandler(cfg)))).Methods(http.MethodGet)
+	m.HandleFunc("/api/v2/secrets/{id}", cfg.rateLimitAPI(rateLimitReveal, cfg.requireScope(apitoken.ScopeReveal, newAPIV2DeleteHandler(cfg)))).Methods(http.MethodDelete)
+	m.HandleFunc("/api/v2/secrets/{id}/reveal", cfg.rateLimitAPI(rateLimitReveal, cfg.requireScope(apitoken.ScopeReveal, newAPIV2RevealHandler(cfg)))).Methods(http.MethodPost)
+
+	// Register all other handlers. These serve browsers, and need CSRF protection.
+	m.HandleFunc("/{token}", cfg.rateLimit(rateLimitPreview, cfg.csrfProtect(newShowConfirmationHandler(cfg)))).Methods(http.MethodGet)
+	m.HandleFunc("/{token}", cfg.rateLimit(rateLimitReveal, cfg.csrfProtect(newGetPasswordHandler(cfg)))).Methods(http.MethodPost)
+	m.HandleFunc("/", cfg.csrfProtect(cfg.requireCreator(newIndexHandler(cfg)))).Methods(http.MethodGet)
+	m.HandleFunc("/", cfg.rateLimit(rateLimitCreate, cfg.csrfProtect(cfg.requireCreator(newSetPasswordHandler(cfg))))).Methods(http.MethodPost)
 
 	m.Use(
 		addRequestIDMW,
+		newForwardedMW(cfg.trustedProxies, cfg.proxyAuth),
 		newInjectLoggerMW(logger),
 		logRequestMW,
-		newDatabasePingMW(RedisIsAlive),
+		newSecurityHeadersMW(cfg.securityHeaders, cfg.requestScheme),
 	)
 
+	// Requests for other hosts are rejected before anything else is done with them.
+	if len(cfg.allowedHosts) > 0 {
+		m.Use(newAllowedHostsMW(cfg.allowedHosts))
+	}
+
+	m.Use(newDatabasePingMW(RedisIsAlive))
+
+	// API clients only need to authenticate when authenticators are configured.
+	if len(cfg.apiAuthenticators) > 0 {
+		m.Use(newAPIAuthMW(cfg.apiAuthenticators))
+	}
+
+	// Bans apply to API clients by their credential, so they are checked after
+	// authentication.
+	if cfg.probes.bans.Threshold > 0 {
+		m.Use(newProbeBanMW(cfg.probes))
+	}
+
 	return m
 }
 
@@ -50,4 +92,49 @@ type routerConfig struct {
 	proto          string
 	pathPrefix     string
 	redisKeyPrefix string
+	labelMaxLength int
+	disableQRCode  bool
+	linkStyle      string
+	oidc           *OIDCProvider
+	sessions       *sessionManager
+	// creators limits creating secrets to users logged in with oidc. Everyone may
+	// create secrets if it is nil.
+	creators *CreatorPolicy
+	// trustedProxies are the networks of proxies whose forwarded headers are read.
+	// With proxyAuth, they authenticate users too.
+	trustedProxies []*net.IPNet
+	proxyAuth      bool
+	// publicURL is the URL the server is reached at, if it is configured. Its scheme,
+	// host and path are also set as proto, hostOverride and pathPrefix.
+	publicURL *url.URL
+	// allowedHosts are the hosts requests may be made for. Every host is allowed if
+	// it is empty.
+	allowedHosts []string
+	// securityHeaders are set on every response.
+	securityHeaders SecurityHeaders
+	// rateLimiter limits the requests of each client. Requests aren't limited if it
+	// is nil.
+	rateLimiter *rateLimiter
+	// probes counts the failures of each client to look up secrets, and bans clients
+	// that are likely guessing tokens.
+	probes  *probeTracker
+	metrics *metrics
+	// apiAuthenticators authenticate API clients. The API is open to everyone when
+	// there are none.
+	apiAuthenticators []apiAuthenticator
+	// clientCertRequired rejects API requests without a verified client certificate.
+	clientCertRequired bool
+}
+
+// identity returns the user of r authenticated by a trusted proxy, or else the
+// logged in user of r, or nil if there isn't one.
+func (cfg routerConfig) identity(r *http.Request) *identity {
+	if fwd := forwardedFromContext(r.Context()); fwd != nil && fwd.user != nil {
+		return fwd.user
+	}
+
+	if cfg.sessions == nil {
+		return nil
+	}
+	return cfg.sessions.identity(r)
 }
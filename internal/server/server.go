@@ -1,20 +1,33 @@
 package server
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 
 	// TODO: mux is deprecated, but until we find something else
 	// we'll use it.
 
+	"github.com/concerthall/gosnappass/internal/apitoken"
 	"github.com/gorilla/mux"
 	"golang.org/x/exp/slog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 const (
-	defaultListenAddress = ":5000"
+	defaultListenAddress  = ":5000"
+	defaultLabelMaxLength = 64
+
+	// DefaultRedisKeyPrefix begins the database keys of the server unless
+	// WithRedisKeyPrefix sets another prefix.
+	DefaultRedisKeyPrefix = "snappass"
 )
 
 type Server struct {
@@ -26,6 +39,47 @@ type Server struct {
 	hostOverride   string
 	proto          string
 	redisKeyPrefix string
+	labelMaxLength int
+	disableQRCode  bool
+	linkStyle      string
+	oidc           *OIDCProvider
+	sessionSecret  []byte
+	creators       *CreatorPolicy
+	trustedProxies []*net.IPNet
+	proxyAuth      bool
+	// publicURL is the URL the server is reached at. Links are always built from it,
+	// if it is set.
+	publicURL    *url.URL
+	allowedHosts []string
+
+	// tlsCerts enables serving https with the certificate it loads, if set.
+	tlsCerts        *CertReloader
+	tlsMinVersion   uint16
+	tlsCipherSuites []uint16
+	// httpRedirectAddress is where plain HTTP requests are redirected to https. No
+	// such listener runs if it is empty.
+	httpRedirectAddress string
+	// done is closed on Shutdown.
+	done chan struct{}
+
+	// grpcListenAddress is where the gRPC service listens. The service is disabled
+	// if it is empty.
+	grpcListenAddress string
+	grpcTLS           *tls.Config
+	grpcServer        *grpc.Server
+
+	// metricsListenAddress is where metrics are served. They aren't served if it is
+	// empty.
+	metricsListenAddress string
+	metrics              *metrics
+
+	apiAuthenticators []apiAuthenticator
+	// clientCAs sign the client certificates API clients may authenticate with.
+	clientCAs          *x509.CertPool
+	clientCertRequired bool
+	securityHeaders    SecurityHeaders
+	rateLimits         RateLimits
+	probeBans          ProbeBans
 }
 
 type ServerOption = func(*Server)
@@ -33,47 +87,220 @@ type ServerOption = func(*Server)
 // New returns a server with the provided opts, if any.
 func New(opts ...ServerOption) *Server {
 	s := Server{
-		listenAddress:  defaultListenAddress,
-		logHandler:     slog.NewJSONHandler(os.Stdout),
-		redisKeyPrefix: "snappass",
+		listenAddress:   defaultListenAddress,
+		logHandler:      slog.NewJSONHandler(os.Stdout),
+		redisKeyPrefix:  DefaultRedisKeyPrefix,
+		labelMaxLength:  defaultLabelMaxLength,
+		linkStyle:       LinkStyleLong,
+		securityHeaders: DefaultSecurityHeaders(),
+		rateLimits:      DefaultRateLimits(),
+		probeBans:       DefaultProbeBans(),
+		tlsMinVersion:   tls.VersionTLS12,
+		done:            make(chan struct{}),
 	}
 
 	for _, opt := range opts {
 		opt(&s)
 	}
 
+	// Links are served over https, unless configured otherwise.
+	if s.tlsCerts != nil && s.proto == "" {
+		s.proto = "https"
+	}
+
 	// Add the Logger
 	s.logger = slog.New(s.logHandler)
-	s.router = router(s.logger, routerConfig{
+
+	// Without a configured secret, CSRF cookies and sessions are signed with a random
+	// key, and do not survive restarts or span replicas. Forms then fail on other
+	// replicas, so deployments of more than one replica must configure it.
+	if len(s.sessionSecret) == 0 {
+		key, err := randomBytes(32)
+		if err != nil {
+			panic(err)
+		}
+		s.sessionSecret = key
+		s.logger.Warn("no session secret configured, forms and logins will not survive restarts or span replicas")
+	}
+
+	cookiePath := s.pathPrefix
+	if cookiePath == "" {
+		cookiePath = "/"
+	}
+
+	// The public host is always allowed.
+	if len(s.allowedHosts) > 0 && s.publicURL != nil {
+		s.allowedHosts = append(s.allowedHosts, s.publicURL.Host)
+	}
+
+	s.metrics = newMetrics(s.redisKeyPrefix)
+
+	cfg := routerConfig{
 		hostOverride:   s.hostOverride,
 		proto:          s.proto,
 		pathPrefix:     s.pathPrefix,
 		redisKeyPrefix: s.redisKeyPrefix,
-	})
+		labelMaxLength: s.labelMaxLength,
+		disableQRCode:  s.disableQRCode,
+		linkStyle:      s.linkStyle,
+		oidc:           s.oidc,
+		creators:       s.creators,
+		trustedProxies: s.trustedProxies,
+		proxyAuth:      s.proxyAuth,
+		publicURL:      s.publicURL,
+		allowedHosts:   s.allowedHosts,
+		sessions: &sessionManager{
+			key:    s.sessionSecret,
+			path:   cookiePath,
+			secure: s.proto == "https",
+		},
+		apiAuthenticators:  s.apiAuthenticators,
+		clientCertRequired: s.clientCertRequired,
+		securityHeaders:    s.securityHeaders,
+		rateLimiter:        newRateLimiter(s.rateLimits, s.redisKeyPrefix),
+		probes:             newProbeTracker(s.probeBans, s.redisKeyPrefix, s.metrics),
+		metrics:            s.metrics,
+	}
+
+	s.router = router(s.logger, cfg)
+	if s.grpcListenAddress != "" {
+		s.grpcServer = newGRPCServer(s.logger, credentials.NewTLS(s.grpcTLS), cfg)
+	}
 	return &s
 }
 
+// Run serves the server until it fails. It refuses to start unless links are built
+// from a configured host, see WithPublicURL, WithAllowedHosts and WithHostOverride.
 func (srv *Server) Run() error {
+	// Links must never be built from a host chosen by the client.
+	if srv.publicURL == nil && srv.hostOverride == "" && len(srv.allowedHosts) == 0 {
+		return errors.New("links would be built from the Host of requests, which clients choose; set a public URL, allowed hosts or a host override")
+	}
+
 	// Check that Redis is running before we start.
 	if err := RedisIsAlive(); err != nil {
 		return fmt.Errorf("unable to talk to the database: %s", err)
 	}
 
+	publicURL := ""
+	if srv.publicURL != nil {
+		publicURL = srv.publicURL.String()
+	}
+
 	srv.logger.Info("starting server",
 		"listenAddress", srv.listenAddress,
+		"publicURL", publicURL,
 		"pathPrefix", srv.pathPrefix,
 		"proto", srv.proto,
 		"hostOverride", srv.hostOverride,
+		"linkStyle", srv.linkStyle,
+		"grpcListenAddress", srv.grpcListenAddress,
+		"tls", srv.tlsCerts != nil,
+		"httpRedirectAddress", srv.httpRedirectAddress,
+		"metricsListenAddress", srv.metricsListenAddress,
 	)
 
-	return http.ListenAndServe(
-		srv.listenAddress,
-		srv.router,
-	)
+	errs := make(chan error, 4)
+	if srv.grpcServer != nil {
+		go func() { errs <- srv.serveGRPC() }()
+	}
+
+	if srv.metricsListenAddress != "" {
+		go func() {
+			errs <- http.ListenAndServe(
+				srv.metricsListenAddress,
+				srv.MetricsHandler(),
+			)
+		}()
+	}
+
+	if srv.tlsCerts == nil {
+		if srv.clientCAs != nil {
+			srv.logger.Warn("client certificates are only requested when serving TLS")
+		}
+
+		go func() {
+			errs <- http.ListenAndServe(
+				srv.listenAddress,
+				srv.router,
+			)
+		}()
+		return <-errs
+	}
+
+	cert := srv.tlsCerts.Certificate()
+	srv.logger.Info("serving TLS certificate", "subject", cert.Subject.String(), "notAfter", cert.NotAfter)
+	go srv.tlsCerts.watch(srv.logger, certReloadInterval, srv.done)
+
+	httpServer := &http.Server{
+		Addr:      srv.listenAddress,
+		Handler:   srv.router,
+		TLSConfig: servingTLSConfig(srv.tlsCerts, srv.tlsMinVersion, srv.tlsCipherSuites, srv.clientCAs),
+	}
+	go func() { errs <- httpServer.ListenAndServeTLS("", "") }()
+
+	if srv.httpRedirectAddress != "" {
+		go func() {
+			errs <- http.ListenAndServe(
+				srv.httpRedirectAddress,
+				newHTTPSRedirectHandler(srv.publicURL, srv.allowedHosts, srv.listenAddress),
+			)
+		}()
+	}
+
+	return <-errs
+}
+
+// Handler returns the HTTP handler of the server, to serve it with a listener of
+// your own, e.g. in tests. Run serves it on its own.
+func (srv *Server) Handler() http.Handler {
+	return srv.router
+}
+
+// MetricsHandler returns the handler serving Prometheus metrics at /metrics. Run
+// serves it on the address set by WithMetrics.
+func (srv *Server) MetricsHandler() http.Handler {
+	m := http.NewServeMux()
+	m.Handle("/metrics", srv.metrics.handler())
+	return m
+}
+
+// ReloadCertificates loads the TLS certificate and key again, e.g. after they were
+// renewed. The previous certificate is still served if they don't load. It does
+// nothing if the server doesn't serve TLS.
+func (srv *Server) ReloadCertificates() error {
+	if srv.tlsCerts == nil {
+		return nil
+	}
+
+	if err := srv.tlsCerts.Reload(); err != nil {
+		srv.logger.Error("unable to reload TLS certificate, serving the previous one", err)
+		return err
+	}
+
+	cert := srv.tlsCerts.Certificate()
+	srv.logger.Info("reloaded TLS certificate", "subject", cert.Subject.String(), "notAfter", cert.NotAfter)
+	return nil
+}
+
+// serveGRPC serves the gRPC service until it is stopped.
+func (srv *Server) serveGRPC() error {
+	lis, err := net.Listen("tcp", srv.grpcListenAddress)
+	if err != nil {
+		return fmt.Errorf("unable to listen for gRPC: %w", err)
+	}
+
+	return srv.grpcServer.Serve(lis)
 }
 
 // Shutdown executes shutdown logic for the server instance.
 func (srv *Server) Shutdown() error {
+	close(srv.done)
+
+	if srv.grpcServer != nil {
+		srv.grpcServer.GracefulStop()
+	}
+
 	return RedisCloseConnections()
 }
 
@@ -83,6 +310,168 @@ func WithRedisKeyPrefix(prefix string) ServerOption {
 	return func(s *Server) { s.redisKeyPrefix = prefix }
 }
 
+// WithLabelMaxLength sets the maximum length, in characters, of the label a sender
+// may attach to a secret.
+func WithLabelMaxLength(length int) ServerOption {
+	return func(s *Server) { s.labelMaxLength = length }
+}
+
+// WithoutQRCode removes the QR code of the link from the page shown after a secret
+// is stored.
+func WithoutQRCode() ServerOption {
+	return func(s *Server) { s.disableQRCode = true }
+}
+
+// WithLinkStyle sets the style of links produced for new secrets to one of
+// LinkStyleLong, LinkStyleShort or LinkStyleWords. Links of every style are
+// accepted regardless of this setting.
+func WithLinkStyle(style string) ServerOption {
+	return func(s *Server) { s.linkStyle = style }
+}
+
+// WithOIDCProvider lets users log in with provider. Logging in is required to reveal
+// secrets restricted to specific users or groups.
+func WithOIDCProvider(provider *OIDCProvider) ServerOption {
+	return func(s *Server) { s.oidc = provider }
+}
+
+// WithCreatorLogin requires users to log in with the provider set by
+// WithOIDCProvider, or through a proxy trusted by WithProxyAuth, to create secrets,
+// and only allows those matching policy. API clients authenticated by a credential
+// are allowed by its scopes instead. Revealing secrets doesn't require logging in.
+func WithCreatorLogin(policy CreatorPolicy) ServerOption {
+	return func(s *Server) { s.creators = &policy }
+}
+
+// WithPublicURL builds every link from u, the URL the server is reached at, see
+// ParsePublicURL. It replaces WithProto, WithHostOverride and WithPathPrefix.
+func WithPublicURL(u *url.URL) ServerOption {
+	return func(s *Server) {
+		s.publicURL = u
+		s.proto = u.Scheme
+		s.hostOverride = u.Host
+		s.pathPrefix = u.Path
+	}
+}
+
+// WithAllowedHosts rejects requests for hosts other than hosts, with or without a
+// port, and the host of the public URL.
+func WithAllowedHosts(hosts []string) ServerOption {
+	return func(s *Server) { s.allowedHosts = hosts }
+}
+
+// WithTrustedProxies reads the client address, scheme and host of requests made by
+// proxies in networks from the Forwarded or X-Forwarded-* headers they set. Links
+// are built from the scheme and host the client requested, unless WithProto or
+// WithHostOverride set them.
+func WithTrustedProxies(networks []*net.IPNet) ServerOption {
+	return func(s *Server) { s.trustedProxies = networks }
+}
+
+// WithProxyAuth treats users named by the X-Forwarded-User and X-Forwarded-Email
+// headers of requests made by trusted proxies as logged in. The proxies must
+// authenticate every request, and remove these headers from the requests of
+// clients.
+func WithProxyAuth() ServerOption {
+	return func(s *Server) { s.proxyAuth = true }
+}
+
+// WithTLS serves https with the certificate of certs, which is reloaded when its
+// files change, or on ReloadCertificates. Links are built with https unless
+// WithProto or WithPublicURL say otherwise.
+func WithTLS(certs *CertReloader) ServerOption {
+	return func(s *Server) { s.tlsCerts = certs }
+}
+
+// WithTLSMinVersion sets the lowest TLS version negotiated, which is TLS 1.2 unless
+// set.
+func WithTLSMinVersion(version uint16) ServerOption {
+	return func(s *Server) { s.tlsMinVersion = version }
+}
+
+// WithTLSCipherSuites limits the cipher suites negotiated with TLS 1.2 and earlier to
+// suites, see ParseCipherSuites. Those of TLS 1.3 aren't configurable.
+func WithTLSCipherSuites(suites []uint16) ServerOption {
+	return func(s *Server) { s.tlsCipherSuites = suites }
+}
+
+// WithHTTPRedirect listens for plain HTTP on address, next to the https server set up
+// by WithTLS, and redirects every request to https.
+func WithHTTPRedirect(address string) ServerOption {
+	return func(s *Server) { s.httpRedirectAddress = address }
+}
+
+// WithSessionSecret sets the key used to sign CSRF and session cookies. All replicas
+// of a deployment must share the same secret, or else forms submitted to another
+// replica than the one that served them are rejected.
+func WithSessionSecret(secret string) ServerOption {
+	return func(s *Server) { s.sessionSecret = []byte(secret) }
+}
+
+// WithGRPC serves the gRPC SecretService on address, next to the HTTP server. Clients
+// authenticate with the certificates required by tlsConfig, see MutualTLSConfig.
+func WithGRPC(address string, tlsConfig *tls.Config) ServerOption {
+	return func(s *Server) {
+		s.grpcListenAddress = address
+		s.grpcTLS = tlsConfig
+	}
+}
+
+// WithAPITokens requires API clients to authenticate with a bearer token from store,
+// granted the scope of the endpoint they call.
+func WithAPITokens(store *apitoken.Store) ServerOption {
+	return func(s *Server) {
+		s.apiAuthenticators = append(s.apiAuthenticators, apiTokenAuthenticator{store: store})
+	}
+}
+
+// WithJWTAuthenticator requires API clients to authenticate with a bearer token,
+// and accepts JWTs verified by authenticator. The scopes of a JWT are granted by the
+// rules of its issuer.
+func WithJWTAuthenticator(authenticator *JWTAuthenticator) ServerOption {
+	return func(s *Server) {
+		s.apiAuthenticators = append(s.apiAuthenticators, authenticator)
+	}
+}
+
+// WithClientCertAuthenticator authenticates API clients with the client
+// certificates accepted by authenticator. Certificates are only requested when
+// serving TLS with WithTLS.
+func WithClientCertAuthenticator(authenticator *ClientCertAuthenticator) ServerOption {
+	return func(s *Server) {
+		s.apiAuthenticators = append(s.apiAuthenticators, authenticator)
+		s.clientCAs = authenticator.clientCAs
+	}
+}
+
+// WithClientCertRequired rejects API requests made without a client certificate
+// accepted by the authenticator set by WithClientCertAuthenticator, even if they
+// carry another credential.
+func WithClientCertRequired() ServerOption {
+	return func(s *Server) { s.clientCertRequired = true }
+}
+
+// WithSecurityHeaders replaces the DefaultSecurityHeaders set on every response.
+func WithSecurityHeaders(headers SecurityHeaders) ServerOption {
+	return func(s *Server) { s.securityHeaders = headers }
+}
+
+// WithRateLimits replaces the DefaultRateLimits of each client.
+func WithRateLimits(limits RateLimits) ServerOption {
+	return func(s *Server) { s.rateLimits = limits }
+}
+
+// WithProbeBans replaces the DefaultProbeBans of clients probing for secrets.
+func WithProbeBans(bans ProbeBans) ServerOption {
+	return func(s *Server) { s.probeBans = bans }
+}
+
+// WithMetrics serves Prometheus metrics at /metrics on address, apart from the pages
+// and APIs, so that they can be kept from the public.
+func WithMetrics(address string) ServerOption {
+	return func(s *Server) { s.metricsListenAddress = address }
+}
+
 // WithPathPrefix informs the server that links should include prefix
 // in the URI. This does not change path handling, and only impacts
 // link rendering when providing the user with their credential link.
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// sessionCookieName holds the identity of a logged in user.
+	sessionCookieName = "gosnappass_session"
+	// loginCookieName holds the state of a login in progress.
+	loginCookieName = "gosnappass_login"
+
+	// sessionLifetime is how long a login remains valid.
+	sessionLifetime = 8 * time.Hour
+	// loginLifetime is how long a user has to complete a login at the provider.
+	loginLifetime = 10 * time.Minute
+)
+
+// errInvalidCookie is returned for cookies that are malformed, tampered with, or
+// expired.
+var errInvalidCookie = errors.New("invalid or expired cookie")
+
+// sessionManager stores state in cookies signed with an HMAC, so that the server
+// holds no session state of its own.
+type sessionManager struct {
+	key []byte
+	// path is the cookie path, which must cover every page of the application.
+	path string
+	// secure marks cookies as only sent over https.
+	secure bool
+}
+
+// signedCookieValue wraps a cookie payload with its expiration time.
+type signedCookieValue struct {
+	Expires int64           `json:"exp"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// set stores v in the cookie called name for lifetime.
+func (sm *sessionManager) set(w http.ResponseWriter, name string, v any, lifetime time.Duration) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	expires := time.Now().Add(lifetime)
+	b, err := json.Marshal(signedCookieValue{Expires: expires.Unix(), Payload: payload})
+	if err != nil {
+		return err
+	}
+
+	value := base64.RawURLEncoding.EncodeToString(b)
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value + "." + base64.RawURLEncoding.EncodeToString(sm.sign(value)),
+		Path:     sm.path,
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   sm.secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// get decodes the cookie called name from r into v.
+func (sm *sessionManager) get(r *http.Request, name string, v any) error {
+	c, err := r.Cookie(name)
+	if err != nil {
+		return err
+	}
+
+	value, sig, found := strings.Cut(c.Value, ".")
+	if !found {
+		return errInvalidCookie
+	}
+
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, sm.sign(value)) {
+		return errInvalidCookie
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return errInvalidCookie
+	}
+
+	scv := signedCookieValue{}
+	if err := json.Unmarshal(b, &scv); err != nil {
+		return errInvalidCookie
+	}
+
+	if time.Now().After(time.Unix(scv.Expires, 0)) {
+		return errInvalidCookie
+	}
+
+	return json.Unmarshal(scv.Payload, v)
+}
+
+// clear removes the cookie called name.
+func (sm *sessionManager) clear(w http.ResponseWriter, name string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Path:     sm.path,
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   sm.secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// identity returns the logged in user of r, or nil if there isn't one.
+func (sm *sessionManager) identity(r *http.Request) *identity {
+	id := identity{}
+	if err := sm.get(r, sessionCookieName, &id); err != nil {
+		return nil
+	}
+	return &id
+}
+
+func (sm *sessionManager) sign(value string) []byte {
+	mac := hmac.New(sha256.New, sm.key)
+	mac.Write([]byte(value))
+	return mac.Sum(nil)
+}
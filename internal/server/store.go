@@ -0,0 +1,288 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// errSecretNotFound is returned when a secret does not exist in the database. This
+// happens when it never existed, it expired, or it was already revealed.
+var errSecretNotFound = errors.New("secret not found")
+
+// errSecretExists is returned when storing a secret at an ID that is taken.
+var errSecretExists = errors.New("secret ID already in use")
+
+// maxCreateAttempts is how many IDs are tried for a new secret before giving up.
+// Collisions are rare, so more than one attempt almost never happens.
+const maxCreateAttempts = 3
+
+// Reasons recorded in tombstones for secrets that no longer exist.
+const (
+	goneExpired  = "expired"
+	goneRevealed = "revealed"
+	goneDeleted  = "deleted"
+)
+
+// tombstoneRetention is how long after a secret expires we remember that it existed,
+// so that it can be reported as gone rather than not found.
+const tombstoneRetention = 24 * time.Hour
+
+// auxKey returns the database key for auxiliary data of kind about name. Auxiliary
+// keys are keyPrefix followed by a colon, which never begins a secret ID.
+func auxKey(keyPrefix, kind, name string) string {
+	return keyPrefix + ":" + kind + ":" + name
+}
+
+// tombstoneKey returns the database key of the tombstone for the secret at id.
+func tombstoneKey(keyPrefix, id string) string {
+	return auxKey(keyPrefix, "tombstone", id)
+}
+
+// secretKindFields identifies secrets whose plaintext is a structured secret of named
+// fields rather than free text.
+const secretKindFields = "fields"
+
+// storedSecret is the value persisted in the database for each secret. Only Token
+// is encrypted. Everything else is metadata the server needs before the secret is
+// revealed.
+type storedSecret struct {
+	// Token is the fernet token containing the encrypted secret.
+	Token string `json:"token"`
+	// NotBefore is the unix time before which the secret may not be revealed. Zero
+	// means the secret may be revealed immediately.
+	NotBefore int64 `json:"not_before,omitempty"`
+	// Label is a short, non-secret description set by the sender and shown to the
+	// recipient before the secret is revealed.
+	Label string `json:"label,omitempty"`
+	// Kind describes how the decrypted plaintext is encoded. Empty means free text;
+	// secretKindFields means a JSON-encoded structured secret.
+	Kind string `json:"kind,omitempty"`
+	// Restriction limits who may reveal the secret. Nil means anyone with the link
+	// may reveal it.
+	Restriction *revealRestriction `json:"restriction,omitempty"`
+}
+
+// activeAt returns true if the secret may be revealed at t.
+func (s storedSecret) activeAt(t time.Time) bool {
+	return s.NotBefore == 0 || !t.Before(s.activatesAt())
+}
+
+// activatesAt returns the time at which the secret may be revealed.
+func (s storedSecret) activatesAt() time.Time {
+	return time.Unix(s.NotBefore, 0)
+}
+
+// newSecret describes a secret to create.
+type newSecret struct {
+	// plaintext is the secret, encoded according to kind.
+	plaintext string
+	kind      string
+	label     string
+	// notBefore is the time from which the secret may be revealed. The zero time
+	// means immediately.
+	notBefore   time.Time
+	restriction *revealRestriction
+	// ttl is how long the secret may be revealed for, counted from notBefore.
+	ttl time.Duration
+	// creator is the actor creating the secret, recorded in the audit event.
+	creator string
+}
+
+// createSecret encrypts and stores s, returning the link that reveals it. A new ID
+// and key are generated if the ID is taken by another secret.
+func createSecret(ctx context.Context, cfg routerConfig, s newSecret) (secretLink, error) {
+	for attempt := 1; ; attempt++ {
+		link, err := newSecretLink(cfg.linkStyle, cfg.redisKeyPrefix)
+		if err != nil {
+			return secretLink{}, err
+		}
+
+		token, err := EncryptWithKey(s.plaintext, link.key)
+		if err != nil {
+			return secretLink{}, err
+		}
+
+		stored := s.metadata()
+		stored.Token = token
+		err = storeSecret(ctx, cfg.redisKeyPrefix, link.id, stored, s.expiration())
+		if err == errSecretExists && attempt < maxCreateAttempts {
+			continue
+		}
+		if err != nil {
+			return secretLink{}, err
+		}
+
+		auditEvent(ctx, auditSecretCreated, s.creator, "secret", link.id)
+		return link, nil
+	}
+}
+
+// metadata returns the unencrypted metadata stored with s.
+func (s newSecret) metadata() storedSecret {
+	stored := storedSecret{Label: s.label, Kind: s.kind, Restriction: s.restriction}
+	if !s.notBefore.IsZero() {
+		stored.NotBefore = s.notBefore.Unix()
+	}
+	return stored
+}
+
+// expiration returns how long s is kept in the database. The TTL counts from
+// activation, so scheduled secrets live until the activation time plus the TTL.
+func (s newSecret) expiration() time.Duration {
+	if s.notBefore.IsZero() {
+		return s.ttl
+	}
+	return s.ttl + time.Until(s.notBefore)
+}
+
+// storeSecret persists s at id, unless another secret is stored there, in which case
+// errSecretExists is returned. The secret is removed from the database when ttl
+// elapses. A tombstone is stored alongside it, and outlives it by tombstoneRetention.
+func storeSecret(ctx context.Context, keyPrefix, id string, s storedSecret, ttl time.Duration) error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	stored, err := RedisClient().SetNX(ctx, id, string(b), ttl).Result()
+	if err != nil {
+		return err
+	}
+
+	if !stored {
+		return errSecretExists
+	}
+
+	return RedisClient().Set(ctx, tombstoneKey(keyPrefix, id), goneExpired, ttl+tombstoneRetention).Err()
+}
+
+// lookupSecret returns the secret stored at id without removing it.
+func lookupSecret(ctx context.Context, id string) (storedSecret, error) {
+	v, err := RedisClient().Get(ctx, id).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return storedSecret{}, errSecretNotFound
+		}
+		return storedSecret{}, err
+	}
+
+	return decodeStoredSecret(v)
+}
+
+// takeSecret removes the secret stored at id from the database, provided it still
+// holds token, the encrypted secret the caller decrypted. Callers decrypt before
+// taking, so that a wrong key never burns a secret. errSecretNotFound is returned if
+// the secret is gone or was replaced meanwhile, e.g. by a concurrent reveal.
+func takeSecret(ctx context.Context, keyPrefix, id, token string) error {
+	err := RedisClient().Watch(ctx, func(tx *redis.Tx) error {
+		v, err := tx.Get(ctx, id).Result()
+		if err != nil {
+			// redis.Nil implies the key didn't exist at access time. It's possible the
+			// key timed out while another view for this key was loaded.
+			if err == redis.Nil {
+				return errSecretNotFound
+			}
+			return err
+		}
+
+		s, err := decodeStoredSecret(v)
+		if err != nil {
+			return err
+		}
+
+		if s.Token != token {
+			return errSecretNotFound
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, id)
+			return nil
+		})
+		return err
+	}, id)
+
+	// The transaction fails if the secret changed after it was read, which only
+	// happens when another request took it.
+	if err == redis.TxFailedErr {
+		return errSecretNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	buryTombstone(ctx, keyPrefix, id, goneRevealed)
+	return nil
+}
+
+// deleteSecret removes the secret stored at id without revealing it.
+func deleteSecret(ctx context.Context, keyPrefix, id string) error {
+	n, err := RedisClient().Del(ctx, id).Result()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return errSecretNotFound
+	}
+
+	buryTombstone(ctx, keyPrefix, id, goneDeleted)
+	return nil
+}
+
+// secretTTL returns how long until the secret at id expires.
+func secretTTL(ctx context.Context, id string) (time.Duration, error) {
+	ttl, err := RedisClient().PTTL(ctx, id).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	// negative durations indicate that the key doesn't exist or has no expiration.
+	if ttl < 0 {
+		return 0, errSecretNotFound
+	}
+
+	return ttl, nil
+}
+
+// secretGone returns the reason the secret at id no longer exists, or false if the
+// database holds no record of it.
+func secretGone(ctx context.Context, keyPrefix, id string) (string, bool, error) {
+	reason, err := RedisClient().Get(ctx, tombstoneKey(keyPrefix, id)).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+
+	return reason, true, nil
+}
+
+// buryTombstone records why the secret at id no longer exists. Tombstones are kept
+// for as long as they were originally meant to, so secrets stored before tombstones
+// existed don't get one. Failing to record the reason is not an error worth failing
+// the request over, since the secret itself is already gone.
+func buryTombstone(ctx context.Context, keyPrefix, id, reason string) {
+	_ = RedisClient().SetArgs(ctx, tombstoneKey(keyPrefix, id), reason, redis.SetArgs{Mode: "XX", KeepTTL: true}).Err()
+}
+
+// decodeStoredSecret parses a database value into a storedSecret. Values written
+// before metadata was stored alongside secrets contain only the fernet token, and
+// are returned as a storedSecret with no metadata.
+func decodeStoredSecret(v string) (storedSecret, error) {
+	if !strings.HasPrefix(v, "{") {
+		return storedSecret{Token: v}, nil
+	}
+
+	s := storedSecret{}
+	if err := json.Unmarshal([]byte(v), &s); err != nil {
+		return storedSecret{}, err
+	}
+
+	return s, nil
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRevealWithWrongKeyKeepsSecret(t *testing.T) {
+	for _, style := range []string{LinkStyleLong, LinkStyleShort, LinkStyleWords} {
+		t.Run(style, func(t *testing.T) {
+			ts := newTestServer(t, WithLinkStyle(style))
+			created, other := ts.createSecret(t, "hunter2"), ts.createSecret(t, "other")
+
+			// The ID of one secret with the valid key of another.
+			token, otherToken := tokenOf(created.Link), tokenOf(other.Link)
+			wrong := token[:len(created.ID)+1] + otherToken[len(other.ID)+1:]
+
+			resp, body := ts.submitForm(t, "/"+wrong, nil)
+			if resp.StatusCode != http.StatusForbidden || strings.Contains(body, "hunter2") {
+				t.Errorf("revealing with a wrong key: got status %d", resp.StatusCode)
+			}
+
+			resp, body = ts.submitForm(t, "/"+token, nil)
+			if resp.StatusCode != http.StatusOK || !strings.Contains(body, "hunter2") {
+				t.Errorf("revealing after a wrong key: got status %d", resp.StatusCode)
+			}
+
+			_, body = ts.get(t, "/"+token)
+			if strings.Contains(body, "data-csrf-token") {
+				t.Errorf("the secret can be revealed twice")
+			}
+		})
+	}
+}
+
+func TestAPIRevealWithWrongKeyKeepsSecret(t *testing.T) {
+	ts := newTestServer(t)
+	created := ts.createSecret(t, "hunter2")
+	other := ts.createSecret(t, "other")
+
+	resp := ts.postJSON(t, "/api/v2/secrets/"+created.ID+"/reveal", apiV2KeyRequest{Key: other.Key}, nil, nil)
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("revealing with a wrong key: got status %d", resp.StatusCode)
+	}
+
+	revealed := apiV2RevealedSecret{}
+	resp = ts.postJSON(t, "/api/v2/secrets/"+created.ID+"/reveal", apiV2KeyRequest{Key: created.Key}, nil, &revealed)
+	if resp.StatusCode != http.StatusOK || revealed.Secret == nil || *revealed.Secret != "hunter2" {
+		t.Errorf("revealing after a wrong key: got status %d", resp.StatusCode)
+	}
+
+	resp = ts.postJSON(t, "/api/v2/secrets/"+created.ID+"/reveal", apiV2KeyRequest{Key: created.Key}, nil, nil)
+	if resp.StatusCode != http.StatusGone {
+		t.Errorf("revealing twice: got status %d", resp.StatusCode)
+	}
+}
+
+func TestTakeSecretRequiresToken(t *testing.T) {
+	newTestServer(t)
+	ctx := context.Background()
+	id := DefaultRedisKeyPrefix + "id"
+	if err := storeSecret(ctx, DefaultRedisKeyPrefix, id, storedSecret{Token: "a"}, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := takeSecret(ctx, DefaultRedisKeyPrefix, id, "b"); err != errSecretNotFound {
+		t.Errorf("taking with another token: got %v", err)
+	}
+
+	if err := takeSecret(ctx, DefaultRedisKeyPrefix, id, "a"); err != nil {
+		t.Errorf("taking with the stored token: got %v", err)
+	}
+
+	if _, err := lookupSecret(ctx, id); err != errSecretNotFound {
+		t.Errorf("looking up a taken secret: got %v", err)
+	}
+}
+
+func TestStoreSecretRefusesTakenID(t *testing.T) {
+	newTestServer(t)
+	ctx := context.Background()
+	id := DefaultRedisKeyPrefix + "id"
+	if err := storeSecret(ctx, DefaultRedisKeyPrefix, id, storedSecret{Token: "first"}, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := storeSecret(ctx, DefaultRedisKeyPrefix, id, storedSecret{Token: "second"}, time.Minute); err != errSecretExists {
+		t.Errorf("storing at a taken ID: got %v", err)
+	}
+
+	stored, err := lookupSecret(ctx, id)
+	if err != nil || stored.Token != "first" {
+		t.Errorf("the first secret was overwritten: got %q, %v", stored.Token, err)
+	}
+}
+
+func TestWordLinks(t *testing.T) {
+	link, err := newSecretLink(LinkStyleWords, DefaultRedisKeyPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(strings.Split(link.publicID, compactSeparator)); n != 8 {
+		t.Errorf("word IDs have %d words, want 8", n)
+	}
+
+	id, key, err := parseToken(link.token, DefaultRedisKeyPrefix)
+	if err != nil || id != link.id || key != link.key.Encode() {
+		t.Errorf("parsing a word token: got %q, %q, %v", id, key, err)
+	}
+
+	// IDs of fewer words are too easily enumerated.
+	short := strings.Join(strings.Split(link.token, compactSeparator)[4:], compactSeparator)
+	if _, _, err := parseToken(short, DefaultRedisKeyPrefix); err == nil {
+		t.Error("parsed a word token with a four-word ID")
+	}
+	if _, err := parseSecretID(strings.Join(strings.Split(link.publicID, compactSeparator)[4:], compactSeparator), DefaultRedisKeyPrefix); err == nil {
+		t.Error("parsed a four-word ID")
+	}
+}
@@ -0,0 +1,287 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+// MutualTLSConfig returns a TLS configuration presenting the certificate in certFile
+// and keyFile, and requiring clients to present a certificate signed by a CA in
+// clientCAFile.
+func MutualTLSConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load certificate: %w", err)
+	}
+
+	clientCAs, err := loadCertPool(clientCAFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// loadCertPool returns the CAs in the PEM bundle clientCAFile.
+func loadCertPool(clientCAFile string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(clientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read client CA bundle: %w", err)
+	}
+
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in client CA bundle %s", clientCAFile)
+	}
+	return clientCAs, nil
+}
+
+// certReloadInterval is how often the files of a CertReloader are checked for
+// changes.
+const certReloadInterval = 30 * time.Second
+
+// CertReloader serves the certificate and key in a pair of files, and loads them
+// again when they change, so that renewed certificates are served without a
+// restart.
+type CertReloader struct {
+	certFile string
+	keyFile  string
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+	// modTime is the latest modification time of the files when they were loaded.
+	modTime time.Time
+}
+
+// NewCertReloader returns a CertReloader of the certificate in certFile and the key
+// in keyFile, which must load.
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	cr := &CertReloader{certFile: certFile, keyFile: keyFile}
+	if err := cr.Reload(); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+// Reload loads the certificate and key again. If they don't load, e.g. because only
+// one of them was replaced yet, the previous certificate is still served.
+func (cr *CertReloader) Reload() error {
+	modTime, err := cr.filesModTime()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return fmt.Errorf("unable to load certificate: %w", err)
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("unable to parse certificate: %w", err)
+	}
+	cert.Leaf = leaf
+
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	cr.cert, cr.modTime = &cert, modTime
+	return nil
+}
+
+// Certificate returns the certificate served.
+func (cr *CertReloader) Certificate() *x509.Certificate {
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+	return cr.cert.Leaf
+}
+
+// GetCertificate returns the certificate served, and is meant for
+// tls.Config.GetCertificate.
+func (cr *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+	return cr.cert, nil
+}
+
+// changed returns true if either file was modified since the certificate was loaded.
+func (cr *CertReloader) changed() (bool, error) {
+	modTime, err := cr.filesModTime()
+	if err != nil {
+		return false, err
+	}
+
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+	return !modTime.Equal(cr.modTime), nil
+}
+
+// filesModTime returns the latest modification time of the certificate and key
+// files.
+func (cr *CertReloader) filesModTime() (time.Time, error) {
+	latest := time.Time{}
+	for _, name := range []string{cr.certFile, cr.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("unable to read certificate: %w", err)
+		}
+
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// watch reloads the certificate whenever its files change, checking every interval,
+// until done is closed.
+func (cr *CertReloader) watch(logger *slog.Logger, interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		changed, err := cr.changed()
+		if err == nil && !changed {
+			continue
+		}
+		if err == nil {
+			err = cr.Reload()
+		}
+		if err != nil {
+			logger.Error("unable to reload TLS certificate, serving the previous one", err)
+			continue
+		}
+
+		cert := cr.Certificate()
+		logger.Info("reloaded TLS certificate", "subject", cert.Subject.String(), "notAfter", cert.NotAfter)
+	}
+}
+
+// tlsVersions are the names of the TLS versions a server may require, see
+// ParseTLSVersion.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ParseTLSVersion parses a TLS version such as "1.2" or "1.3".
+func ParseTLSVersion(s string) (uint16, error) {
+	version, ok := tlsVersions[strings.TrimPrefix(strings.TrimSpace(s), "TLS")]
+	if !ok {
+		return 0, fmt.Errorf("unknown TLS version %q, expected one of 1.0, 1.1, 1.2 or 1.3", s)
+	}
+	return version, nil
+}
+
+// ParseCipherSuites parses a comma separated list of the names of cipher suites,
+// e.g. "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256". Only the secure suites of TLS 1.2
+// and earlier are accepted; those of TLS 1.3 can't be configured.
+func ParseCipherSuites(s string) ([]uint16, error) {
+	suites := map[string]*tls.CipherSuite{}
+	for _, suite := range tls.CipherSuites() {
+		suites[suite.Name] = suite
+	}
+
+	ids := []uint16{}
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		suite, ok := suites[name]
+		if !ok {
+			return nil, fmt.Errorf("%q is not a supported cipher suite", name)
+		}
+
+		if len(suite.SupportedVersions) == 1 && suite.SupportedVersions[0] == tls.VersionTLS13 {
+			return nil, fmt.Errorf("%q is a TLS 1.3 cipher suite, which can't be configured", name)
+		}
+		ids = append(ids, suite.ID)
+	}
+
+	return ids, nil
+}
+
+// servingTLSConfig returns the TLS configuration of an HTTP server presenting the
+// certificate of certs, with at least minVersion, and cipherSuites if any. If
+// clientCAs is set, clients are asked for a certificate, which must be signed by one
+// of clientCAs if presented. Clients without one still connect, since browsers don't
+// have any.
+func servingTLSConfig(certs *CertReloader, minVersion uint16, cipherSuites []uint16, clientCAs *x509.CertPool) *tls.Config {
+	config := &tls.Config{
+		GetCertificate: certs.GetCertificate,
+		MinVersion:     minVersion,
+	}
+	if len(cipherSuites) > 0 {
+		config.CipherSuites = cipherSuites
+	}
+	if clientCAs != nil {
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+		config.ClientCAs = clientCAs
+	}
+	return config
+}
+
+// newHTTPSRedirectHandler produces a handler redirecting plain HTTP requests to the
+// same URL over https, on the host of publicURL if it is set, or else on the
+// requested host and the port of tlsAddress. Redirects to hosts not in allowedHosts,
+// if any, are refused, so that clients can't pick where they are redirected.
+func newHTTPSRedirectHandler(publicURL *url.URL, allowedHosts []string, tlsAddress string) http.Handler {
+	_, port, _ := net.SplitHostPort(tlsAddress)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := ""
+		if publicURL != nil {
+			host = publicURL.Host
+		} else {
+			if !validHost(r.Host) {
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
+
+			host = r.Host
+			if h, _, err := net.SplitHostPort(r.Host); err == nil {
+				host = h
+			}
+			if port != "" && port != "443" {
+				host = net.JoinHostPort(strings.Trim(host, "[]"), port)
+			}
+
+			if len(allowedHosts) > 0 && !hostAllowed(allowedHosts, host) {
+				http.Error(w, http.StatusText(http.StatusMisdirectedRequest), http.StatusMisdirectedRequest)
+				return
+			}
+		}
+
+		target := url.URL{Scheme: "https", Host: host, Path: r.URL.Path, RawPath: r.URL.RawPath, RawQuery: r.URL.RawQuery}
+
+		// Only GET and HEAD may be changed to GET by a 301; others keep their method.
+		status := http.StatusPermanentRedirect
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			status = http.StatusMovedPermanently
+		}
+		http.Redirect(w, r, target.String(), status)
+	})
+}
@@ -0,0 +1,234 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+// writeCert writes a certificate for name issued by ca, and its key, to certFile and
+// keyFile, both modified at modTime.
+func writeCert(t *testing.T, ca *testCA, name, certFile, keyFile string, modTime time.Time) {
+	t.Helper()
+	certPEM, keyPEM := ca.issue(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: name},
+		DNSNames:    []string{name},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	for file, b := range map[string][]byte{certFile: certPEM, keyFile: keyPEM} {
+		if err := os.WriteFile(file, b, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(file, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// servedName returns the common name of the certificate served by cr.
+func servedName(t *testing.T, cr *CertReloader) string {
+	t.Helper()
+	cert, err := cr.GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert.Leaf.Subject.CommonName
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	ca := newTestCA(t)
+	writeCert(t, ca, "old.example.com", certFile, keyFile, time.Now())
+
+	cr, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := servedName(t, cr); name != "old.example.com" {
+		t.Fatalf("got certificate for %q", name)
+	}
+
+	// A certificate that doesn't match the key, as while only one of them was
+	// replaced, isn't served.
+	keyPEM, err := os.ReadFile(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeCert(t, ca, "new.example.com", certFile, keyFile, time.Now().Add(time.Minute))
+	if err := os.WriteFile(keyFile, keyPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := cr.Reload(); err == nil {
+		t.Error("reloaded a certificate without its key")
+	}
+	if name := servedName(t, cr); name != "old.example.com" {
+		t.Errorf("after a failed reload: got certificate for %q", name)
+	}
+
+	writeCert(t, ca, "new.example.com", certFile, keyFile, time.Now().Add(2*time.Minute))
+	if err := cr.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if name := servedName(t, cr); name != "new.example.com" || cr.Certificate().Subject.CommonName != "new.example.com" {
+		t.Errorf("after a reload: got certificate for %q", name)
+	}
+
+	if err := os.Remove(keyFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := cr.Reload(); err == nil {
+		t.Error("reloaded without a key file")
+	}
+	if name := servedName(t, cr); name != "new.example.com" {
+		t.Errorf("without a key file: got certificate for %q", name)
+	}
+
+	if _, err := NewCertReloader(certFile, keyFile); err == nil {
+		t.Error("created a reloader without a key file")
+	}
+}
+
+func TestCertReloaderWatch(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	ca := newTestCA(t)
+	writeCert(t, ca, "old.example.com", certFile, keyFile, time.Now())
+
+	cr, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logs := &syncBuffer{}
+	done := make(chan struct{})
+	defer close(done)
+	go cr.watch(slog.New(slog.NewJSONHandler(logs)), 10*time.Millisecond, done)
+
+	waitFor := func(t *testing.T, log string) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for !strings.Contains(logs.String(), log) {
+			if time.Now().After(deadline) {
+				t.Fatalf("%q wasn't logged: %s", log, logs)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// Rewriting only the certificate fails to reload until the key follows.
+	keyPEM, err := os.ReadFile(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeCert(t, ca, "new.example.com", certFile, keyFile, time.Now().Add(time.Minute))
+	if err := os.WriteFile(keyFile, keyPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "unable to reload TLS certificate, serving the previous one")
+	if name := servedName(t, cr); name != "old.example.com" {
+		t.Errorf("after a failed reload: got certificate for %q", name)
+	}
+
+	writeCert(t, ca, "new.example.com", certFile, keyFile, time.Now().Add(2*time.Minute))
+	waitFor(t, "reloaded TLS certificate")
+	if name := servedName(t, cr); name != "new.example.com" {
+		t.Errorf("after rewriting the files: got certificate for %q", name)
+	}
+}
+
+func TestHTTPSRedirectHandler(t *testing.T) {
+	publicURL, err := url.Parse("https://snappass.example.com/sharepass/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		publicURL    *url.URL
+		allowedHosts []string
+		tlsAddress   string
+		method       string
+		target       string
+		host         string
+		wantStatus   int
+		wantLocation string
+	}{
+		{"GET", nil, nil, ":443", http.MethodGet, "http://snappass.example.org/s?a=b", "", http.StatusMovedPermanently, "https://snappass.example.org/s?a=b"},
+		{"HEAD", nil, nil, ":443", http.MethodHead, "http://snappass.example.org/", "", http.StatusMovedPermanently, "https://snappass.example.org/"},
+		{"POST keeps its method", nil, nil, ":443", http.MethodPost, "http://snappass.example.org/", "", http.StatusPermanentRedirect, "https://snappass.example.org/"},
+		{"escaped path", nil, nil, ":443", http.MethodGet, "http://snappass.example.org/a%2Fb", "", http.StatusMovedPermanently, "https://snappass.example.org/a%2Fb"},
+		{"port of the TLS address", nil, nil, ":8443", http.MethodGet, "http://snappass.example.org:8080/", "", http.StatusMovedPermanently, "https://snappass.example.org:8443/"},
+		{"requested port dropped", nil, nil, ":443", http.MethodGet, "http://snappass.example.org:8080/", "", http.StatusMovedPermanently, "https://snappass.example.org/"},
+		{"IPv6 host", nil, nil, ":8443", http.MethodGet, "http://[2001:db8::1]:8080/", "", http.StatusMovedPermanently, "https://[2001:db8::1]:8443/"},
+		{"public URL", publicURL, nil, ":8443", http.MethodGet, "http://attacker.example/sharepass/s", "", http.StatusMovedPermanently, "https://snappass.example.com/sharepass/s"},
+		{"allowed host", nil, []string{"snappass.example.org"}, ":8443", http.MethodGet, "http://snappass.example.org/", "", http.StatusMovedPermanently, "https://snappass.example.org:8443/"},
+		{"host not allowed", nil, []string{"snappass.example.org"}, ":443", http.MethodGet, "http://attacker.example/", "", http.StatusMisdirectedRequest, ""},
+		{"public URL ignores the allowed hosts", publicURL, []string{"snappass.example.org"}, ":443", http.MethodGet, "http://attacker.example/", "", http.StatusMovedPermanently, "https://snappass.example.com/"},
+		{"invalid host", nil, nil, ":443", http.MethodGet, "http://snappass.example.org/", "attacker.example@snappass.example.org", http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.target, nil)
+			if tt.host != "" {
+				r.Host = tt.host
+			}
+			w := httptest.NewRecorder()
+			newHTTPSRedirectHandler(tt.publicURL, tt.allowedHosts, tt.tlsAddress).ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus || w.Header().Get("Location") != tt.wantLocation {
+				t.Errorf("got status %d to %q, want %d to %q", w.Code, w.Header().Get("Location"), tt.wantStatus, tt.wantLocation)
+			}
+		})
+	}
+}
+
+func TestParseTLSVersion(t *testing.T) {
+	for s, want := range map[string]uint16{"1.2": tls.VersionTLS12, " TLS1.3": tls.VersionTLS13, "1.0": tls.VersionTLS10} {
+		if got, err := ParseTLSVersion(s); err != nil || got != want {
+			t.Errorf("ParseTLSVersion(%q) = %x, %v, want %x", s, got, err, want)
+		}
+	}
+
+	for _, s := range []string{"", "1.4", "SSL3.0", "tls1.2"} {
+		if _, err := ParseTLSVersion(s); err == nil {
+			t.Errorf("parsed TLS version %q", s)
+		}
+	}
+}
+
+func TestParseCipherSuites(t *testing.T) {
+	got, err := ParseCipherSuites(" TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,,TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256 ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0] != tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 || got[1] != tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256 {
+		t.Errorf("got suites %x", got)
+	}
+
+	if got, err := ParseCipherSuites(""); err != nil || len(got) != 0 {
+		t.Errorf("parsing no suites: got %x, %v", got, err)
+	}
+
+	for _, s := range []string{
+		"TLS_AES_128_GCM_SHA256",
+		"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,TLS_CHACHA20_POLY1305_SHA256",
+		"TLS_RSA_WITH_RC4_128_SHA",
+		"TLS_NOT_A_SUITE",
+	} {
+		if _, err := ParseCipherSuites(s); err == nil {
+			t.Errorf("parsed cipher suites %q", s)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package server
+
+// wordList is the list of words used by word links. Each word encodes one byte, so
+// the list must contain exactly 256 distinct words. Words are short, lowercase, and
+// easy to spell when read aloud. Changing the list breaks existing word links.
+var wordList = [256]string{
+	"acid", "acorn", "actor", "adult", "agent", "alarm", "album", "alert",
+	"alley", "amber", "angle", "ankle", "apple", "apron", "arena", "armor",
+	"arrow", "atlas", "attic", "audio", "bacon", "badge", "bagel", "baker",
+	"banjo", "barn", "basil", "basin", "beach", "beard", "bench", "berry",
+	"bison", "blade", "blank", "blaze", "bloom", "board", "boat", "bonus",
+	"boost", "boots", "brain", "brass", "bread", "brick", "bride", "brook",
+	"brush", "buddy", "bugle", "bunny", "burst", "cabin", "cable", "camel",
+	"canal", "candy", "canoe", "cargo", "cedar", "chain", "chair", "chalk",
+	"charm", "cheek", "chess", "chief", "chili", "cider", "clamp", "cliff",
+	"clock", "cloud", "coach", "cobra", "cocoa", "comet", "coral", "couch",
+	"crane", "cream", "creek", "crown", "cubic", "daisy", "dance", "delta",
+	"denim", "depot", "diary", "dingo", "diver", "dock", "dough", "dozen",
+	"drift", "drum", "eagle", "easel", "echo", "elbow", "elder", "ember",
+	"empty", "envoy", "equal", "error", "event", "fable", "fancy", "feast",
+	"fence", "ferry", "fiber", "field", "flame", "flask", "fleet", "flint",
+	"flock", "flute", "focus", "fox", "frame", "frost", "fruit", "fudge",
+	"gamer", "gecko", "ghost", "giant", "glass", "globe", "glove", "goat",
+	"grain", "grape", "guava", "habit", "harp", "hazel", "heart", "hedge",
+	"hero", "hiker", "hobby", "honey", "hotel", "human", "igloo", "index",
+	"ingot", "inlet", "iris", "ivory", "jelly", "jewel", "joker", "judge",
+	"juice", "kayak", "kiosk", "koala", "lamp", "laser", "lemon", "level",
+	"lilac", "lime", "linen", "lion", "llama", "lotus", "lunar", "mango",
+	"maple", "medal", "melon", "metal", "modem", "moose", "motor", "mural",
+	"music", "novel", "oasis", "ocean", "olive", "omega", "onion", "opera",
+	"orbit", "otter", "oven", "panda", "paper", "pasta", "peach", "piano",
+	"pilot", "pixel", "plaza", "plum", "poem", "polka", "pony", "poppy",
+	"prism", "quail", "quiet", "quilt", "radar", "radio", "raft", "rain",
+	"raven", "razor", "reef", "relay", "rhino", "rider", "river", "robin",
+	"robot", "rodeo", "rose", "ruby", "rugby", "salad", "salsa", "satin",
+	"sauce", "scarf", "scout", "shark", "shelf", "skunk", "slate", "sled",
+	"snail", "sonic", "spice", "spoon", "squid", "stamp", "stone", "stove",
+	"sugar", "swamp", "syrup", "table", "tango", "tiger", "toast", "topaz",
+}
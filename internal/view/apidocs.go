@@ -0,0 +1,238 @@
+package view
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/concerthall/gosnappass/internal/embedded"
+)
+
+// apiDocMethods are the operations rendered from each path of the specification, in
+// display order.
+var apiDocMethods = []string{"get", "post", "put", "patch", "delete"}
+
+// apiDocs is the documentation rendered by APIDocs, built once from the embedded
+// OpenAPI specification.
+var apiDocs apiDocument
+
+// openAPISpec holds the parts of an OpenAPI document that are rendered as
+// documentation.
+type openAPISpec struct {
+	Info struct {
+		Title       string `json:"title"`
+		Description string `json:"description"`
+		Version     string `json:"version"`
+	} `json:"info"`
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components struct {
+		Responses map[string]openAPIResponse `json:"responses"`
+		Schemas   map[string]openAPISchema   `json:"schemas"`
+	} `json:"components"`
+}
+
+type openAPIOperation struct {
+	Summary     string   `json:"summary"`
+	Description string   `json:"description"`
+	Tags        []string `json:"tags"`
+	RequestBody *struct {
+		Content map[string]struct {
+			Schema openAPISchema `json:"schema"`
+		} `json:"content"`
+	} `json:"requestBody"`
+	Responses map[string]openAPIResponse `json:"responses"`
+}
+
+type openAPIResponse struct {
+	Ref         string `json:"$ref"`
+	Description string `json:"description"`
+}
+
+type openAPISchema struct {
+	Ref         string                   `json:"$ref"`
+	Type        string                   `json:"type"`
+	Format      string                   `json:"format"`
+	Description string                   `json:"description"`
+	Required    []string                 `json:"required"`
+	Enum        []string                 `json:"enum"`
+	Items       *openAPISchema           `json:"items"`
+	OneOf       []openAPISchema          `json:"oneOf"`
+	Properties  map[string]openAPISchema `json:"properties"`
+}
+
+// apiDocument is the rendered form of the OpenAPI specification.
+type apiDocument struct {
+	Title       string
+	Description string
+	Version     string
+	Operations  []apiOperation
+	Schemas     []apiSchema
+}
+
+type apiOperation struct {
+	Method      string
+	Path        string
+	Tag         string
+	Summary     string
+	Description string
+	RequestBody string
+	Responses   []apiResponse
+}
+
+type apiResponse struct {
+	Status      string
+	Description string
+}
+
+type apiSchema struct {
+	Name        string
+	Description string
+	Properties  []apiProperty
+}
+
+type apiProperty struct {
+	Name        string
+	Type        string
+	Required    bool
+	Description string
+}
+
+// loadAPIDocs builds the rendered documentation from the embedded OpenAPI
+// specification.
+func loadAPIDocs() (apiDocument, error) {
+	spec := openAPISpec{}
+	if err := json.Unmarshal(embedded.OpenAPISpec, &spec); err != nil {
+		return apiDocument{}, err
+	}
+
+	doc := apiDocument{
+		Title:       spec.Info.Title,
+		Description: spec.Info.Description,
+		Version:     spec.Info.Version,
+	}
+
+	paths := make([]string, 0, len(spec.Paths))
+	for path := range spec.Paths {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		for _, method := range apiDocMethods {
+			raw, ok := spec.Paths[path][method]
+			if !ok {
+				continue
+			}
+
+			op := openAPIOperation{}
+			if err := json.Unmarshal(raw, &op); err != nil {
+				return apiDocument{}, err
+			}
+
+			rendered := apiOperation{
+				Method:      strings.ToUpper(method),
+				Path:        path,
+				Summary:     op.Summary,
+				Description: op.Description,
+			}
+			if len(op.Tags) > 0 {
+				rendered.Tag = op.Tags[0]
+			}
+			if op.RequestBody != nil {
+				if content, ok := op.RequestBody.Content["application/json"]; ok {
+					rendered.RequestBody = schemaType(content.Schema)
+				}
+			}
+
+			statuses := make([]string, 0, len(op.Responses))
+			for status := range op.Responses {
+				statuses = append(statuses, status)
+			}
+			sort.Strings(statuses)
+
+			for _, status := range statuses {
+				resp := op.Responses[status]
+				if resp.Ref != "" {
+					resp = spec.Components.Responses[refName(resp.Ref)]
+				}
+				rendered.Responses = append(rendered.Responses, apiResponse{Status: status, Description: resp.Description})
+			}
+
+			doc.Operations = append(doc.Operations, rendered)
+		}
+	}
+
+	names := make([]string, 0, len(spec.Components.Schemas))
+	for name := range spec.Components.Schemas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		schema := spec.Components.Schemas[name]
+		rendered := apiSchema{Name: name, Description: schema.Description}
+
+		props := make([]string, 0, len(schema.Properties))
+		for prop := range schema.Properties {
+			props = append(props, prop)
+		}
+		sort.Strings(props)
+
+		for _, prop := range props {
+			p := schema.Properties[prop]
+			rendered.Properties = append(rendered.Properties, apiProperty{
+				Name:        prop,
+				Type:        schemaType(p),
+				Required:    contains(schema.Required, prop),
+				Description: p.Description,
+			})
+		}
+
+		doc.Schemas = append(doc.Schemas, rendered)
+	}
+
+	return doc, nil
+}
+
+// schemaType describes the type of s in a few words, naming referenced schemas.
+func schemaType(s openAPISchema) string {
+	switch {
+	case s.Ref != "":
+		return refName(s.Ref)
+	case len(s.OneOf) > 0:
+		types := make([]string, 0, len(s.OneOf))
+		for _, o := range s.OneOf {
+			types = append(types, schemaType(o))
+		}
+		return strings.Join(types, " or ")
+	case s.Type == "array" && s.Items != nil:
+		return "array of " + schemaType(*s.Items)
+	case len(s.Enum) > 0:
+		return s.Type + " (" + strings.Join(s.Enum, ", ") + ")"
+	case s.Format != "":
+		return s.Type + " (" + s.Format + ")"
+	}
+
+	return s.Type
+}
+
+// refName returns the name of the component a local $ref points to.
+func refName(ref string) string {
+	return ref[strings.LastIndex(ref, "/")+1:]
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+// APIDocs renders the documentation of the JSON APIs from the embedded OpenAPI
+// specification.
+func APIDocs(w http.ResponseWriter) error {
+	return bufferedWriteTo(w, apiDocsTemplate, map[string]any{"AppHomeLinkRef": appHomeLinkRef, "Doc": apiDocs})
+}
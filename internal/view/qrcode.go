@@ -0,0 +1,48 @@
+package view
+
+import (
+	"fmt"
+	"html/template"
+	"strings"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// qrCodeModuleSize is the width and height, in SVG user units, of each QR code module.
+const qrCodeModuleSize = 4
+
+// qrCodeSVG renders content as a QR code in an inline SVG document. The bitmap
+// includes the quiet zone required by scanners. Dark modules in each row are
+// merged into a single rect to keep the markup small. The markup is built from the
+// bitmap alone, never from content, so it is safe to render unescaped.
+func qrCodeSVG(content string) (template.HTML, error) {
+	q, err := qrcode.New(content, qrcode.Medium)
+	if err != nil {
+		return "", err
+	}
+
+	bitmap := q.Bitmap()
+	size := len(bitmap) * qrCodeModuleSize
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges" role="img" aria-label="QR code of the secret link">`, size, size, size, size)
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="#fff"/>`, size, size)
+	for y, row := range bitmap {
+		for x := 0; x < len(row); x++ {
+			if !row[x] {
+				continue
+			}
+
+			start := x
+			for x < len(row) && row[x] {
+				x++
+			}
+
+			fmt.Fprintf(&b, `<rect x="%d" y="%d" width="%d" height="%d" fill="#000"/>`,
+				start*qrCodeModuleSize, y*qrCodeModuleSize, (x-start)*qrCodeModuleSize, qrCodeModuleSize)
+		}
+	}
+	b.WriteString(`</svg>`)
+
+	return template.HTML(b.String()), nil
+}
@@ -13,21 +13,29 @@ package view
 import (
 	"bytes"
 	"fmt"
+	"html/template"
 	"net/http"
 	"os"
-	"text/template"
+	"time"
 
 	"github.com/concerthall/gosnappass/internal/config"
 	"github.com/concerthall/gosnappass/internal/embedded"
 )
 
 var (
-	appHomeLinkRef          string = "/"
-	indexTemplate           *template.Template
-	confirmationTemplate    *template.Template
-	previewPasswordTemplate *template.Template
-	expiredTemplate         *template.Template
-	showPasswordTemplate    *template.Template
+	appHomeLinkRef           string = "/"
+	indexTemplate            *template.Template
+	confirmationTemplate     *template.Template
+	previewPasswordTemplate  *template.Template
+	expiredTemplate          *template.Template
+	showPasswordTemplate     *template.Template
+	showFieldsTemplate       *template.Template
+	notAuthorizedTemplate    *template.Template
+	createNotAllowedTemplate *template.Template
+	notVerifiedTemplate      *template.Template
+	rateLimitedTemplate      *template.Template
+	bannedTemplate           *template.Template
+	apiDocsTemplate          *template.Template
 )
 
 // LoadTemplates reaches into the filesystem and loads the appropriate base and
@@ -58,6 +66,38 @@ func LoadTemplates() error {
 		return err
 	}
 
+	if showFieldsTemplate, err = template.ParseFS(embedded.Templates, "templates/base.html", "templates/fields.html"); err != nil {
+		return err
+	}
+
+	if notAuthorizedTemplate, err = template.ParseFS(embedded.Templates, "templates/base.html", "templates/forbidden.html"); err != nil {
+		return err
+	}
+
+	if createNotAllowedTemplate, err = template.ParseFS(embedded.Templates, "templates/base.html", "templates/create_forbidden.html"); err != nil {
+		return err
+	}
+
+	if notVerifiedTemplate, err = template.ParseFS(embedded.Templates, "templates/base.html", "templates/not_verified.html"); err != nil {
+		return err
+	}
+
+	if rateLimitedTemplate, err = template.ParseFS(embedded.Templates, "templates/base.html", "templates/rate_limited.html"); err != nil {
+		return err
+	}
+
+	if bannedTemplate, err = template.ParseFS(embedded.Templates, "templates/base.html", "templates/banned.html"); err != nil {
+		return err
+	}
+
+	if apiDocsTemplate, err = template.ParseFS(embedded.Templates, "templates/base.html", "templates/apidocs.html"); err != nil {
+		return err
+	}
+
+	if apiDocs, err = loadAPIDocs(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -70,9 +110,20 @@ func init() {
 	}
 }
 
+// ScriptNoncer is implemented by the response writers of pages whose scripts must
+// carry a nonce, allowed by the Content-Security-Policy of the response.
+type ScriptNoncer interface {
+	ScriptNonce() string
+}
+
 // bufferedWriteTo will execute the template with data to a byte buffer, and
-// write that to w if no error occurs in writing.
-func bufferedWriteTo(w http.ResponseWriter, tmpl *template.Template, data any) error {
+// write that to w if no error occurs in writing. Scripts are given the nonce of w,
+// if it is a ScriptNoncer.
+func bufferedWriteTo(w http.ResponseWriter, tmpl *template.Template, data map[string]any) error {
+	if n, ok := w.(ScriptNoncer); ok {
+		data["ScriptNonce"] = n.ScriptNonce()
+	}
+
 	b := []byte{}
 	buf := bytes.NewBuffer(b)
 	if err := tmpl.ExecuteTemplate(buf, "base", data); err != nil {
@@ -83,27 +134,125 @@ func bufferedWriteTo(w http.ResponseWriter, tmpl *template.Template, data any) e
 	return nil
 }
 
-func Index(w http.ResponseWriter) error {
+// IndexOptions configures the form rendered by Index.
+type IndexOptions struct {
+	// LabelMaxLength is the maximum number of characters accepted for a label.
+	LabelMaxLength int
+	// Restrictions offers restricting who may reveal the secret.
+	Restrictions bool
+	// CSRFToken is submitted with the form.
+	CSRFToken string
+	// SignedInAs is the name of the logged in user.
+	SignedInAs string
+	// LogoutCSRFToken is submitted to log out. The user isn't offered to log out
+	// without one.
+	LogoutCSRFToken string
+}
+
+func Index(w http.ResponseWriter, opts IndexOptions) error {
 	// TODO: fix redundant AppHomeLinkRef usage across all views.
-	return bufferedWriteTo(w, indexTemplate, map[string]string{"AppHomeLinkRef": appHomeLinkRef})
+	return bufferedWriteTo(w, indexTemplate, map[string]any{
+		"AppHomeLinkRef":  appHomeLinkRef,
+		"LabelMaxLength":  opts.LabelMaxLength,
+		"Restrictions":    opts.Restrictions,
+		"CSRFToken":       opts.CSRFToken,
+		"SignedInAs":      opts.SignedInAs,
+		"LogoutCSRFToken": opts.LogoutCSRFToken,
+	})
 }
 
-func Confirm(w http.ResponseWriter, link string) error {
-	return bufferedWriteTo(w, confirmationTemplate, map[string]string{"AppHomeLinkRef": appHomeLinkRef, "PasswordLink": link})
+// Confirm renders the page containing the link to a newly stored secret. If
+// showQRCode is true, the page also contains a QR code of the link.
+func Confirm(w http.ResponseWriter, link string, showQRCode bool) error {
+	data := map[string]any{"AppHomeLinkRef": appHomeLinkRef, "PasswordLink": link}
+	if showQRCode {
+		svg, err := qrCodeSVG(link)
+		if err != nil {
+			return fmt.Errorf("unable to render QR code: %w", err)
+		}
+		data["QRCode"] = svg
+	}
+
+	return bufferedWriteTo(w, confirmationTemplate, data)
 }
 
-func PreviewPassword(w http.ResponseWriter) error {
-	return bufferedWriteTo(w, previewPasswordTemplate, map[string]string{"AppHomeLinkRef": appHomeLinkRef})
+// PreviewPassword renders the page shown before a secret is revealed, including the
+// sender's label if one was set. If notBefore is not the zero time, the secret cannot
+// be revealed yet and the page shows a countdown to notBefore instead of the reveal
+// button. The reveal button submits csrfToken.
+func PreviewPassword(w http.ResponseWriter, label string, notBefore time.Time, csrfToken string) error {
+	data := map[string]any{"AppHomeLinkRef": appHomeLinkRef, "Label": label, "CSRFToken": csrfToken}
+	if !notBefore.IsZero() {
+		data["NotBefore"] = notBefore.Unix()
+		data["NotBeforeText"] = notBefore.UTC().Format("2006-01-02 15:04 MST")
+	}
+
+	return bufferedWriteTo(w, previewPasswordTemplate, data)
 }
 
 // CredentialExpiredOrNotFound is the view corresponding with serving HTTP 404 responses. If the
 // view rendering fails a buffered write, this view falls back to a plain text resposne.
 func CredentialExpiredOrNotFound(w http.ResponseWriter) {
-	if err := bufferedWriteTo(w, expiredTemplate, map[string]string{"AppHomeLinkRef": appHomeLinkRef}); err != nil {
+	if err := bufferedWriteTo(w, expiredTemplate, map[string]any{"AppHomeLinkRef": appHomeLinkRef}); err != nil {
 		fmt.Fprintln(w, "We didn't find it (404)")
 	}
 }
 
 func ShowPassword(w http.ResponseWriter, password string) error {
-	return bufferedWriteTo(w, showPasswordTemplate, map[string]string{"AppHomeLinkRef": appHomeLinkRef, "Password": password})
+	return bufferedWriteTo(w, showPasswordTemplate, map[string]any{"AppHomeLinkRef": appHomeLinkRef, "Password": password})
+}
+
+// Field is a single named value of a structured secret.
+type Field struct {
+	Name  string
+	Value string
+}
+
+// ShowFields renders a structured secret, giving each field its own copy button.
+func ShowFields(w http.ResponseWriter, fields []Field) error {
+	return bufferedWriteTo(w, showFieldsTemplate, map[string]any{"AppHomeLinkRef": appHomeLinkRef, "Fields": fields})
+}
+
+// NotAuthorized is shown when user may not reveal a secret restricted by its sender.
+// The user is empty if nobody is logged in.
+func NotAuthorized(w http.ResponseWriter, user string) error {
+	return bufferedWriteTo(w, notAuthorizedTemplate, map[string]any{"AppHomeLinkRef": appHomeLinkRef, "User": user})
+}
+
+// CreateNotAllowed is shown when user may not create secrets. The user is offered to
+// log out if logoutCSRFToken isn't empty.
+func CreateNotAllowed(w http.ResponseWriter, user, logoutCSRFToken string) error {
+	return bufferedWriteTo(w, createNotAllowedTemplate, map[string]any{"AppHomeLinkRef": appHomeLinkRef, "SignedInAs": user, "LogoutCSRFToken": logoutCSRFToken})
+}
+
+// RequestNotVerified is shown when a form submission fails the CSRF checks.
+func RequestNotVerified(w http.ResponseWriter) error {
+	return bufferedWriteTo(w, notVerifiedTemplate, map[string]any{"AppHomeLinkRef": appHomeLinkRef})
+}
+
+// RateLimited is shown when a client made too many requests, and must wait for
+// retryAfter.
+func RateLimited(w http.ResponseWriter, retryAfter time.Duration) error {
+	return bufferedWriteTo(w, rateLimitedTemplate, map[string]any{"AppHomeLinkRef": appHomeLinkRef, "RetryAfter": formatWait(retryAfter)})
+}
+
+// Banned is shown when a client was banned for requesting too many unknown secrets,
+// and must wait for retryAfter.
+func Banned(w http.ResponseWriter, retryAfter time.Duration) error {
+	return bufferedWriteTo(w, bannedTemplate, map[string]any{"AppHomeLinkRef": appHomeLinkRef, "RetryAfter": formatWait(retryAfter)})
+}
+
+// formatWait describes the wait d in the largest fitting unit, up to hours.
+func formatWait(d time.Duration) string {
+	switch seconds := int(d.Round(time.Second) / time.Second); {
+	case seconds <= 1:
+		return "a second"
+	case seconds < 60:
+		return fmt.Sprintf("%d seconds", seconds)
+	case d <= 90*time.Second:
+		return "a minute"
+	case d < 90*time.Minute:
+		return fmt.Sprintf("%d minutes", int(d.Round(time.Minute)/time.Minute))
+	}
+	return fmt.Sprintf("%d hours", int(d.Round(time.Hour)/time.Hour))
 }
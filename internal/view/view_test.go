@@ -0,0 +1,221 @@
+package view
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// hostileInputs try to break out of every context that views render values in:
+// element content, textarea content, quoted and unquoted attributes, URLs and
+// templates.
+var hostileInputs = []string{
+	`</textarea><script>alert(1)</script>`,
+	`</script><script>alert(1)</script>`,
+	`"><img src=x onerror=alert(1)>`,
+	`'><svg onload=alert(1)>`,
+	`" autofocus onfocus="alert(1)`,
+	`javascript:alert(1)`,
+	`<!--<script>alert(1)//-->`,
+	`{{ .ScriptNonce }}{{ template "js" . }}`,
+	" alert(1) ",
+}
+
+// hostilePrefixes are URL_PREFIX values trying to inject script through the home
+// link.
+var hostilePrefixes = []string{
+	`javascript:alert(1)//`,
+	`"><script>alert(1)</script>`,
+	`/prefix" onmouseover="alert(1)`,
+}
+
+const testScriptNonce = "dGVzdC1ub25jZQ"
+
+// noncedRecorder records a response, and gives scripts testScriptNonce.
+type noncedRecorder struct {
+	*httptest.ResponseRecorder
+}
+
+func (noncedRecorder) ScriptNonce() string {
+	return testScriptNonce
+}
+
+// view renders a page into a recorder.
+type view struct {
+	name   string
+	render func(w noncedRecorder) error
+}
+
+// viewsOf returns every view, rendering input wherever a view renders a value.
+func viewsOf(input string) []view {
+	return []view{
+		{"Index", func(w noncedRecorder) error {
+			return Index(w, IndexOptions{LabelMaxLength: 100, Restrictions: true, CSRFToken: input, SignedInAs: input, LogoutCSRFToken: input})
+		}},
+		{"Confirm", func(w noncedRecorder) error { return Confirm(w, input, true) }},
+		{"PreviewPassword", func(w noncedRecorder) error { return PreviewPassword(w, input, time.Time{}, input) }},
+		{"PreviewPassword not before", func(w noncedRecorder) error {
+			return PreviewPassword(w, input, time.Now().Add(time.Hour), input)
+		}},
+		{"CredentialExpiredOrNotFound", func(w noncedRecorder) error { CredentialExpiredOrNotFound(w); return nil }},
+		{"ShowPassword", func(w noncedRecorder) error { return ShowPassword(w, input) }},
+		{"ShowFields", func(w noncedRecorder) error {
+			return ShowFields(w, []Field{{Name: input, Value: input}, {Name: "Notes", Value: input}})
+		}},
+		{"NotAuthorized", func(w noncedRecorder) error { return NotAuthorized(w, input) }},
+		{"CreateNotAllowed", func(w noncedRecorder) error { return CreateNotAllowed(w, input, input) }},
+		{"RequestNotVerified", func(w noncedRecorder) error { return RequestNotVerified(w) }},
+		{"RateLimited", func(w noncedRecorder) error { return RateLimited(w, time.Minute) }},
+		{"Banned", func(w noncedRecorder) error { return Banned(w, time.Hour) }},
+		{"APIDocs", func(w noncedRecorder) error { return APIDocs(w) }},
+	}
+}
+
+func TestViewsEscapeHostileInput(t *testing.T) {
+	for _, input := range hostileInputs {
+		for _, v := range viewsOf(input) {
+			t.Run(v.name+"/"+input, func(t *testing.T) {
+				assertSafe(t, render(t, v))
+			})
+		}
+	}
+}
+
+func TestViewsEscapeHostilePrefix(t *testing.T) {
+	defer func(prefix string) { appHomeLinkRef = prefix }(appHomeLinkRef)
+
+	for _, prefix := range hostilePrefixes {
+		appHomeLinkRef = prefix
+		for _, v := range viewsOf("s3cr3t") {
+			t.Run(v.name+"/"+prefix, func(t *testing.T) {
+				assertSafe(t, render(t, v))
+			})
+		}
+	}
+}
+
+func TestViewsRenderHostileSecretsVerbatim(t *testing.T) {
+	for _, input := range hostileInputs {
+		t.Run(input, func(t *testing.T) {
+			password := render(t, view{render: func(w noncedRecorder) error { return ShowPassword(w, input) }})
+			if got := textareaContents(t, password); len(got) != 1 || got[0] != input {
+				t.Errorf("ShowPassword rendered %q, want %q", got, input)
+			}
+
+			fields := render(t, view{render: func(w noncedRecorder) error {
+				return ShowFields(w, []Field{{Name: "password", Value: input}})
+			}})
+			if got := textareaContents(t, fields); len(got) != 1 || got[0] != input {
+				t.Errorf("ShowFields rendered %q, want %q", got, input)
+			}
+
+			confirm := render(t, view{render: func(w noncedRecorder) error { return Confirm(w, input, false) }})
+			if got := attribute(t, confirm, "password-link", "value"); got != input {
+				t.Errorf("Confirm rendered link %q, want %q", got, input)
+			}
+		})
+	}
+}
+
+func render(t *testing.T, v view) string {
+	t.Helper()
+	w := noncedRecorder{httptest.NewRecorder()}
+	if err := v.render(w); err != nil {
+		t.Fatal(err)
+	}
+	return w.Body.String()
+}
+
+// assertSafe fails the test if page runs any script other than the nonced static
+// scripts of views, through script elements, event handlers or javascript: URLs.
+func assertSafe(t *testing.T, page string) {
+	t.Helper()
+	z := html.NewTokenizer(strings.NewReader(page))
+	inScript := false
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			if z.Err() != io.EOF {
+				t.Fatal(z.Err())
+			}
+			return
+		case html.EndTagToken:
+			inScript = false
+		case html.TextToken:
+			if text := string(z.Text()); inScript && strings.TrimSpace(text) != "" {
+				t.Errorf("page has an inline script %q", text)
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			tok := z.Token()
+			if tok.Data == "script" {
+				inScript = tt == html.StartTagToken
+				if attr(tok, "nonce") != testScriptNonce || !strings.HasPrefix(attr(tok, "src"), "static/") {
+					t.Errorf("page has an unexpected script %s", tok)
+				}
+			}
+
+			for _, a := range tok.Attr {
+				if strings.HasPrefix(strings.ToLower(a.Key), "on") {
+					t.Errorf("page has an injected attribute %s in %s", a.Key, tok)
+				}
+				if a.Key == "href" || a.Key == "src" || a.Key == "action" {
+					if strings.HasPrefix(strings.ToLower(strings.TrimSpace(a.Val)), "javascript:") {
+						t.Errorf("page has a javascript URL in %s", tok)
+					}
+				}
+			}
+		}
+	}
+}
+
+// textareaContents returns the contents of the textareas of page.
+func textareaContents(t *testing.T, page string) []string {
+	t.Helper()
+	contents := []string{}
+	z := html.NewTokenizer(strings.NewReader(page))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return contents
+		case html.StartTagToken:
+			if z.Token().Data != "textarea" {
+				continue
+			}
+			if z.Next() == html.TextToken {
+				contents = append(contents, string(z.Text()))
+			} else {
+				contents = append(contents, "")
+			}
+		}
+	}
+}
+
+// attribute returns the attribute key of the element of page with id.
+func attribute(t *testing.T, page, id, key string) string {
+	t.Helper()
+	z := html.NewTokenizer(strings.NewReader(page))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			t.Fatalf("page has no element with id %s", id)
+		case html.StartTagToken, html.SelfClosingTagToken:
+			if tok := z.Token(); attr(tok, "id") == id {
+				return attr(tok, key)
+			}
+		}
+	}
+}
+
+func attr(tok html.Token, key string) string {
+	for _, a := range tok.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
@@ -0,0 +1,364 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/concerthall/gosnappass/internal/linktoken"
+)
+
+// maxTTL is the longest a server keeps a secret.
+const maxTTL = 14 * 24 * time.Hour
+
+// Client shares and reveals secrets with a gosnappass server.
+type Client struct {
+	baseURL     *url.URL
+	httpClient  *http.Client
+	bearerToken string
+	snappassAPI bool
+}
+
+type Option = func(*Client)
+
+// New returns a client of the server at baseURL, the URL of its home page.
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("the server URL must be http or https, got %q", baseURL)
+	}
+
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+
+	c := &Client{baseURL: u, httpClient: http.DefaultClient}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c, nil
+}
+
+// WithHTTPClient sends requests with httpClient instead of http.DefaultClient.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) { c.httpClient = httpClient }
+}
+
+// WithBearerToken authenticates requests with token, an API token or a JWT.
+func WithBearerToken(token string) Option {
+	return func(c *Client) { c.bearerToken = token }
+}
+
+// WithSnappassAPI shares secrets with the snappass compatible API. It only supports
+// a TTL, in whole seconds. That API can't reveal secrets, so Reveal returns an error
+// with this option.
+func WithSnappassAPI() Option {
+	return func(c *Client) { c.snappassAPI = true }
+}
+
+// ShareOptions configures a shared secret. The zero value shares a secret for the
+// server's default TTL.
+type ShareOptions struct {
+	// TTL is how long the secret can be revealed for. Zero means the server's
+	// default.
+	TTL time.Duration
+	// Label is a public, non-secret description shown before the secret is revealed.
+	Label string
+	// NotBefore is the time before which the secret can't be revealed.
+	NotBefore time.Time
+	// RestrictTo lists the users, by email or subject, and groups, as group:<name>,
+	// allowed to reveal the secret.
+	RestrictTo []string
+}
+
+// Field is a named value of a structured secret.
+type Field struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Secret is a revealed secret. Text is set for text secrets, and Fields for
+// structured secrets.
+type Secret struct {
+	ID     string
+	Label  string
+	Text   string
+	Fields []Field
+}
+
+// Share stores secret on the server and returns the link that reveals it.
+func (c *Client) Share(ctx context.Context, secret string, opts ShareOptions) (string, error) {
+	if secret == "" {
+		return "", errors.New("the secret must not be empty")
+	}
+
+	if c.snappassAPI {
+		return c.shareSnappass(ctx, secret, opts)
+	}
+
+	return c.shareV2(ctx, v2CreateRequest{Secret: &secret}, opts)
+}
+
+// ShareFields stores a structured secret of named fields on the server and returns
+// the link that reveals it. It requires the v2 API.
+func (c *Client) ShareFields(ctx context.Context, fields []Field, opts ShareOptions) (string, error) {
+	if len(fields) == 0 {
+		return "", errors.New("at least one field is required")
+	}
+
+	if c.snappassAPI {
+		return "", errors.New("the snappass API doesn't support structured secrets")
+	}
+
+	return c.shareV2(ctx, v2CreateRequest{Fields: fields}, opts)
+}
+
+// Reveal returns the secret that link reveals, and deletes it from the server. The
+// link may also be just its last path segment, the token. It requires the v2 API.
+func (c *Client) Reveal(ctx context.Context, link string) (*Secret, error) {
+	if c.snappassAPI {
+		return nil, errors.New("the snappass API can't reveal secrets")
+	}
+
+	id, key, err := ParseLink(link)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := v2RevealedSecret{}
+	if err := c.do(ctx, http.MethodPost, "api/v2/secrets/"+url.PathEscape(id)+"/reveal", v2KeyRequest{Key: key}, &resp); err != nil {
+		return nil, err
+	}
+
+	secret := &Secret{ID: resp.ID, Label: resp.Label, Fields: resp.Fields}
+	if resp.Secret != nil {
+		secret.Text = *resp.Secret
+	}
+	return secret, nil
+}
+
+// ParseLink returns the ID and key of the secret that link reveals. The link may
+// also be just its last path segment, the token. Long tokens separate the ID and key
+// with "~". Short and word tokens separate their parts with "-", and word tokens
+// end with twelve key words.
+func ParseLink(link string) (id, key string, err error) {
+	token := link
+	if strings.Contains(link, "/") {
+		u, err := url.Parse(link)
+		if err != nil {
+			return "", "", err
+		}
+		path := strings.TrimSuffix(u.EscapedPath(), "/")
+		token = path[strings.LastIndex(path, "/")+1:]
+	}
+
+	id, key, style, err := linktoken.Split(token)
+	if err != nil {
+		return "", "", err
+	}
+
+	// Long tokens escape their parts in the path.
+	if style == linktoken.Long {
+		if id, err = url.PathUnescape(id); err != nil {
+			return "", "", err
+		}
+		if key, err = url.PathUnescape(key); err != nil {
+			return "", "", err
+		}
+	}
+	return id, key, nil
+}
+
+// Error is returned for requests the server rejected.
+type Error struct {
+	// StatusCode is the HTTP status of the response.
+	StatusCode int
+	// Code is the stable error code of the v2 API, such as "not_found".
+	Code    string
+	Message string
+	// Reason explains why a gone secret no longer exists: expired, revealed or
+	// deleted.
+	Reason string
+}
+
+var (
+	// ErrNotFound matches errors for secrets that don't exist.
+	ErrNotFound = errors.New("secret not found")
+	// ErrGone matches errors for secrets that expired, were revealed, or were
+	// deleted.
+	ErrGone = errors.New("secret no longer exists")
+)
+
+func (e *Error) Error() string {
+	msg := e.Message
+	if msg == "" {
+		msg = http.StatusText(e.StatusCode)
+	}
+
+	if e.Reason != "" {
+		msg += " (" + e.Reason + ")"
+	}
+
+	return fmt.Sprintf("gosnappass: %d %s", e.StatusCode, msg)
+}
+
+// Is makes errors.Is match ErrNotFound and ErrGone.
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrGone:
+		return e.StatusCode == http.StatusGone
+	}
+	return false
+}
+
+type snappassRequest struct {
+	Password string `json:"password"`
+	TTL      int    `json:"ttl,omitempty"`
+}
+
+type snappassResponse struct {
+	Link string `json:"link"`
+}
+
+type v2CreateRequest struct {
+	Secret     *string    `json:"secret,omitempty"`
+	Fields     []Field    `json:"fields,omitempty"`
+	TTL        int        `json:"ttl,omitempty"`
+	Label      string     `json:"label,omitempty"`
+	NotBefore  *time.Time `json:"not_before,omitempty"`
+	RestrictTo []string   `json:"restrict_to,omitempty"`
+}
+
+type v2Secret struct {
+	ID   string `json:"id"`
+	Link string `json:"link"`
+}
+
+type v2KeyRequest struct {
+	Key string `json:"key"`
+}
+
+type v2RevealedSecret struct {
+	ID     string  `json:"id"`
+	Label  string  `json:"label"`
+	Secret *string `json:"secret"`
+	Fields []Field `json:"fields"`
+}
+
+type v2Error struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+		Reason  string `json:"reason"`
+	} `json:"error"`
+}
+
+func (c *Client) shareSnappass(ctx context.Context, secret string, opts ShareOptions) (string, error) {
+	if opts.Label != "" || !opts.NotBefore.IsZero() || len(opts.RestrictTo) > 0 {
+		return "", errors.New("the snappass API only supports a TTL")
+	}
+
+	ttl, err := ttlSeconds(opts.TTL)
+	if err != nil {
+		return "", err
+	}
+
+	resp := snappassResponse{}
+	if err := c.do(ctx, http.MethodPost, "api/set_password/", snappassRequest{Password: secret, TTL: ttl}, &resp); err != nil {
+		return "", err
+	}
+
+	return resp.Link, nil
+}
+
+func (c *Client) shareV2(ctx context.Context, req v2CreateRequest, opts ShareOptions) (string, error) {
+	ttl, err := ttlSeconds(opts.TTL)
+	if err != nil {
+		return "", err
+	}
+
+	req.TTL, req.Label, req.RestrictTo = ttl, opts.Label, opts.RestrictTo
+	if !opts.NotBefore.IsZero() {
+		req.NotBefore = &opts.NotBefore
+	}
+
+	resp := v2Secret{}
+	if err := c.do(ctx, http.MethodPost, "api/v2/secrets", req, &resp); err != nil {
+		return "", err
+	}
+
+	return resp.Link, nil
+}
+
+// ttlSeconds converts ttl to the whole seconds sent to the server. Zero means the
+// server's default.
+func ttlSeconds(ttl time.Duration) (int, error) {
+	if ttl == 0 {
+		return 0, nil
+	}
+
+	if ttl < time.Second || ttl > maxTTL || ttl%time.Second != 0 {
+		return 0, errors.New("the TTL must be whole seconds between 1 second and 2 weeks")
+	}
+
+	return int(math.Round(ttl.Seconds())), nil
+}
+
+// do sends body as JSON to the endpoint at path, relative to the server's base URL,
+// and decodes the JSON response into v.
+func (c *Client) do(ctx context.Context, method, path string, body, v any) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL.ResolveReference(&url.URL{Path: path}).String(), bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	if c.bearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.bearerToken)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode >= 300 {
+		apiErr := &Error{StatusCode: resp.StatusCode}
+		body := v2Error{}
+		if json.Unmarshal(respBody, &body) == nil && body.Error.Code != "" {
+			apiErr.Code, apiErr.Message, apiErr.Reason = body.Error.Code, body.Error.Message, body.Error.Reason
+		} else {
+			apiErr.Message = strings.TrimSpace(string(respBody))
+		}
+		return apiErr
+	}
+
+	return json.Unmarshal(respBody, v)
+}
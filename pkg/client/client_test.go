@@ -0,0 +1,266 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/concerthall/gosnappass/internal/apitoken"
+	"github.com/concerthall/gosnappass/internal/config"
+	"github.com/concerthall/gosnappass/internal/server"
+	"github.com/concerthall/gosnappass/pkg/client"
+)
+
+// testRedis is the database of the in-process servers of every test.
+var testRedis *miniredis.Miniredis
+
+func TestMain(m *testing.M) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		panic(err)
+	}
+	testRedis = mr
+	os.Setenv(config.EnvRedisURL, "redis://"+mr.Addr())
+
+	code := m.Run()
+	mr.Close()
+	os.Exit(code)
+}
+
+// newTestServer serves an in-process server with opts on an empty database until
+// the test ends, and returns its URL.
+func newTestServer(t *testing.T, opts ...server.ServerOption) string {
+	t.Helper()
+	testRedis.FlushAll()
+
+	srv := server.New(append([]server.ServerOption{server.LogTo(io.Discard)}, opts...)...)
+	ts := httptest.NewServer(srv.Handler())
+	t.Cleanup(ts.Close)
+	return ts.URL
+}
+
+// newTestClient returns a client of the server at serverURL.
+func newTestClient(t *testing.T, serverURL string, opts ...client.Option) *client.Client {
+	t.Helper()
+	c, err := client.New(serverURL, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestShareAndReveal(t *testing.T) {
+	ctx := context.Background()
+	for _, style := range []string{server.LinkStyleLong, server.LinkStyleShort, server.LinkStyleWords} {
+		t.Run(style, func(t *testing.T) {
+			serverURL := newTestServer(t, server.WithLinkStyle(style))
+			c := newTestClient(t, serverURL)
+
+			link, err := c.Share(ctx, "hunter2", client.ShareOptions{TTL: time.Hour, Label: "database password"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(link, serverURL+"/") {
+				t.Errorf("link %q is not on the server %s", link, serverURL)
+			}
+
+			secret, err := c.Reveal(ctx, link)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if secret.Text != "hunter2" || secret.Label != "database password" || secret.Fields != nil {
+				t.Errorf("revealed %+v", secret)
+			}
+
+			_, err = c.Reveal(ctx, link)
+			if !errors.Is(err, client.ErrGone) {
+				t.Errorf("revealing again: got error %v, want ErrGone", err)
+			}
+
+			apiErr := &client.Error{}
+			if !errors.As(err, &apiErr) || apiErr.Code != "gone" || apiErr.Reason != "revealed" {
+				t.Errorf("revealing again: got error %#v", err)
+			}
+		})
+	}
+}
+
+func TestRevealToken(t *testing.T) {
+	ctx := context.Background()
+	c := newTestClient(t, newTestServer(t))
+
+	link, err := c.Share(ctx, "hunter2", client.ShareOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret, err := c.Reveal(ctx, link[strings.LastIndex(link, "/")+1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.Text != "hunter2" {
+		t.Errorf("revealed %q", secret.Text)
+	}
+}
+
+func TestShareFields(t *testing.T) {
+	ctx := context.Background()
+	c := newTestClient(t, newTestServer(t))
+
+	fields := []client.Field{{Name: "username", Value: "admin"}, {Name: "password", Value: "hunter2"}}
+	link, err := c.ShareFields(ctx, fields, client.ShareOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret, err := c.Reveal(ctx, link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.Text != "" || !reflect.DeepEqual(secret.Fields, fields) {
+		t.Errorf("revealed %+v", secret)
+	}
+}
+
+func TestRevealUnknownSecret(t *testing.T) {
+	ctx := context.Background()
+	c := newTestClient(t, newTestServer(t))
+
+	link, err := c.Share(ctx, "hunter2", client.ShareOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testRedis.FlushAll()
+
+	_, err = c.Reveal(ctx, link)
+	if !errors.Is(err, client.ErrNotFound) {
+		t.Errorf("got error %v, want ErrNotFound", err)
+	}
+}
+
+func TestSnappassAPI(t *testing.T) {
+	ctx := context.Background()
+	serverURL := newTestServer(t)
+	c := newTestClient(t, serverURL, client.WithSnappassAPI())
+
+	link, err := c.Share(ctx, "hunter2", client.ShareOptions{TTL: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.Reveal(ctx, link); err == nil {
+		t.Error("revealed a secret with the snappass API")
+	}
+
+	if _, err := c.Share(ctx, "hunter2", client.ShareOptions{Label: "database password"}); err == nil {
+		t.Error("shared a labelled secret with the snappass API")
+	}
+
+	if _, err := c.ShareFields(ctx, []client.Field{{Name: "password", Value: "hunter2"}}, client.ShareOptions{}); err == nil {
+		t.Error("shared fields with the snappass API")
+	}
+
+	secret, err := newTestClient(t, serverURL).Reveal(ctx, link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.Text != "hunter2" {
+		t.Errorf("revealed %q", secret.Text)
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	ctx := context.Background()
+	tokensFile := filepath.Join(t.TempDir(), "tokens.json")
+	token, err := apitoken.Mint(tokensFile, "ci", []string{apitoken.ScopeCreate})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := apitoken.NewStore(tokensFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverURL := newTestServer(t, server.WithAPITokens(store))
+
+	_, err = newTestClient(t, serverURL).Share(ctx, "hunter2", client.ShareOptions{})
+	apiErr := &client.Error{}
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized || apiErr.Code != "unauthorized" {
+		t.Errorf("without a token: got error %v", err)
+	}
+
+	c := newTestClient(t, serverURL, client.WithBearerToken(token))
+	link, err := c.Share(ctx, "hunter2", client.ShareOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = c.Reveal(ctx, link)
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusForbidden {
+		t.Errorf("revealing without the reveal scope: got error %v", err)
+	}
+}
+
+func TestShareRejectsInvalidOptions(t *testing.T) {
+	ctx := context.Background()
+	c := newTestClient(t, "https://snappass.example.com/")
+
+	for _, ttl := range []time.Duration{time.Millisecond, 1500 * time.Millisecond, 15 * 24 * time.Hour} {
+		if _, err := c.Share(ctx, "hunter2", client.ShareOptions{TTL: ttl}); err == nil {
+			t.Errorf("shared a secret with a TTL of %s", ttl)
+		}
+	}
+
+	if _, err := c.Share(ctx, "", client.ShareOptions{}); err == nil {
+		t.Error("shared an empty secret")
+	}
+
+	if _, err := c.ShareFields(ctx, nil, client.ShareOptions{}); err == nil {
+		t.Error("shared no fields")
+	}
+
+	if _, err := client.New("ftp://snappass.example.com/"); err == nil {
+		t.Error("created a client of an ftp server")
+	}
+}
+
+func TestParseLink(t *testing.T) {
+	words := "able-acid-aged-also-area-army-away-baby-back-ball-band-bank-base-bath-bear-beat-been-beer-bell-belt"
+	tests := []struct {
+		link    string
+		id, key string
+	}{
+		{"https://snappass.example.com/snappassabc~a2V5", "snappassabc", "a2V5"},
+		{"https://snappass.example.com/prefix/snappassabc~a2V5/", "snappassabc", "a2V5"},
+		{"snappassabc~a2V5", "snappassabc", "a2V5"},
+		{"snappass%7Eabc~a2V5", "snappass~abc", "a2V5"},
+		{"https://snappass.example.com/3mJr7AoUXx2Wqd-5zN8sxn1UFpn", "3mJr7AoUXx2Wqd", "5zN8sxn1UFpn"},
+		{"https://snappass.example.com/" + words, "able-acid-aged-also-area-army-away-baby", "back-ball-band-bank-base-bath-bear-beat-been-beer-bell-belt"},
+		{words, "able-acid-aged-also-area-army-away-baby", "back-ball-band-bank-base-bath-bear-beat-been-beer-bell-belt"},
+	}
+
+	for _, tt := range tests {
+		id, key, err := client.ParseLink(tt.link)
+		if err != nil {
+			t.Errorf("ParseLink(%q): %s", tt.link, err)
+			continue
+		}
+		if id != tt.id || key != tt.key {
+			t.Errorf("ParseLink(%q) = %q, %q, want %q, %q", tt.link, id, key, tt.id, tt.key)
+		}
+	}
+
+	for _, link := range []string{"https://snappass.example.com/", "a~b~c", "a-b-c", "able-acid-aged-also-back-ball-band-bank-base-bath-bear-beat-been-beer-bell-belt"} {
+		if _, _, err := client.ParseLink(link); err == nil {
+			t.Errorf("ParseLink(%q) succeeded", link)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+// Package client shares and reveals secrets through the HTTP APIs of a gosnappass
+// server.
+//
+// Share a secret, and send the returned link to its recipient:
+//
+//	c, err := client.New("https://snappass.example.com/")
+//	if err != nil {
+//		return err
+//	}
+//
+//	link, err := c.Share(ctx, "hunter2", client.ShareOptions{
+//		TTL:   time.Hour,
+//		Label: "database password",
+//	})
+//
+// Reveal a secret from its link. This deletes the secret from the server:
+//
+//	secret, err := c.Reveal(ctx, link)
+//	if errors.Is(err, client.ErrGone) {
+//		// the secret expired, was revealed, or was deleted.
+//	}
+//	fmt.Println(secret.Text)
+//
+// Servers that require API clients to authenticate accept a bearer token:
+//
+//	c, err := client.New("https://snappass.example.com/", client.WithBearerToken(os.Getenv("SNAPPASS_TOKEN")))
+//
+// Secrets are shared with the v2 API by default. WithSnappassAPI shares them with
+// the snappass compatible API instead, which the original pinterest/snappass also
+// serves. That API can't reveal secrets, so clients created with WithSnappassAPI
+// only share them.
+package client
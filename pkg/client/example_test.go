@@ -0,0 +1,97 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/concerthall/gosnappass/pkg/client"
+)
+
+func ExampleClient_Share() {
+	c, err := client.New("https://snappass.example.com/", client.WithBearerToken(os.Getenv("SNAPPASS_TOKEN")))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	link, err := c.Share(context.Background(), "hunter2", client.ShareOptions{
+		TTL:   time.Hour,
+		Label: "database password",
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Println(link)
+}
+
+func ExampleClient_ShareFields() {
+	c, err := client.New("https://snappass.example.com/")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	link, err := c.ShareFields(context.Background(), []client.Field{
+		{Name: "username", Value: "admin"},
+		{Name: "password", Value: "hunter2"},
+	}, client.ShareOptions{Label: "admin account"})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Println(link)
+}
+
+func ExampleClient_Reveal() {
+	c, err := client.New("https://snappass.example.com/")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	secret, err := c.Reveal(context.Background(), "https://snappass.example.com/3mJr7AoUXx2Wqd-5zN8sxn1UFpn")
+	if errors.Is(err, client.ErrGone) {
+		log.Fatal("the secret expired, was revealed, or was deleted")
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if secret.Fields != nil {
+		for _, field := range secret.Fields {
+			fmt.Printf("%s: %s\n", field.Name, field.Value)
+		}
+		return
+	}
+	fmt.Println(secret.Text)
+}
+
+func ExampleWithSnappassAPI() {
+	// The original pinterest/snappass only serves the snappass compatible API.
+	c, err := client.New("https://snappass.example.com/", client.WithSnappassAPI())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	link, err := c.Share(context.Background(), "hunter2", client.ShareOptions{TTL: time.Hour})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Println(link)
+}
+
+func ExampleParseLink() {
+	id, key, err := client.ParseLink("https://snappass.example.com/3mJr7AoUXx2Wqd-5zN8sxn1UFpn")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Println(id)
+	fmt.Println(key)
+	// Output:
+	// 3mJr7AoUXx2Wqd
+	// 5zN8sxn1UFpn
+}